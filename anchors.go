@@ -7,6 +7,7 @@
 package goatapi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/netip"
@@ -152,7 +153,7 @@ func (filter *AnchorFilter) FilterASN6(as uint) {
 	filter.params.Add("as_v6", fmt.Sprint(as))
 }
 
-// Limit limits the number of result retrieved
+// Limit limits the number of result retrieved; 0 (the default) means no limit
 func (filter *AnchorFilter) Limit(max uint) {
 	filter.limit = max
 }
@@ -205,6 +206,14 @@ func (filter *AnchorFilter) GetAnchorCount() (
 // Results (or an error) appear on a channel
 func (filter *AnchorFilter) GetAnchors(
 	anchors chan AsyncAnchorResult,
+) {
+	filter.getAnchors(context.Background(), anchors)
+}
+
+// getAnchors is GetAnchors that stops when the context is cancelled
+func (filter *AnchorFilter) getAnchors(
+	ctx context.Context,
+	anchors chan AsyncAnchorResult,
 ) {
 	defer close(anchors)
 
@@ -212,49 +221,52 @@ func (filter *AnchorFilter) GetAnchors(
 	if filter.id != 0 {
 		anchor, err := GetAnchor(filter.verbose, filter.id)
 		if err != nil {
-			anchors <- AsyncAnchorResult{Anchor{}, err}
+			send(ctx, anchors, AsyncAnchorResult{Anchor{}, err})
 			return
 		}
-		anchors <- AsyncAnchorResult{*anchor, nil}
+		send(ctx, anchors, AsyncAnchorResult{*anchor, nil})
 		return
 	}
 
 	// sanity checks - late in the process, but not too late
 	err := filter.verifyFilters()
 	if err != nil {
-		anchors <- AsyncAnchorResult{Anchor{}, err}
+		send(ctx, anchors, AsyncAnchorResult{Anchor{}, err})
 		return
 	}
 
 	query := apiBaseURL + "anchors/?" + filter.params.Encode()
 
-	resp, err := apiGetRequest(filter.verbose, query, nil)
+	resp, err := apiGetRequestContext(ctx, filter.verbose, query, nil)
 
 	// results are paginated with next= (and previous=)
 	var total uint = 0
 	for {
 		if err != nil {
-			anchors <- AsyncAnchorResult{Anchor{}, err}
+			send(ctx, anchors, AsyncAnchorResult{Anchor{}, err})
+			return
 		}
 		defer resp.Body.Close()
 
 		if resp.StatusCode != 200 {
-			anchors <- AsyncAnchorResult{Anchor{}, err}
+			send(ctx, anchors, AsyncAnchorResult{Anchor{}, err})
 			return
 		}
 
 		// grab and store the actual content
 		var page anchorListingPage
 		err = json.NewDecoder(resp.Body).Decode(&page)
-		if err != nil {
-			anchors <- AsyncAnchorResult{Anchor{}, err}
+		if err != nil && !send(ctx, anchors, AsyncAnchorResult{Anchor{}, err}) {
+			return
 		}
 
 		// return items while observing the limit
 		for _, anchor := range page.Anchors {
-			anchors <- AsyncAnchorResult{anchor, nil}
+			if !send(ctx, anchors, AsyncAnchorResult{anchor, nil}) {
+				return
+			}
 			total++
-			if total >= filter.limit {
+			if filter.limit != 0 && total >= filter.limit {
 				return
 			}
 		}
@@ -265,7 +277,7 @@ func (filter *AnchorFilter) GetAnchors(
 		}
 
 		// just follow the next link
-		resp, err = apiGetRequest(filter.verbose, page.Next, nil)
+		resp, err = apiGetRequestContext(ctx, filter.verbose, page.Next, nil)
 	}
 }
 
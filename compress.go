@@ -0,0 +1,122 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression identifies a compression method for result files
+type Compression uint
+
+// supported compression methods
+const (
+	CompressionNone  Compression = iota // 0
+	CompressionGzip                     // 1
+	CompressionBzip2                    // 2 (read only)
+	CompressionXz                       // 3
+	CompressionZstd                     // 4
+)
+
+// CompressionDict maps the compression methods to human readable names
+var CompressionDict = map[Compression]string{
+	CompressionNone:  "none",
+	CompressionGzip:  "gzip",
+	CompressionBzip2: "bzip2",
+	CompressionXz:    "xz",
+	CompressionZstd:  "zstd",
+}
+
+// magic bytes at the start of compressed streams
+var compressionMagic = []struct {
+	compression Compression
+	magic       []byte
+}{
+	{CompressionGzip, []byte{0x1f, 0x8b}},
+	{CompressionBzip2, []byte("BZh")},
+	{CompressionXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{CompressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// detectCompression peeks into a buffered reader and tells what kind of
+// compression is used, based on the magic bytes at the start of the stream
+func detectCompression(reader *bufio.Reader) Compression {
+	for _, cm := range compressionMagic {
+		head, _ := reader.Peek(len(cm.magic))
+		if bytes.Equal(head, cm.magic) {
+			return cm.compression
+		}
+	}
+	return CompressionNone
+}
+
+// decompressingReader wraps a reader with the appropriate decompressor,
+// after auto-detecting the compression method used
+// The returned closer needs to be called when the reader is no longer used
+func decompressingReader(from io.Reader) (io.Reader, func() error, error) {
+	buffered := bufio.NewReader(from)
+	noop := func() error { return nil }
+
+	switch detectCompression(buffered) {
+	case CompressionGzip:
+		reader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, nil, err
+		}
+		return reader, reader.Close, nil
+	case CompressionBzip2:
+		return bzip2.NewReader(buffered), noop, nil
+	case CompressionXz:
+		reader, err := xz.NewReader(buffered)
+		if err != nil {
+			return nil, nil, err
+		}
+		return reader, noop, nil
+	case CompressionZstd:
+		reader, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, nil, err
+		}
+		return reader, func() error { reader.Close(); return nil }, nil
+	default:
+		return buffered, noop, nil
+	}
+}
+
+// compressingWriter wraps a writer with a compressor of the specified kind
+// The returned writer has to be closed to flush all data
+func compressingWriter(to io.Writer, compression Compression) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionNone:
+		return nopWriteCloser{to}, nil
+	case CompressionGzip:
+		return gzip.NewWriter(to), nil
+	case CompressionXz:
+		return xz.NewWriter(to)
+	case CompressionZstd:
+		return zstd.NewWriter(to)
+	default:
+		return nil, fmt.Errorf("writing %s compressed output is not supported", CompressionDict[compression])
+	}
+}
+
+// a WriteCloser that doesn't need closing
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -7,6 +7,7 @@
 package goatapi
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -55,7 +56,18 @@ func apiGetRequest(
 	url string,
 	key *uuid.UUID,
 ) (*http.Response, error) {
-	req, err := http.NewRequest("GET", url, nil)
+	return apiGetRequestContext(context.Background(), verbose, url, key)
+}
+
+// apiGetRequestContext is like apiGetRequest, but the request is aborted
+// when the context is cancelled
+func apiGetRequestContext(
+	ctx context.Context,
+	verbose bool,
+	url string,
+	key *uuid.UUID,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -75,3 +87,14 @@ func apiGetRequest(
 
 	return client.Do(req)
 }
+
+// send puts an item on a channel, unless the context is cancelled first
+// (e.g. the reader went away); it returns false in the latter case
+func send[T any](ctx context.Context, items chan T, item T) bool {
+	select {
+	case items <- item:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,150 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"context"
+
+	"github.com/robert-kisteleki/goatapi/result"
+)
+
+// Iterator walks through items (probes, anchors, measurements, results)
+// one by one, as an alternative to reading them from a channel:
+//
+//	it := filter.IterateProbes()
+//	defer it.Close()
+//	for it.Next() {
+//		if it.Err() != nil {
+//			// handle the error
+//			continue
+//		}
+//		probe := it.Result()
+//	}
+//
+// Unlike bufio.Scanner, an error does not necessarily end the iteration:
+// some errors (e.g. an unparseable result) only concern one item
+type Iterator[T any] struct {
+	next   func() (T, error, bool)
+	stop   func()
+	result T
+	err    error
+	done   bool
+}
+
+// newChannelIterator makes an iterator that reads from a channel of
+// async items, using split to separate the item from its error
+// The producer has to close the channel when it's done, and should stop
+// early if cancel is called
+func newChannelIterator[A any, T any](
+	items chan A,
+	cancel context.CancelFunc,
+	split func(A) (T, error),
+) *Iterator[T] {
+	return &Iterator[T]{
+		next: func() (T, error, bool) {
+			item, ok := <-items
+			if !ok {
+				var zero T
+				return zero, nil, false
+			}
+			res, err := split(item)
+			return res, err, true
+		},
+		stop: func() {
+			// tell the producer to stop, and wait until it did
+			cancel()
+			for range items {
+			}
+		},
+	}
+}
+
+// Next advances to the next item; it returns false if there are no more
+func (it *Iterator[T]) Next() bool {
+	if it.done {
+		return false
+	}
+	var ok bool
+	it.result, it.err, ok = it.next()
+	if !ok {
+		it.done = true
+	}
+	return ok
+}
+
+// Result returns the current item
+func (it *Iterator[T]) Result() T {
+	return it.result
+}
+
+// Err returns the error for the current item, if there was one
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Close stops the iteration; it is needed if not all items were read
+// It stops the producer (e.g. no more pages are fetched, the stream is
+// closed) and returns once it's finished
+func (it *Iterator[T]) Close() {
+	if !it.done {
+		it.done = true
+		it.stop()
+	}
+}
+
+// IterateProbes returns an iterator over the probes matching the filter
+func (filter *ProbeFilter) IterateProbes() *Iterator[Probe] {
+	probes := make(chan AsyncProbeResult)
+	ctx, cancel := context.WithCancel(context.Background())
+	go filter.getProbes(ctx, probes)
+	return newChannelIterator(probes, cancel, func(p AsyncProbeResult) (Probe, error) {
+		return p.Probe, p.Error
+	})
+}
+
+// IterateAnchors returns an iterator over the anchors matching the filter
+func (filter *AnchorFilter) IterateAnchors() *Iterator[Anchor] {
+	anchors := make(chan AsyncAnchorResult)
+	ctx, cancel := context.WithCancel(context.Background())
+	go filter.getAnchors(ctx, anchors)
+	return newChannelIterator(anchors, cancel, func(a AsyncAnchorResult) (Anchor, error) {
+		return a.Anchor, a.Error
+	})
+}
+
+// IterateMeasurements returns an iterator over the measurements matching the filter
+func (filter *MeasurementFilter) IterateMeasurements() *Iterator[Measurement] {
+	measurements := make(chan AsyncMeasurementResult)
+	ctx, cancel := context.WithCancel(context.Background())
+	go filter.getMeasurements(ctx, measurements)
+	return newChannelIterator(measurements, cancel, func(m AsyncMeasurementResult) (Measurement, error) {
+		return m.Measurement, m.Error
+	})
+}
+
+// IterateResults returns an iterator over the results matching the filter
+func (filter *ResultsFilter) IterateResults(verbose bool) *Iterator[result.Result] {
+	results := make(chan result.AsyncResult)
+	ctx, cancel := context.WithCancel(context.Background())
+	go filter.getResults(ctx, verbose, results)
+	return newChannelIterator(results, cancel, func(r result.AsyncResult) (result.Result, error) {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		return *r.Result, nil
+	})
+}
+
+// IterateResultsOf returns an iterator over the results matching the
+// filter, narrowed down to a specific result type, e.g. *result.PingResult.
+// Results of other types are reported as errors
+func IterateResultsOf[T result.Result](filter *ResultsFilter, verbose bool) *Iterator[T] {
+	results := make(chan result.AsyncResult)
+	ctx, cancel := context.WithCancel(context.Background())
+	go filter.getResults(ctx, verbose, results)
+	return newChannelIterator(results, cancel, result.As[T])
+}
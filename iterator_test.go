@@ -0,0 +1,158 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/robert-kisteleki/goatapi/result"
+)
+
+// Test if typed result iterators narrow down results properly
+func TestTypedResultIterator(t *testing.T) {
+	filter := NewResultsFilter()
+	filter.FilterFile("testdata/ping.txt")
+	it := IterateResultsOf[*result.PingResult](&filter, false)
+	n := 0
+	for it.Next() {
+		if it.Err() != nil {
+			t.Fatalf("unexpected error: %v", it.Err())
+		}
+		if it.Result().Sent != 3 {
+			t.Errorf("unexpected ping result: %+v", it.Result())
+		}
+		n++
+	}
+	assertEqual(t, n, 3, "wrong number of ping results")
+
+	other := NewResultsFilter()
+	other.FilterFile("testdata/ping.txt")
+	mismatch := IterateResultsOf[*result.DnsResult](&other, false)
+	if !mismatch.Next() || mismatch.Err() == nil || mismatch.Result() != nil {
+		t.Errorf("type mismatch was not reported")
+	}
+	mismatch.Close()
+	if mismatch.Next() {
+		t.Errorf("iteration should end after Close()")
+	}
+
+	dnsfilter := NewResultsFilter()
+	dnsfilter.FilterFile("testdata/dns.txt")
+	results := make(chan result.AsyncResult)
+	go dnsfilter.GetResults(false, results)
+	n = 0
+	for dns := range result.Typed[*result.DnsResult](results) {
+		if dns.Error != nil {
+			t.Fatalf("unexpected error: %v", dns.Error)
+		}
+		n += len(dns.Result.Responses)
+	}
+	assertEqual(t, n, 3, "wrong number of DNS responses")
+}
+
+// Test if probes can be iterated over, across pages
+func TestProbeIterator(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(w, `{"count":3,"next":null,"results":[{"id":3}]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"count":3,"next":"%s/probes/?page=2","results":[{"id":1},{"id":2}]}`, server.URL)
+	}))
+	defer server.Close()
+	oldbase := apiBaseURL
+	SetAPIBase(server.URL + "/")
+	defer SetAPIBase(oldbase)
+
+	filter := NewProbeFilter()
+	filter.Limit(10)
+	it := filter.IterateProbes()
+	defer it.Close()
+	ids := make([]uint, 0)
+	for it.Next() {
+		if it.Err() != nil {
+			t.Fatalf("unexpected error: %v", it.Err())
+		}
+		ids = append(ids, it.Result().ID)
+	}
+	assertEqual(t, fmt.Sprint(ids), "[1 2 3]", "wrong probes from iterator")
+}
+
+// Test if closing an iterator early stops the producer
+func TestIteratorClose(t *testing.T) {
+	var server *httptest.Server
+	var pages atomic.Int32
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages.Add(1)
+		fmt.Fprintf(w, `{"count":6,"next":"%s/probes/?page=2","results":[{"id":1},{"id":2},{"id":3}]}`, server.URL)
+	}))
+	defer server.Close()
+	oldbase := apiBaseURL
+	SetAPIBase(server.URL + "/")
+	defer SetAPIBase(oldbase)
+
+	filter := NewProbeFilter()
+	it := filter.IterateProbes()
+	if !it.Next() || it.Err() != nil {
+		t.Fatalf("first probe is missing")
+	}
+	it.Close()
+	assertEqual(t, pages.Load(), int32(1), "next page was fetched after Close()")
+
+	// a stream that never ends on its own
+	line, err := os.ReadFile("testdata/ping.txt")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	ping := strings.SplitN(string(line), "\n", 2)[0]
+	gone := make(chan struct{})
+	stream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.ReadMessage() // subscription
+		conn.WriteMessage(websocket.TextMessage, []byte(`["atlas_subscribed",{}]`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`["atlas_result",`+ping+`]`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`["atlas_result",`+ping+`]`))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				close(gone)
+				return
+			}
+		}
+	}))
+	defer stream.Close()
+	oldstream := streamBaseURL
+	SetStreamBase("ws" + strings.TrimPrefix(stream.URL, "http"))
+	defer SetStreamBase(oldstream)
+
+	results := NewResultsFilter()
+	results.FilterID(1001)
+	results.Stream(true)
+	sit := results.IterateResults(false)
+	if !sit.Next() || sit.Err() != nil {
+		t.Fatalf("first streamed result is missing: %v", sit.Err())
+	}
+	sit.Close()
+	select {
+	case <-gone:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Close() did not stop the stream")
+	}
+}
@@ -0,0 +1,238 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// resultReader reads results one by one from some input
+// next() returns the results as a single line JSON string, io.EOF at the
+// end of the input, *ResultTooLongError for skipped results or any other
+// error, after which reading cannot continue
+type resultReader interface {
+	next() (string, error)
+}
+
+// newResultReader makes a result reader that suits the input format:
+// either one JSON object per line, a JSON array of results or a stream of
+// (possibly pretty-printed) JSON objects. The format is auto-detected.
+// A max of 0 means DefaultMaxResultSize
+func newResultReader(from io.Reader, source string, max int) resultReader {
+	buffered := bufio.NewReaderSize(from, 64*1024)
+
+	switch detectJSONLayout(buffered) {
+	case jsonLayoutArray:
+		return newJSONReader(buffered, source, max, true)
+	case jsonLayoutStream:
+		return newJSONReader(buffered, source, max, false)
+	default:
+		return newLineReader(buffered, source, max)
+	}
+}
+
+// ways to lay out results in an input
+const (
+	jsonLayoutLines  = iota // one result per line
+	jsonLayoutArray         // one big JSON array
+	jsonLayoutStream        // concatenated JSON objects, not necessarily one per line
+)
+
+// detectJSONLayout peeks into the start of the input to determine how the
+// results are laid out. If the first line is a complete JSON object, or the
+// first line is longer than what we can peek into, then it's one result
+// per line
+func detectJSONLayout(reader *bufio.Reader) int {
+	start := -1
+	for n := 1; n <= reader.Size(); n++ {
+		head, err := reader.Peek(n)
+		if len(head) < n {
+			if start >= 0 && json.Valid(bytes.TrimSpace(head[start:])) {
+				return jsonLayoutLines
+			}
+			if start >= 0 && err == io.EOF {
+				return jsonLayoutStream
+			}
+			return jsonLayoutLines
+		}
+		c := head[n-1]
+		if start < 0 {
+			switch c {
+			case ' ', '\t', '\r', '\n':
+				continue
+			case '[':
+				return jsonLayoutArray
+			case '{':
+				start = n - 1
+				continue
+			default:
+				// not JSON, let the line reader report that
+				return jsonLayoutLines
+			}
+		}
+		if c == '\n' {
+			if json.Valid(head[start:n]) {
+				return jsonLayoutLines
+			}
+			return jsonLayoutStream
+		}
+	}
+	return jsonLayoutLines
+}
+
+// jsonReader reads results from a JSON array or from a stream of JSON
+// objects, one by one, without loading all of them into memory
+// Like lineReader, it keeps at most max bytes of a result, so it can skip
+// over results that are too long and carry on with the next one
+type jsonReader struct {
+	reader  *bufio.Reader
+	source  string
+	max     int
+	array   bool
+	started bool
+	done    bool
+	index   uint
+	offset  int64
+}
+
+func newJSONReader(from *bufio.Reader, source string, max int, array bool) *jsonReader {
+	if max <= 0 {
+		max = DefaultMaxResultSize
+	}
+	return &jsonReader{
+		reader: from,
+		source: source,
+		max:    max,
+		array:  array,
+	}
+}
+
+// readByte reads the next byte, keeping track of the offset
+func (jr *jsonReader) readByte() (byte, error) {
+	c, err := jr.reader.ReadByte()
+	if err == nil {
+		jr.offset++
+	}
+	return c, err
+}
+
+// skipSpace reads the next byte that is not white space
+func (jr *jsonReader) skipSpace() (byte, error) {
+	for {
+		c, err := jr.readByte()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return c, nil
+	}
+}
+
+func (jr *jsonReader) next() (string, error) {
+	if jr.done {
+		return "", io.EOF
+	}
+
+	// fail is used on errors from which there's no recovery
+	fail := func(err error) (string, error) {
+		jr.done = true
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", fmt.Errorf("error reading %s at result %d (offset %d): %v",
+			jr.source, jr.index+1, jr.offset, err)
+	}
+
+	c, err := jr.skipSpace()
+	if jr.array {
+		// the opening [ before the first result, a , or the closing ] after others
+		expected := byte(',')
+		if !jr.started {
+			expected = '['
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if c != expected && !(c == ']' && jr.started) {
+			return fail(fmt.Errorf("expected %q instead of %q", expected, c))
+		}
+		if c == '[' {
+			c, err = jr.skipSpace()
+			if err != nil {
+				return fail(err)
+			}
+		}
+		if c == ']' {
+			jr.done = true
+			return "", io.EOF
+		}
+		if c == ',' {
+			c, err = jr.skipSpace()
+			if err != nil {
+				return fail(err)
+			}
+		}
+	} else if err == io.EOF {
+		jr.done = true
+		return "", io.EOF
+	} else if err != nil {
+		return fail(err)
+	}
+	jr.started = true
+	if c != '{' {
+		return fail(fmt.Errorf("expected a JSON object instead of %q", c))
+	}
+
+	// find the end of the object, keeping no more than max bytes of it
+	start := jr.offset - 1
+	raw := []byte{c}
+	size := 1
+	depth := 1
+	inString, escaped := false, false
+	for depth > 0 {
+		c, err = jr.readByte()
+		if err != nil {
+			return fail(err)
+		}
+		size++
+		if size <= jr.max {
+			raw = append(raw, c)
+		}
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case inString:
+			inString = c != '"'
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	jr.index++
+	if size > jr.max {
+		return "", &ResultTooLongError{jr.source, jr.index, start, size, jr.max}
+	}
+
+	// results are passed on (and saved) as one line each
+	var line bytes.Buffer
+	if err := json.Compact(&line, raw); err != nil {
+		return fail(err)
+	}
+	return line.String(), nil
+}
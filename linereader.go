@@ -0,0 +1,103 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxResultSize is the default limit for the size of one result (line)
+const DefaultMaxResultSize = 16 * 1024 * 1024
+
+// ResultTooLongError is reported for results that are larger than
+// the allowed maximum; such results are skipped
+type ResultTooLongError struct {
+	Source string // where the result came from (file name, stdin, API)
+	Line   uint   // line number in the source, or result number in a JSON array (1-based)
+	Offset int64  // byte offset of the start of the line in the source
+	Size   int    // size of the line in bytes
+	Max    int    // the maximum allowed size
+}
+
+func (e *ResultTooLongError) Error() string {
+	return fmt.Sprintf("result at %s line %d (offset %d) is %d bytes, longer than the maximum of %d; skipped",
+		e.Source, e.Line, e.Offset, e.Size, e.Max)
+}
+
+// lineReader reads lines of bounded length from a reader
+// Unlike bufio.Scanner it can skip over lines that are too long and
+// report where they are, then carry on with the next line
+type lineReader struct {
+	reader *bufio.Reader
+	source string
+	max    int
+	line   uint
+	offset int64
+}
+
+// newLineReader makes a line reader with a maximum line length of max
+// bytes. A max of 0 means DefaultMaxResultSize
+func newLineReader(from io.Reader, source string, max int) *lineReader {
+	if max <= 0 {
+		max = DefaultMaxResultSize
+	}
+	return &lineReader{
+		reader: bufio.NewReaderSize(from, 64*1024),
+		source: source,
+		max:    max,
+	}
+}
+
+// next returns the next line without the line terminator
+// It returns io.EOF at the end of the input, a *ResultTooLongError if the
+// line was too long (in which case reading can continue) or any other
+// error from the underlying reader
+func (lr *lineReader) next() (string, error) {
+	line := make([]byte, 0)
+	size := 0
+	start := lr.offset
+	for {
+		chunk, err := lr.reader.ReadSlice('\n')
+		size += len(chunk)
+		lr.offset += int64(len(chunk))
+		line = append(line, chunk...)
+		if size > lr.max+2 { // +2 for the line terminator
+			// too long anyway: only keep the end, for the line terminator
+			line = append(line[:0], line[len(line)-2:]...)
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("error reading %s at line %d: %v", lr.source, lr.line+1, err)
+		}
+		if err == io.EOF && size == 0 {
+			return "", io.EOF
+		}
+		break
+	}
+	lr.line++
+
+	// strip line terminators; only now that the line is complete, as the
+	// \r and \n of a \r\n can be in different chunks
+	terminator := 0
+	if bytes.HasSuffix(line, []byte("\r\n")) {
+		terminator = 2
+	} else if bytes.HasSuffix(line, []byte("\n")) {
+		terminator = 1
+	}
+	size -= terminator
+
+	if size > lr.max {
+		return "", &ResultTooLongError{lr.source, lr.line, start, size, lr.max}
+	}
+	return string(line[:size]), nil
+}
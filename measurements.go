@@ -7,6 +7,7 @@
 package goatapi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -396,7 +397,7 @@ func (filter *MeasurementFilter) Sort(by string) {
 	filter.params.Add("sort", by)
 }
 
-// Limit limits the number of result retrieved
+// Limit limits the number of result retrieved; 0 (the default) means no limit
 func (filter *MeasurementFilter) Limit(limit uint) {
 	filter.limit = limit
 }
@@ -511,6 +512,14 @@ func (filter *MeasurementFilter) GetMeasurementCount() (
 // Results (or an error) appear on a channel
 func (filter *MeasurementFilter) GetMeasurements(
 	measurements chan AsyncMeasurementResult,
+) {
+	filter.getMeasurements(context.Background(), measurements)
+}
+
+// getMeasurements is GetMeasurements that stops when the context is cancelled
+func (filter *MeasurementFilter) getMeasurements(
+	ctx context.Context,
+	measurements chan AsyncMeasurementResult,
 ) {
 	defer close(measurements)
 
@@ -518,17 +527,17 @@ func (filter *MeasurementFilter) GetMeasurements(
 	if filter.id != 0 {
 		msm, err := GetMeasurement(filter.verbose, filter.id, filter.key)
 		if err != nil {
-			measurements <- AsyncMeasurementResult{Measurement{}, err}
+			send(ctx, measurements, AsyncMeasurementResult{Measurement{}, err})
 			return
 		}
-		measurements <- AsyncMeasurementResult{*msm, nil}
+		send(ctx, measurements, AsyncMeasurementResult{*msm, nil})
 		return
 	}
 
 	// sanity checks - late in the process, but not too late
 	err := filter.verifyFilters()
 	if err != nil {
-		measurements <- AsyncMeasurementResult{Measurement{}, err}
+		send(ctx, measurements, AsyncMeasurementResult{Measurement{}, err})
 		return
 	}
 
@@ -538,19 +547,32 @@ func (filter *MeasurementFilter) GetMeasurements(
 	}
 	query += "?" + filter.params.Encode()
 
-	resp, err := apiGetRequest(filter.verbose, query, filter.key)
+	getMeasurementPages(ctx, filter.verbose, query, filter.key, filter.limit, measurements)
+}
+
+// getMeasurementPages returns the measurements from a listing query on a
+// channel, following the pages while observing the limit (0 means no limit)
+func getMeasurementPages(
+	ctx context.Context,
+	verbose bool,
+	query string,
+	key *uuid.UUID,
+	limit uint,
+	measurements chan AsyncMeasurementResult,
+) {
+	resp, err := apiGetRequestContext(ctx, verbose, query, key)
 
 	var total uint = 0
 	// results are paginated with next= (and previous=)
 	for {
 		if err != nil {
-			measurements <- AsyncMeasurementResult{Measurement{}, err}
+			send(ctx, measurements, AsyncMeasurementResult{Measurement{}, err})
 			return
 		}
 		defer resp.Body.Close()
 
 		if resp.StatusCode != 200 {
-			measurements <- AsyncMeasurementResult{Measurement{}, parseAPIError(resp)}
+			send(ctx, measurements, AsyncMeasurementResult{Measurement{}, parseAPIError(resp)})
 			return
 		}
 
@@ -558,15 +580,17 @@ func (filter *MeasurementFilter) GetMeasurements(
 		var page measurementListingPage
 		err = json.NewDecoder(resp.Body).Decode(&page)
 		if err != nil {
-			measurements <- AsyncMeasurementResult{Measurement{}, err}
+			send(ctx, measurements, AsyncMeasurementResult{Measurement{}, err})
 			return
 		}
 
 		// return items while observing the limit
 		for _, msm := range page.Measurements {
-			measurements <- AsyncMeasurementResult{msm, nil}
+			if !send(ctx, measurements, AsyncMeasurementResult{msm, nil}) {
+				return
+			}
 			total++
-			if total >= filter.limit {
+			if limit != 0 && total >= limit {
 				return
 			}
 		}
@@ -577,7 +601,7 @@ func (filter *MeasurementFilter) GetMeasurements(
 		}
 
 		// just follow the next link
-		resp, err = apiGetRequest(filter.verbose, page.Next, filter.key)
+		resp, err = apiGetRequestContext(ctx, verbose, page.Next, key)
 	}
 }
 
@@ -0,0 +1,201 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robert-kisteleki/goatapi/result"
+)
+
+// resultPipeline turns result strings into result objects and delivers
+// them to the results channel. Parsing happens either on the caller's
+// goroutine, or in a pool of workers if the filter asks for parallelism.
+// Filtering, counting and saving always happens on a single goroutine.
+type resultPipeline struct {
+	ctx     context.Context // cancelled if the reader went away
+	filter  *ResultsFilter
+	results chan result.AsyncResult
+	full    atomic.Bool // the limit was reached, no more results needed
+
+	// these are only used with parallel parsing
+	jobs      chan parseJob
+	parsed    chan parseJob
+	window    chan struct{} // limits the number of results in flight
+	workers   sync.WaitGroup
+	collected chan struct{}
+	seq       uint64
+}
+
+// one unit of work for the parser workers
+type parseJob struct {
+	seq  uint64
+	line string
+	hint string
+	res  result.Result
+	err  error
+}
+
+// newResultPipeline prepares a pipeline; finish() has to be called on it
+// once there is no more input
+func (filter *ResultsFilter) newResultPipeline(
+	ctx context.Context,
+	results chan result.AsyncResult,
+) *resultPipeline {
+	p := &resultPipeline{ctx: ctx, filter: filter, results: results}
+	if filter.parallelism <= 1 {
+		return p
+	}
+
+	workers := int(filter.parallelism)
+	p.jobs = make(chan parseJob, workers)
+	p.parsed = make(chan parseJob, workers)
+	p.window = make(chan struct{}, 4*workers)
+	p.collected = make(chan struct{})
+
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.parseWorker()
+	}
+	go p.collect()
+
+	return p
+}
+
+// done tells if the pipeline doesn't need more input
+func (p *resultPipeline) done() bool {
+	return p.full.Load() || p.ctx.Err() != nil
+}
+
+// submit puts a result string into the pipeline
+func (p *resultPipeline) submit(line string) {
+	if p.filter.saveAll {
+		p.filter.saveResult(p.ctx, line, p.results)
+	}
+
+	// until we know what type of results we're dealing with, parse in line
+	if p.jobs == nil || p.filter.typehint == "" {
+		res, err := p.parse(line, p.filter.typehint)
+		p.deliver(parseJob{line: line, res: res, err: err})
+		return
+	}
+
+	p.window <- struct{}{}
+	p.jobs <- parseJob{seq: p.seq, line: line, hint: p.filter.typehint}
+	p.seq++
+}
+
+// submitError puts an error into the pipeline, in order with the results
+func (p *resultPipeline) submitError(err error) {
+	if p.jobs == nil || p.seq == 0 {
+		send(p.ctx, p.results, result.AsyncResult{Result: nil, Error: err})
+		return
+	}
+
+	p.window <- struct{}{}
+	p.jobs <- parseJob{seq: p.seq, err: err}
+	p.seq++
+}
+
+// finish waits for all results to be delivered
+func (p *resultPipeline) finish() {
+	if p.jobs == nil {
+		return
+	}
+	close(p.jobs)
+	p.workers.Wait()
+	close(p.parsed)
+	<-p.collected
+}
+
+// parseWorker parses results until there are no more jobs
+func (p *resultPipeline) parseWorker() {
+	defer p.workers.Done()
+	for job := range p.jobs {
+		if job.err == nil {
+			job.res, job.err = p.parse(job.line, job.hint)
+		}
+		p.parsed <- job
+	}
+}
+
+// parse parses one result, keeping its source if asked to
+func (p *resultPipeline) parse(line string, hint string) (result.Result, error) {
+	res, err := result.ParseWithTypeHint(line, hint)
+	if err == nil && p.filter.keepSource {
+		result.KeepSource(res, line)
+	}
+	return res, err
+}
+
+// collect delivers parsed results, in the original order if needed
+func (p *resultPipeline) collect() {
+	defer close(p.collected)
+
+	pending := make(map[uint64]parseJob)
+	var next uint64
+	for job := range p.parsed {
+		if !p.filter.preserveOrder {
+			p.deliver(job)
+			<-p.window
+			continue
+		}
+		pending[job.seq] = job
+		for {
+			job, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			p.deliver(job)
+			<-p.window
+			next++
+		}
+	}
+}
+
+// deliver applies the filters to a parsed result and puts it on the
+// results channel if it's a match
+func (p *resultPipeline) deliver(job parseJob) {
+	filter := p.filter
+	if p.done() {
+		return // drain
+	}
+	if job.err != nil {
+		send(p.ctx, p.results, result.AsyncResult{Result: nil, Error: job.err})
+		return
+	}
+	res := job.res
+
+	// check if time interval and probe constraints match (applicable if we're
+	// reading from a file), and if so, put the result on the channel
+	ts := time.Time(res.GetTimeStamp())
+	if (filter.start == nil || filter.start.Before(ts.Add(time.Duration(1)))) &&
+		(filter.stop == nil || filter.stop.After(ts.Add(time.Duration(-1)))) &&
+		(len(filter.probes) == 0 || slices.Contains(filter.probes, res.GetProbeID())) {
+		if !send(p.ctx, p.results, result.AsyncResult{Result: &res, Error: nil}) {
+			return
+		}
+		filter.fetched++
+
+		if !filter.saveAll {
+			filter.saveResult(p.ctx, job.line, p.results)
+		}
+		if filter.limit != 0 && filter.fetched >= filter.limit {
+			p.full.Store(true)
+		}
+	}
+
+	// a type hint makes parsing much faster
+	if filter.typehint == "" {
+		filter.typehint = res.TypeName()
+	}
+}
@@ -0,0 +1,140 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// make a big file out of a fixture by repeating it
+func repeatFixture(tb testing.TB, fixture string, times int) string {
+	tb.Helper()
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	name := filepath.Join(tb.TempDir(), filepath.Base(fixture))
+	if err = os.WriteFile(name, []byte(strings.Repeat(string(data), times)), 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	return name
+}
+
+// Test if parallel parsing delivers the same results, in order if asked to
+func TestParallelParsing(t *testing.T) {
+	name := repeatFixture(t, "testdata/traceroute.txt", 100)
+
+	filter := NewResultsFilter()
+	filter.FilterFile(name)
+	sequential, errs := collectResults(t, filter)
+	if len(errs) != 0 || len(sequential) != 200 {
+		t.Fatalf("sequential parsing failed: %d results, errors: %v", len(sequential), errs)
+	}
+
+	filter = NewResultsFilter()
+	filter.FilterFile(name)
+	filter.Parallelism(8)
+	parallel, errs := collectResults(t, filter)
+	if len(errs) != 0 || len(parallel) != len(sequential) {
+		t.Fatalf("parallel parsing failed: %d results, errors: %v", len(parallel), errs)
+	}
+	for i := range sequential {
+		if !sequential[i].GetTimeStamp().Equal(parallel[i].GetTimeStamp()) {
+			t.Fatalf("parallel parsing did not preserve order at result %d", i)
+		}
+	}
+
+	filter = NewResultsFilter()
+	filter.FilterFile(name)
+	filter.Parallelism(8)
+	filter.PreserveOrder(false)
+	unordered, errs := collectResults(t, filter)
+	if len(errs) != 0 || len(unordered) != len(sequential) {
+		t.Fatalf("unordered parallel parsing failed: %d results, errors: %v", len(unordered), errs)
+	}
+
+	filter = NewResultsFilter()
+	filter.FilterFile(name)
+	filter.Parallelism(8)
+	filter.Limit(17)
+	limited, errs := collectResults(t, filter)
+	if len(errs) != 0 || len(limited) != 17 {
+		t.Fatalf("parallel parsing with limit failed: %d results, errors: %v", len(limited), errs)
+	}
+}
+
+// Test if saving works the same way with parallel parsing
+func TestParallelSave(t *testing.T) {
+	name := repeatFixture(t, "testdata/ping.txt", 50)
+	saved := filepath.Join(t.TempDir(), "saved.txt")
+	file, err := os.Create(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter := NewResultsFilter()
+	filter.FilterFile(name)
+	filter.FilterProbeIDs([]uint{11})
+	filter.Parallelism(4)
+	filter.Save(file)
+	res, errs := collectResults(t, filter)
+	file.Close()
+	if len(errs) != 0 || len(res) != 100 {
+		t.Fatalf("parallel parsing failed: %d results, errors: %v", len(res), errs)
+	}
+
+	data, err := os.ReadFile(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 100 {
+		t.Fatalf("expected 100 saved results, got %d", len(lines))
+	}
+	for i, line := range lines {
+		if !strings.Contains(line, `"prb_id":11`) {
+			t.Fatalf("saved result %d is not from the filtered probe", i)
+		}
+	}
+}
+
+func benchmarkParsing(b *testing.B, fixture string) {
+	name := repeatFixture(b, fixture, 2000)
+	counts := []uint{1, 2, 4, uint(runtime.NumCPU())}
+	slices.Sort(counts)
+	for _, workers := range slices.Compact(counts) {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				filter := NewResultsFilter()
+				filter.FilterFile(name)
+				filter.Parallelism(workers)
+				res, errs := collectResults(b, filter)
+				if len(res) == 0 || len(errs) != 0 {
+					b.Fatalf("parsing failed: %d results, errors: %v", len(res), errs)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParsePing(b *testing.B) {
+	benchmarkParsing(b, "testdata/ping.txt")
+}
+
+func BenchmarkParseTraceroute(b *testing.B) {
+	benchmarkParsing(b, "testdata/traceroute.txt")
+}
+
+func BenchmarkParseDns(b *testing.B) {
+	benchmarkParsing(b, "testdata/dns.txt")
+}
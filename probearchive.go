@@ -0,0 +1,456 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Probe archives are the daily snapshots of all probes published by
+// RIPE NCC, e.g. https://ftp.ripe.net/ripe/atlas/probes/archive/2023/12/20231201.json.bz2
+// They are (compressed) JSON objects with all the probes in "objects",
+// in a slightly different format than the API uses: times are UNIX
+// epochs, the status is a code with a separate name and tags are just
+// slugs. Plain JSON arrays of probes (in either format) are read as well.
+
+// the radius of the Earth in km, for the radius filter
+const earthRadius = 6371.0
+
+// API version of an archived probe
+type archiveProbe struct {
+	ID             uint            `json:"id"`
+	Address4       *netip.Addr     `json:"address_v4"`
+	Address6       *netip.Addr     `json:"address_v6"`
+	ASN4           *uint           `json:"asn_v4"`
+	ASN6           *uint           `json:"asn_v6"`
+	CountryCode    string          `json:"country_code"`
+	Description    string          `json:"description"`
+	FirstConnected *uniTime        `json:"first_connected"`
+	LastConnected  *uniTime        `json:"last_connected"`
+	Location       *Geolocation    `json:"geometry"`
+	Latitude       *float32        `json:"latitude"`
+	Longitude      *float32        `json:"longitude"`
+	Anchor         bool            `json:"is_anchor"`
+	Prefix4        *netip.Prefix   `json:"prefix_v4"`
+	Prefix6        *netip.Prefix   `json:"prefix_v6"`
+	Public         bool            `json:"is_public"`
+	Status         json.RawMessage `json:"status"`      // a code, or a status object
+	StatusName     string          `json:"status_name"` // only with a code
+	StatusSince    *uniTime        `json:"status_since"`
+	TotalUptime    uint            `json:"total_uptime"`
+	Type           string          `json:"type"`
+	Tags           []archiveTag    `json:"tags"`
+}
+
+// archived tags are slugs, or tag objects
+type archiveTag Tag
+
+func (tag *archiveTag) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &tag.Slug); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, (*Tag)(tag))
+}
+
+// probe turns an archived probe into a regular one
+func (ap *archiveProbe) probe() (Probe, error) {
+	probe := Probe{
+		ID:             ap.ID,
+		Address4:       ap.Address4,
+		Address6:       ap.Address6,
+		ASN4:           ap.ASN4,
+		ASN6:           ap.ASN6,
+		CountryCode:    ap.CountryCode,
+		Description:    ap.Description,
+		FirstConnected: ap.FirstConnected,
+		LastConnected:  ap.LastConnected,
+		Anchor:         ap.Anchor,
+		Prefix4:        ap.Prefix4,
+		Prefix6:        ap.Prefix6,
+		Public:         ap.Public,
+		TotalUptime:    ap.TotalUptime,
+		Type:           ap.Type,
+		Tags:           make([]Tag, len(ap.Tags)),
+	}
+
+	if ap.Location != nil {
+		probe.Location = *ap.Location
+	} else if ap.Latitude != nil && ap.Longitude != nil {
+		probe.Location = Geolocation{"Point", []float32{*ap.Longitude, *ap.Latitude}}
+	}
+
+	if len(ap.Status) > 0 && ap.Status[0] == '{' {
+		if err := json.Unmarshal(ap.Status, &probe.Status); err != nil {
+			return probe, err
+		}
+	} else if len(ap.Status) > 0 && string(ap.Status) != "null" {
+		if err := json.Unmarshal(ap.Status, &probe.Status.ID); err != nil {
+			return probe, err
+		}
+		probe.Status.Name = ap.StatusName
+		probe.Status.Since = ap.StatusSince
+	}
+	if probe.Status.Name == "" {
+		probe.Status.Name = ProbeStatusDict[probe.Status.ID]
+	}
+	if ap.StatusSince != nil {
+		probe.StatusSince = *ap.StatusSince
+	}
+
+	for i, tag := range ap.Tags {
+		probe.Tags[i] = Tag(tag)
+		if probe.Tags[i].Name == "" {
+			probe.Tags[i].Name = tag.Slug
+		}
+	}
+
+	return probe, nil
+}
+
+// FilterFile "filters" probes from a probe archive file instead of the API
+// "-" means stdin. Compressed files (gzip, bzip2, xz, zstd) are detected
+// automatically. The other filters are applied locally
+func (filter *ProbeFilter) FilterFile(filename string) {
+	filter.file = filename
+}
+
+// getFileProbes reads the probes from an archive file that match the
+// filters, and sends them to the channel
+func (filter *ProbeFilter) getFileProbes(ctx context.Context, probes chan AsyncProbeResult) {
+	match, err := filter.localMatcher()
+	if err != nil {
+		send(ctx, probes, AsyncProbeResult{Probe{}, err})
+		return
+	}
+
+	// sorting needs all the probes first
+	sortBy := filter.params.Get("sort")
+	matching := make([]Probe, 0)
+
+	var total uint = 0
+	err = filter.readProbeFile(func(probe Probe) bool {
+		if !match(&probe) {
+			return true
+		}
+		if sortBy != "" {
+			matching = append(matching, probe)
+			return true
+		}
+		if !send(ctx, probes, AsyncProbeResult{probe, nil}) {
+			return false
+		}
+		total++
+		return filter.limit == 0 || total < filter.limit
+	})
+	if err != nil {
+		send(ctx, probes, AsyncProbeResult{Probe{}, err})
+		return
+	}
+
+	if sortBy == "" {
+		return
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		if sortBy == "-id" {
+			return matching[i].ID > matching[j].ID
+		}
+		return matching[i].ID < matching[j].ID
+	})
+	for _, probe := range matching {
+		if filter.limit != 0 && total >= filter.limit {
+			return
+		}
+		if !send(ctx, probes, AsyncProbeResult{probe, nil}) {
+			return
+		}
+		total++
+	}
+}
+
+// readProbeFile reads the probes in an archive file one by one, and calls
+// use with each of them until it returns false
+func (filter *ProbeFilter) readProbeFile(use func(Probe) bool) error {
+	var file *os.File
+	if filter.file == "-" {
+		file = os.Stdin
+		if filter.verbose {
+			fmt.Printf("# Reading probes from stdin\n")
+		}
+	} else {
+		var err error
+		file, err = os.Open(filter.file)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if filter.verbose {
+			fmt.Printf("# Reading probes from file: %s\n", filter.file)
+		}
+	}
+
+	reader, closer, err := decompressingReader(file)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", filter.file, err)
+	}
+	defer closer()
+
+	fail := func(err error) error {
+		return fmt.Errorf("error reading probes from %s: %v", filter.file, err)
+	}
+
+	// find the start of the list of probes
+	decoder := json.NewDecoder(reader)
+	token, err := decoder.Token()
+	if err != nil {
+		return fail(err)
+	}
+	if token == json.Delim('{') {
+		for {
+			if !decoder.More() {
+				return fail(fmt.Errorf("no probes in the archive"))
+			}
+			key, err := decoder.Token()
+			if err != nil {
+				return fail(err)
+			}
+			if key == "objects" {
+				token, err = decoder.Token()
+				if err != nil {
+					return fail(err)
+				}
+				break
+			}
+			// skip whatever else is there
+			var skip json.RawMessage
+			if err := decoder.Decode(&skip); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	if token != json.Delim('[') {
+		return fail(fmt.Errorf("not a list of probes"))
+	}
+
+	for decoder.More() {
+		var archived archiveProbe
+		if err := decoder.Decode(&archived); err != nil {
+			return fail(err)
+		}
+		probe, err := archived.probe()
+		if err != nil {
+			return fail(fmt.Errorf("probe %d: %v", archived.ID, err))
+		}
+		if !use(probe) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// localMatcher turns the filters into a function that tells if a probe
+// matches all of them
+func (filter *ProbeFilter) localMatcher() (func(*Probe) bool, error) {
+	if err := filter.verifyFilters(); err != nil {
+		return nil, err
+	}
+
+	checks := make([]func(*Probe) bool, 0)
+	add := func(check func(*Probe) bool) {
+		checks = append(checks, check)
+	}
+
+	if filter.id != 0 {
+		add(func(p *Probe) bool { return p.ID == filter.id })
+	}
+
+	for key, values := range filter.params {
+		for _, value := range values {
+			var err error
+			switch key {
+			case "format[datetime]", "sort":
+				// not a filter
+			case "country_code":
+				add(func(p *Probe) bool { return strings.EqualFold(p.CountryCode, value) })
+			case "id__gt", "id__gte", "id__lt", "id__lte":
+				var n uint64
+				n, err = strconv.ParseUint(value, 10, 0)
+				add(func(p *Probe) bool { return compareOp(key, float64(p.ID), float64(n)) })
+			case "id__in":
+				var ids map[uint]bool
+				ids, err = parseUintSet(value)
+				add(func(p *Probe) bool { return ids[p.ID] })
+			case "asn", "asn_v4", "asn_v6", "asn_v4__in", "asn_v6__in":
+				var asns map[uint]bool
+				asns, err = parseUintSet(value)
+				v4 := !strings.HasPrefix(key, "asn_v6")
+				v6 := !strings.HasPrefix(key, "asn_v4")
+				add(func(p *Probe) bool {
+					return (v4 && p.ASN4 != nil && asns[*p.ASN4]) ||
+						(v6 && p.ASN6 != nil && asns[*p.ASN6])
+				})
+			case "status":
+				var n uint64
+				n, err = strconv.ParseUint(value, 10, 0)
+				add(func(p *Probe) bool { return p.Status.ID == uint(n) })
+			case "latitude__gt", "latitude__gte", "latitude__lt", "latitude__lte":
+				var f float64
+				f, err = strconv.ParseFloat(value, 64)
+				add(func(p *Probe) bool {
+					lat, _, ok := probeCoordinates(p)
+					return ok && compareOp(key, lat, f)
+				})
+			case "longitude__gt", "longitude__gte", "longitude__lt", "longitude__lte":
+				var f float64
+				f, err = strconv.ParseFloat(value, 64)
+				add(func(p *Probe) bool {
+					_, lon, ok := probeCoordinates(p)
+					return ok && compareOp(key, lon, f)
+				})
+			case "is_anchor":
+				var yesno bool
+				yesno, err = strconv.ParseBool(value)
+				add(func(p *Probe) bool { return p.Anchor == yesno })
+			case "is_public":
+				var yesno bool
+				yesno, err = strconv.ParseBool(value)
+				add(func(p *Probe) bool { return p.Public == yesno })
+			case "radius":
+				var lat, lon, radius float64
+				_, err = fmt.Sscanf(value, "%f,%f:%f", &lat, &lon, &radius)
+				add(func(p *Probe) bool {
+					plat, plon, ok := probeCoordinates(p)
+					return ok && distance(lat, lon, plat, plon) <= radius
+				})
+			case "prefix_v4", "prefix_v6":
+				var prefix netip.Prefix
+				prefix, err = netip.ParsePrefix(value)
+				v4 := key == "prefix_v4"
+				add(func(p *Probe) bool {
+					if v4 {
+						return inPrefix(prefix, p.Address4, p.Prefix4)
+					}
+					return inPrefix(prefix, p.Address6, p.Prefix6)
+				})
+			case "tags":
+				tags := strings.Split(value, ",")
+				add(func(p *Probe) bool {
+					for _, tag := range tags {
+						if !hasTag(p, tag) {
+							return false
+						}
+					}
+					return true
+				})
+			default:
+				return nil, fmt.Errorf("filter %s is not supported for probe archives", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s filter: %s", key, value)
+			}
+		}
+	}
+
+	return func(p *Probe) bool {
+		for _, check := range checks {
+			if !check(p) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// compareOp compares two numbers according to the suffix of a filter
+// like "id__gt"
+func compareOp(key string, a, b float64) bool {
+	switch key[strings.LastIndex(key, "__")+2:] {
+	case "gt":
+		return a > b
+	case "gte":
+		return a >= b
+	case "lt":
+		return a < b
+	default:
+		return a <= b
+	}
+}
+
+// parseUintSet turns a comma separated list of numbers into a set
+func parseUintSet(list string) (map[uint]bool, error) {
+	set := make(map[uint]bool)
+	for _, item := range strings.Split(list, ",") {
+		n, err := strconv.ParseUint(item, 10, 0)
+		if err != nil {
+			return nil, err
+		}
+		set[uint(n)] = true
+	}
+	return set, nil
+}
+
+// probeCoordinates returns the latitude and longitude of a probe, if known
+func probeCoordinates(p *Probe) (float64, float64, bool) {
+	if len(p.Location.Coordinates) < 2 {
+		return 0, 0, false
+	}
+	return float64(p.Location.Coordinates[1]), float64(p.Location.Coordinates[0]), true
+}
+
+// distance calculates the great circle distance (in km) between two points
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dlat := (lat2 - lat1) * rad
+	dlon := (lon2 - lon1) * rad
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// inPrefix tells if a probe's address or prefix is inside a prefix
+func inPrefix(prefix netip.Prefix, addr *netip.Addr, probePrefix *netip.Prefix) bool {
+	if addr != nil && prefix.Contains(*addr) {
+		return true
+	}
+	return probePrefix != nil &&
+		probePrefix.Bits() >= prefix.Bits() &&
+		prefix.Contains(probePrefix.Addr())
+}
+
+// hasTag tells if a probe has a tag
+func hasTag(p *Probe, slug string) bool {
+	for _, tag := range p.Tags {
+		if tag.Slug == slug {
+			return true
+		}
+	}
+	return false
+}
+
+// countFileProbes counts the probes in an archive file that match the filters
+func (filter *ProbeFilter) countFileProbes() (uint, error) {
+	match, err := filter.localMatcher()
+	if err != nil {
+		return 0, err
+	}
+	var count uint = 0
+	err = filter.readProbeFile(func(probe Probe) bool {
+		if match(&probe) {
+			count++
+		}
+		return true
+	})
+	return count, err
+}
@@ -0,0 +1,343 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/robert-kisteleki/goatapi/result"
+)
+
+// ConnectionMeasurementID is the built-in measurement that has the
+// connection and disconnection events of all probes
+const ConnectionMeasurementID = 7000
+
+// how far GetProbeHistory looks back for the last connection event
+// before the window
+const probeHistoryLookback = 365 * 24 * time.Hour
+
+// ProbeConnection is a period when a probe was connected to the
+// infrastructure
+type ProbeConnection struct {
+	Start            time.Time    // the start of the history if connected before that
+	End              time.Time    // the end of the history if still connected
+	Controller       string       // the controller the probe was connected to
+	ASN              uint         // the ASN the probe connected from; 0 if unknown
+	Prefix           netip.Prefix // the prefix the probe connected from; invalid if unknown
+	ControllerChange bool         // the controller is not the same as in the previous connection
+	AddressChange    bool         // the ASN or prefix is not the same as in the previous connection (if that's known)
+}
+
+// ProbeDisconnection is a period when a probe was not connected
+type ProbeDisconnection struct {
+	Start time.Time //
+	End   time.Time //
+}
+
+// Duration returns how long the probe was connected
+func (conn *ProbeConnection) Duration() time.Duration {
+	return conn.End.Sub(conn.Start)
+}
+
+// Duration returns how long the probe was disconnected
+func (disc *ProbeDisconnection) Duration() time.Duration {
+	return disc.End.Sub(disc.Start)
+}
+
+// ProbeHistory collects the connection events of a probe in a time window,
+// and turns them into connected and disconnected periods. It is not safe
+// for concurrent use
+type ProbeHistory struct {
+	ProbeID   uint                       //
+	Start     time.Time                  // start of the window
+	Stop      time.Time                  // end of the window
+	Connected bool                       // the state in the window if there are no events
+	Unknown   bool                       // there are no events and the state in the window is not known either
+	events    []*result.ConnectionResult //
+	err       error                      // the first error seen
+	Skipped   uint                       // number of results that are not connection events of this probe
+	Errors    uint                       // number of errors seen
+}
+
+// NewProbeHistory prepares a connection history of a probe between start
+// and stop; both are needed
+func NewProbeHistory(probeID uint, start, stop time.Time) *ProbeHistory {
+	return &ProbeHistory{
+		ProbeID: probeID,
+		Start:   start,
+		Stop:    stop,
+		events:  make([]*result.ConnectionResult, 0),
+	}
+}
+
+// Add adds a connection event to the history. Events of other probes and
+// events outside of the window are ignored; it returns false for those
+func (history *ProbeHistory) Add(conn *result.ConnectionResult) bool {
+	ts := conn.GetTimeStamp()
+	if conn.GetProbeID() != history.ProbeID || ts.Before(history.Start) || ts.After(history.Stop) {
+		return false
+	}
+	history.events = append(history.events, conn)
+	return true
+}
+
+// Consume reads results from a channel until it is closed, and adds the
+// connection events to the history. Other results and errors are counted;
+// the first error is returned by Err()
+func (history *ProbeHistory) Consume(results chan result.AsyncResult) {
+	for res := range results {
+		if res.Error != nil {
+			if history.err == nil {
+				history.err = res.Error
+			}
+			history.Errors++
+			continue
+		}
+		conn, ok := (*res.Result).(*result.ConnectionResult)
+		if !ok || !history.Add(conn) {
+			history.Skipped++
+		}
+	}
+}
+
+// Err returns the first error Consume has seen, if any
+func (history *ProbeHistory) Err() error {
+	return history.err
+}
+
+// sortedEvents returns the events in time order
+func (history *ProbeHistory) sortedEvents() []*result.ConnectionResult {
+	events := make([]*result.ConnectionResult, len(history.events))
+	copy(events, history.events)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].GetTimeStamp().Before(events[j].GetTimeStamp())
+	})
+	return events
+}
+
+// Connections returns the periods when the probe was connected, in time
+// order. Without a disconnect event the probe is considered to be
+// connected until it connects again, or until the end of the window
+// If the first event is a disconnect, the probe was connected since before
+// the window; the ASN and prefix of that connection are unknown
+func (history *ProbeHistory) Connections() []ProbeConnection {
+	events := history.sortedEvents()
+
+	connections := make([]ProbeConnection, 0)
+	if len(events) == 0 {
+		if history.Connected {
+			connections = append(connections, ProbeConnection{Start: history.Start, End: history.Stop})
+		}
+		return connections
+	}
+
+	var open *ProbeConnection
+	add := func(conn ProbeConnection) {
+		if len(connections) > 0 {
+			previous := connections[len(connections)-1]
+			conn.ControllerChange = previous.Controller != conn.Controller
+			if previous.ASN != 0 || previous.Prefix.IsValid() {
+				conn.AddressChange = previous.ASN != conn.ASN || previous.Prefix != conn.Prefix
+			}
+		}
+		connections = append(connections, conn)
+	}
+
+	for _, event := range events {
+		ts := event.GetTimeStamp()
+		switch event.Event {
+		case "connect":
+			if open != nil {
+				open.End = ts
+				add(*open)
+			}
+			open = &ProbeConnection{
+				Start:      ts,
+				Controller: event.Controller,
+				ASN:        event.Asn,
+				Prefix:     event.Prefix,
+			}
+		case "disconnect":
+			if open == nil && len(connections) == 0 {
+				// connected since before the window, from an unknown address
+				open = &ProbeConnection{
+					Start:      history.Start,
+					Controller: event.Controller,
+				}
+			}
+			if open != nil {
+				open.End = ts
+				add(*open)
+				open = nil
+			}
+		}
+	}
+	if open != nil {
+		open.End = history.Stop
+		add(*open)
+	}
+
+	return connections
+}
+
+// Disconnections returns the periods in the window when the probe was
+// not connected, in time order
+func (history *ProbeHistory) Disconnections() []ProbeDisconnection {
+	disconnections := make([]ProbeDisconnection, 0)
+	from := history.Start
+	for _, conn := range history.Connections() {
+		if conn.Start.After(from) {
+			disconnections = append(disconnections, ProbeDisconnection{from, conn.Start})
+		}
+		if conn.End.After(from) {
+			from = conn.End
+		}
+	}
+	if history.Stop.After(from) {
+		disconnections = append(disconnections, ProbeDisconnection{from, history.Stop})
+	}
+	return disconnections
+}
+
+// ConnectedAt tells if the probe was connected at a point in time
+func (history *ProbeHistory) ConnectedAt(t time.Time) bool {
+	for _, conn := range history.Connections() {
+		if !t.Before(conn.Start) && t.Before(conn.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// Uptime returns how long the probe was connected between start and stop
+func (history *ProbeHistory) Uptime(start, stop time.Time) time.Duration {
+	var uptime time.Duration
+	for _, conn := range history.Connections() {
+		from := conn.Start
+		if from.Before(start) {
+			from = start
+		}
+		to := conn.End
+		if to.After(stop) {
+			to = stop
+		}
+		if to.After(from) {
+			uptime += to.Sub(from)
+		}
+	}
+	return uptime
+}
+
+// Availability returns the percentage of time the probe was connected
+// between start and stop; -1 if that's an empty period or the state of
+// the probe is not known. Only the part of the period that is inside the
+// window of the history counts
+func (history *ProbeHistory) Availability(start, stop time.Time) float64 {
+	if history.Unknown {
+		return -1
+	}
+	if start.Before(history.Start) {
+		start = history.Start
+	}
+	if stop.After(history.Stop) {
+		stop = history.Stop
+	}
+	if !stop.After(start) {
+		return -1
+	}
+	return 100 * float64(history.Uptime(start, stop)) / float64(stop.Sub(start))
+}
+
+// GetProbeHistory retrieves the connection events of a probe between start
+// and stop (zero stop means now) and makes a history out of them. If there
+// are no events in the window, the probe was in the same state all along:
+// the current status of the probe if that hasn't changed since before the
+// window, otherwise the state after the last event before the window. If
+// neither is known, the history is marked as Unknown
+func GetProbeHistory(
+	verbose bool,
+	id uint,
+	start, stop time.Time,
+) (
+	*ProbeHistory,
+	error,
+) {
+	if stop.IsZero() {
+		stop = time.Now()
+	}
+	history, err := getProbeHistory(verbose, id, start, stop)
+	if err != nil {
+		return nil, err
+	}
+	if len(history.events) != 0 {
+		return history, nil
+	}
+
+	probe, err := GetProbe(verbose, id)
+	if err != nil {
+		return nil, err
+	}
+	since := time.Time(probe.StatusSince)
+	if since.IsZero() && probe.Status.Since != nil {
+		since = time.Time(*probe.Status.Since)
+	}
+	if !since.IsZero() && !since.After(start) {
+		history.Connected = probe.Status.ID == ProbeStatusConnected
+		return history, nil
+	}
+
+	// look back in ever bigger steps
+	limit := start.Add(-probeHistoryLookback)
+	to := start
+	for back := 24 * time.Hour; to.After(limit); back *= 2 {
+		from := start.Add(-back)
+		if from.Before(limit) {
+			from = limit
+		}
+		earlier, err := getProbeHistory(verbose, id, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if events := earlier.sortedEvents(); len(events) != 0 {
+			history.Connected = events[len(events)-1].Event == "connect"
+			return history, nil
+		}
+		to = from
+	}
+
+	history.Unknown = true
+	return history, nil
+}
+
+// getProbeHistory makes a history from the connection events of a probe
+// between start and stop
+func getProbeHistory(
+	verbose bool,
+	id uint,
+	start, stop time.Time,
+) (
+	*ProbeHistory,
+	error,
+) {
+	history := NewProbeHistory(id, start, stop)
+
+	filter := NewResultsFilter()
+	filter.FilterID(ConnectionMeasurementID)
+	filter.FilterProbeIDs([]uint{id})
+	filter.FilterStart(start)
+	filter.FilterStop(stop)
+
+	results := make(chan result.AsyncResult)
+	go filter.GetResults(verbose, results)
+	history.Consume(results)
+	if err := history.Err(); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
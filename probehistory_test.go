@@ -0,0 +1,144 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/robert-kisteleki/goatapi/result"
+)
+
+// describe connection periods as "start-end" items
+func describeConnections(connections []ProbeConnection) string {
+	items := make([]string, 0)
+	for _, conn := range connections {
+		items = append(items, fmt.Sprintf("%d-%d", conn.Start.Unix(), conn.End.Unix()))
+	}
+	return fmt.Sprint(items)
+}
+
+// Test turning connection events into periods and availability
+func TestProbeHistory(t *testing.T) {
+	events := []string{
+		`{"fw":5080,"msm_id":7000,"prb_id":11,"timestamp":8000,"type":"connection","event":"connect","controller":"ctr-fra01","asn":64500,"prefix":"203.0.113.0/24"}`,
+		`{"fw":5080,"msm_id":7000,"prb_id":11,"timestamp":1000,"type":"connection","event":"disconnect","controller":"ctr-ams01","prefix":"198.51.100.0/24"}`,
+		`{"fw":5080,"msm_id":7000,"prb_id":11,"timestamp":2000,"type":"connection","event":"connect","controller":"ctr-ams01","asn":3333,"prefix":"198.51.100.0/24"}`,
+		`{"fw":5080,"msm_id":7000,"prb_id":11,"timestamp":5000,"type":"connection","event":"disconnect","controller":"ctr-ams01","prefix":"198.51.100.0/24"}`,
+		`{"fw":5080,"msm_id":7000,"prb_id":11,"timestamp":6000,"type":"connection","event":"connect","controller":"ctr-fra01","asn":3333,"prefix":"198.51.100.0/24"}`,
+		`{"fw":5080,"msm_id":7000,"prb_id":12,"timestamp":3000,"type":"connection","event":"connect","controller":"ctr-ams01","asn":3333,"prefix":"198.51.100.0/24"}`,
+		`{"fw":5080,"msm_id":7000,"prb_id":11,"timestamp":20000,"type":"connection","event":"connect","controller":"ctr-ams01","asn":3333,"prefix":"198.51.100.0/24"}`,
+		`{"fw":5080,"lts":14,"msm_id":7001,"prb_id":11,"timestamp":3000,"type":"uptime","uptime":1000}`,
+	}
+	results := make(chan result.AsyncResult)
+	go func() {
+		for _, event := range events {
+			res, err := result.Parse(event)
+			results <- result.AsyncResult{Result: &res, Error: err}
+		}
+		results <- result.AsyncResult{Error: os.ErrNotExist}
+		close(results)
+	}()
+
+	history := NewProbeHistory(11, time.Unix(500, 0), time.Unix(10000, 0))
+	history.Consume(results)
+	assertEqual(t, history.Skipped, uint(3), "other probes, times and types skipped")
+	assertEqual(t, history.Errors, uint(1), "errors counted")
+
+	connections := history.Connections()
+	assertEqual(t, describeConnections(connections), "[500-1000 2000-5000 6000-8000 8000-10000]", "connection periods")
+	assertEqual(t, connections[0].ASN, uint(0), "ASN of the connection before the window")
+	assertEqual(t, connections[0].Prefix.IsValid(), false, "prefix of the connection before the window")
+	assertEqual(t, connections[1].ControllerChange, false, "same controller")
+	assertEqual(t, connections[1].AddressChange, false, "no address change after an unknown address")
+	assertEqual(t, connections[2].ControllerChange, true, "controller change")
+	assertEqual(t, connections[2].AddressChange, false, "same address")
+	assertEqual(t, connections[3].AddressChange, true, "address change")
+	assertEqual(t, connections[3].ASN, uint(64500), "ASN of the connection")
+	assertEqual(t, connections[3].Duration(), 2000*time.Second, "duration of a connection")
+
+	disconnections := history.Disconnections()
+	assertEqual(t, len(disconnections), 2, "disconnected periods")
+	assertEqual(t, disconnections[0].Start.Unix(), int64(1000), "start of a disconnection")
+	assertEqual(t, disconnections[1].Duration(), 1000*time.Second, "duration of a disconnection")
+
+	assertEqual(t, history.ConnectedAt(time.Unix(5500, 0)), false, "disconnected at a time")
+	assertEqual(t, history.ConnectedAt(time.Unix(7000, 0)), true, "connected at a time")
+	assertEqual(t, history.Uptime(time.Unix(0, 0), time.Unix(99999, 0)), 7500*time.Second, "total uptime")
+	assertEqual(t, math.Round(history.Availability(time.Unix(0, 0), time.Unix(99999, 0))*100), 7895.0, "availability in the window")
+	assertEqual(t, history.Availability(time.Unix(2000, 0), time.Unix(6000, 0)), 75.0, "availability in a period")
+	assertEqual(t, history.Availability(time.Unix(20000, 0), time.Unix(30000, 0)), -1.0, "availability outside of the window")
+
+	// no events at all
+	history = NewProbeHistory(11, time.Unix(500, 0), time.Unix(10000, 0))
+	assertEqual(t, len(history.Connections()), 0, "no connections")
+	history.Connected = true
+	assertEqual(t, history.Availability(time.Unix(0, 0), time.Unix(99999, 0)), 100.0, "connected all along")
+}
+
+// Test getting the history of probes from the API
+func TestGetProbeHistory(t *testing.T) {
+	connections, err := os.ReadFile("testdata/connection.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/measurements/7000/results/":
+			w.Write(connections)
+			// probe 13 connected before the window; the history keeps events in its window only
+			fmt.Fprint(w, `{"fw":5080,"msm_id":7000,"prb_id":13,"timestamp":1699900000,"type":"connection","event":"connect","controller":"ctr-ams01","asn":3333,"prefix":"198.51.100.0/24"}`+"\n")
+		case "/probes/12/":
+			fmt.Fprint(w, `{"id":12,"status":{"id":1,"name":"Connected","since":"2023-01-01T00:00:00Z"},"status_since":1672531200}`)
+		case "/probes/13/", "/probes/14/":
+			fmt.Fprint(w, `{"id":13,"status":{"id":2,"name":"Disconnected","since":"2023-11-15T00:00:00Z"},"status_since":1700006400}`)
+		default:
+			t.Errorf("unexpected request: %s", r.URL.Path)
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+	oldbase := apiBaseURL
+	SetAPIBase(server.URL + "/")
+	defer SetAPIBase(oldbase)
+
+	start := time.Unix(1699990000, 0)
+	stop := time.Unix(1700010000, 0)
+	history, err := GetProbeHistory(false, 11, start, stop)
+	if err != nil {
+		t.Fatalf("error getting the history: %v", err)
+	}
+	assertEqual(t, describeConnections(history.Connections()), "[1700000000-1700003600]", "connection from the API")
+	assertEqual(t, history.Availability(start, stop), 18.0, "availability from the API")
+
+	history, err = GetProbeHistory(false, 12, start, stop)
+	if err != nil {
+		t.Fatalf("error getting the history: %v", err)
+	}
+	assertEqual(t, history.Availability(start, stop), 100.0, "availability from the probe status")
+
+	// disconnected since after the window, connected before it
+	history, err = GetProbeHistory(false, 13, start, time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatalf("error getting the history: %v", err)
+	}
+	assertEqual(t, history.Availability(start, time.Unix(1700000000, 0)), 100.0, "availability from an earlier event")
+
+	// no events at all
+	history, err = GetProbeHistory(false, 14, start, time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatalf("error getting the history: %v", err)
+	}
+	assertEqual(t, history.Unknown, true, "unknown state")
+	assertEqual(t, history.Availability(start, time.Unix(1700000000, 0)), -1.0, "unknown availability")
+}
@@ -0,0 +1,187 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/robert-kisteleki/goatapi/result"
+)
+
+// ProbeMeasurementFilter lists the measurements a probe takes part in now,
+// or took part in at any time
+type ProbeMeasurementFilter struct {
+	params  url.Values
+	probe   uint
+	current bool
+	limit   uint
+	verbose bool
+	key     *uuid.UUID
+}
+
+// NewProbeMeasurementFilter prepares a new filter for the measurements of
+// a probe
+func NewProbeMeasurementFilter(probe uint) ProbeMeasurementFilter {
+	filter := ProbeMeasurementFilter{probe: probe}
+	filter.params = url.Values{}
+	filter.params.Add("format[datetime]", "iso-8601")
+	return filter
+}
+
+// Verbose sets verbosity
+func (filter *ProbeMeasurementFilter) Verbose(verbose bool) {
+	filter.verbose = verbose
+}
+
+// FilterCurrent filters for measurements the probe takes part in now (true),
+// or took part in at any time (false, the default)
+func (filter *ProbeMeasurementFilter) FilterCurrent(current bool) {
+	filter.current = current
+}
+
+// FilterType filters for a particular measurement type
+func (filter *ProbeMeasurementFilter) FilterType(typ string) {
+	filter.params.Add("type", typ)
+}
+
+// FilterStatus filters for measurements that have a specific status
+// See: const MeasurementStatus*
+func (filter *ProbeMeasurementFilter) FilterStatus(n uint) {
+	filter.params.Add("status", fmt.Sprint(n))
+}
+
+// Sort asks the result list to be sorted by some ordering
+// See also: MeasurementListSortOrders
+func (filter *ProbeMeasurementFilter) Sort(by string) {
+	filter.params.Add("sort", by)
+}
+
+// PageSize sets the number of measurements in one page of the listing
+func (filter *ProbeMeasurementFilter) PageSize(size uint) {
+	filter.params.Set("page_size", fmt.Sprint(size))
+}
+
+// Limit limits the number of result retrieved; 0 (the default) means no limit
+func (filter *ProbeMeasurementFilter) Limit(limit uint) {
+	filter.limit = limit
+}
+
+// ApiKey sets the API key to be used
+// This key should have the "list_measurements" permission
+func (filter *ProbeMeasurementFilter) ApiKey(key *uuid.UUID) {
+	filter.key = key
+}
+
+// Verify sanity of applied filters
+func (filter *ProbeMeasurementFilter) verifyFilters() error {
+	if filter.probe == 0 {
+		return fmt.Errorf("probe ID must be specified")
+	}
+
+	if filter.params.Has("sort") && !ValidMeasurementListSortOrder(filter.params.Get("sort")) {
+		return fmt.Errorf("invalid sort order")
+	}
+
+	if filter.params.Has("type") && !ValidMeasurementType(filter.params.Get("type")) {
+		return fmt.Errorf("invalid measurement type")
+	}
+
+	return nil
+}
+
+// the listing query; current participation is a measurement filter,
+// all of them is a list of the probe
+func (filter *ProbeMeasurementFilter) query() string {
+	if filter.current {
+		params := maps.Clone(filter.params)
+		params.Set("current_probes", fmt.Sprint(filter.probe))
+		return apiBaseURL + "measurements/?" + params.Encode()
+	}
+	return fmt.Sprintf("%sprobes/%d/measurements/?%s", apiBaseURL, filter.probe, filter.params.Encode())
+}
+
+// GetMeasurementCount returns the number of measurements of the probe
+func (filter *ProbeMeasurementFilter) GetMeasurementCount() (
+	count uint,
+	err error,
+) {
+	// sanity checks - late in the process, but not too late
+	err = filter.verifyFilters()
+	if err != nil {
+		return
+	}
+
+	resp, err := apiGetRequest(filter.verbose, filter.query(), filter.key)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return 0, parseAPIError(resp)
+	}
+
+	// grab and store the actual content
+	var page measurementListingPage
+	err = json.NewDecoder(resp.Body).Decode(&page)
+	if err != nil {
+		return 0, err
+	}
+
+	// the only really important data point is the count
+	return page.Count, nil
+}
+
+// GetMeasurements returns the measurements of the probe
+// Results (or an error) appear on a channel
+func (filter *ProbeMeasurementFilter) GetMeasurements(
+	measurements chan AsyncMeasurementResult,
+) {
+	defer close(measurements)
+
+	// sanity checks - late in the process, but not too late
+	err := filter.verifyFilters()
+	if err != nil {
+		measurements <- AsyncMeasurementResult{Measurement{}, err}
+		return
+	}
+
+	getMeasurementPages(context.Background(), filter.verbose, filter.query(), filter.key, filter.limit, measurements)
+}
+
+// GetLatestResults returns the latest result of the probe from each of its
+// measurements on a channel, which is closed at the end
+// Measurements without a result from the probe are skipped
+func (filter *ProbeMeasurementFilter) GetLatestResults(
+	results chan result.AsyncResult,
+) {
+	defer close(results)
+
+	measurements := make(chan AsyncMeasurementResult)
+	go filter.GetMeasurements(measurements)
+	for msm := range measurements {
+		if msm.Error != nil {
+			results <- result.AsyncResult{Result: nil, Error: msm.Error}
+			continue
+		}
+
+		latest := NewResultsFilter()
+		latest.FilterID(msm.Measurement.ID)
+		latest.FilterProbeIDs([]uint{filter.probe})
+		latest.FilterLatest()
+		msmresults := make(chan result.AsyncResult)
+		go latest.GetResults(filter.verbose, msmresults)
+		for res := range msmresults {
+			results <- res
+		}
+	}
+}
@@ -0,0 +1,113 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/robert-kisteleki/goatapi/result"
+)
+
+// Test listing the measurements of a probe and getting its latest results
+func TestProbeMeasurements(t *testing.T) {
+	// the latest results of probe 21 from the mixed fixture
+	latest := make(map[string]string)
+	file, err := os.Open("testdata/mixed.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		res, _ := result.Parse(scanner.Text())
+		base, _ := result.CsvRows("base", res)
+		if res.GetProbeID() == 21 {
+			latest["/measurements/"+base[0][0]+"/latest/"] = scanner.Text()
+		}
+	}
+	file.Close()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/probes/21/measurements/" && query.Get("page") == "":
+			fmt.Fprintf(w, `{"count":3,"next":"%s/probes/21/measurements/?page=2","results":[{"id":1002,"type":"ping"},{"id":2002,"type":"dns"}]}`, server.URL)
+		case r.URL.Path == "/probes/21/measurements/":
+			fmt.Fprint(w, `{"count":3,"next":null,"results":[{"id":5001,"type":"traceroute"}]}`)
+		case r.URL.Path == "/measurements/" && query.Get("current_probes") == "21":
+			fmt.Fprint(w, `{"count":1,"next":null,"results":[{"id":5002,"type":"traceroute","probes":[{"id":21}]}]}`)
+		case strings.HasSuffix(r.URL.Path, "/latest/"):
+			if query.Get("probe_ids") != "21" {
+				t.Errorf("latest results of other probes asked for: %s", query.Get("probe_ids"))
+			}
+			fmt.Fprintln(w, latest[r.URL.Path])
+		default:
+			t.Errorf("unexpected request: %s", r.URL)
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+	oldbase := apiBaseURL
+	SetAPIBase(server.URL + "/")
+	defer SetAPIBase(oldbase)
+
+	msmIDs := func(filter ProbeMeasurementFilter) string {
+		measurements := make(chan AsyncMeasurementResult)
+		go filter.GetMeasurements(measurements)
+		ids := make([]uint, 0)
+		for msm := range measurements {
+			if msm.Error != nil {
+				t.Fatalf("error listing measurements: %v", msm.Error)
+			}
+			ids = append(ids, msm.Measurement.ID)
+		}
+		return fmt.Sprint(ids)
+	}
+
+	filter := NewProbeMeasurementFilter(21)
+	assertEqual(t, msmIDs(filter), "[1002 2002 5001]", "all measurements, over pages")
+	filter.Limit(2)
+	assertEqual(t, msmIDs(filter), "[1002 2002]", "measurements with a limit")
+	count, err := filter.GetMeasurementCount()
+	if err != nil {
+		t.Fatalf("error counting measurements: %v", err)
+	}
+	assertEqual(t, count, uint(3), "number of measurements")
+
+	filter = NewProbeMeasurementFilter(21)
+	filter.FilterCurrent(true)
+	assertEqual(t, msmIDs(filter), "[5002]", "current measurements")
+
+	// the latest result from each measurement, skipping the ones without
+	filter = NewProbeMeasurementFilter(21)
+	results := make(chan result.AsyncResult)
+	go filter.GetLatestResults(results)
+	items := make([]string, 0)
+	for res := range results {
+		if res.Error != nil {
+			t.Fatalf("error getting the latest results: %v", res.Error)
+		}
+		items = append(items, (*res.Result).TypeName())
+	}
+	assertEqual(t, strings.Join(items, " "), "ping dns", "latest results")
+
+	filter = NewProbeMeasurementFilter(0)
+	if _, err := filter.GetMeasurementCount(); err == nil {
+		t.Errorf("a probe ID is needed")
+	}
+	filter = NewProbeMeasurementFilter(21)
+	filter.FilterType("bogus")
+	if _, err := filter.GetMeasurementCount(); err == nil {
+		t.Errorf("bad type should be rejected")
+	}
+}
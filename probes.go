@@ -7,6 +7,7 @@
 package goatapi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/netip"
@@ -129,6 +130,7 @@ type probeListingPage struct {
 type ProbeFilter struct {
 	params  url.Values
 	id      uint
+	file    string
 	limit   uint
 	verbose bool
 }
@@ -290,7 +292,7 @@ func (filter *ProbeFilter) Sort(by string) {
 	filter.params.Add("sort", by)
 }
 
-// Limit limits the number of result retrieved
+// Limit limits the number of result retrieved; 0 (the default) means no limit
 func (filter *ProbeFilter) Limit(limit uint) {
 	filter.limit = limit
 }
@@ -333,6 +335,11 @@ func (filter *ProbeFilter) GetProbeCount() (
 	count uint,
 	err error,
 ) {
+	// probe archives are counted locally
+	if filter.file != "" {
+		return filter.countFileProbes()
+	}
+
 	// sanity checks - late in the process, but not too late
 	err = filter.verifyFilters()
 	if err != nil {
@@ -363,56 +370,72 @@ func (filter *ProbeFilter) GetProbeCount() (
 // Results (or an error) appear on a channel
 func (filter *ProbeFilter) GetProbes(
 	probes chan AsyncProbeResult,
+) {
+	filter.getProbes(context.Background(), probes)
+}
+
+// getProbes is GetProbes that stops when the context is cancelled
+func (filter *ProbeFilter) getProbes(
+	ctx context.Context,
+	probes chan AsyncProbeResult,
 ) {
 	defer close(probes)
 
+	// probes from an archive file instead of the API
+	if filter.file != "" {
+		filter.getFileProbes(ctx, probes)
+		return
+	}
+
 	// special case: a specific ID was "filtered"
 	if filter.id != 0 {
 		probe, err := GetProbe(filter.verbose, filter.id)
 		if err != nil {
-			probes <- AsyncProbeResult{Probe{}, err}
+			send(ctx, probes, AsyncProbeResult{Probe{}, err})
 		}
-		probes <- AsyncProbeResult{*probe, nil}
+		send(ctx, probes, AsyncProbeResult{*probe, nil})
 		return
 	}
 
 	// sanity checks - late in the process, but not too late
 	err := filter.verifyFilters()
 	if err != nil {
-		probes <- AsyncProbeResult{Probe{}, err}
+		send(ctx, probes, AsyncProbeResult{Probe{}, err})
 		return
 	}
 
 	query := apiBaseURL + "probes/?" + filter.params.Encode()
 
-	resp, err := apiGetRequest(filter.verbose, query, nil)
+	resp, err := apiGetRequestContext(ctx, filter.verbose, query, nil)
 
 	// results are paginated with next= (and previous=)
 	var total uint = 0
 	for {
 		if err != nil {
-			probes <- AsyncProbeResult{Probe{}, err}
+			send(ctx, probes, AsyncProbeResult{Probe{}, err})
 			return
 		}
 		defer resp.Body.Close()
 
 		if resp.StatusCode != 200 {
-			probes <- AsyncProbeResult{Probe{}, parseAPIError(resp)}
+			send(ctx, probes, AsyncProbeResult{Probe{}, parseAPIError(resp)})
 			return
 		}
 
 		// grab and store the actual content
 		var page probeListingPage
 		err = json.NewDecoder(resp.Body).Decode(&page)
-		if err != nil {
-			probes <- AsyncProbeResult{Probe{}, err}
+		if err != nil && !send(ctx, probes, AsyncProbeResult{Probe{}, err}) {
+			return
 		}
 
 		// return items while observing the limit
 		for _, probe := range page.Probes {
-			probes <- AsyncProbeResult{probe, nil}
+			if !send(ctx, probes, AsyncProbeResult{probe, nil}) {
+				return
+			}
 			total++
-			if total >= filter.limit {
+			if filter.limit != 0 && total >= filter.limit {
 				return
 			}
 		}
@@ -423,7 +446,7 @@ func (filter *ProbeFilter) GetProbes(
 		}
 
 		// just follow the next link
-		resp, err = apiGetRequest(filter.verbose, page.Next, nil)
+		resp, err = apiGetRequestContext(ctx, filter.verbose, page.Next, nil)
 	}
 }
 
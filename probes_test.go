@@ -7,6 +7,10 @@
 package goatapi
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
 	"testing"
 )
 
@@ -52,3 +56,137 @@ func TestProbeFilterValidator(t *testing.T) {
 		t.Fatalf("Sort order is not filtered properly")
 	}
 }
+
+// archiveProbeIDs lists the IDs of the probes the filter finds in the archive
+func archiveProbeIDs(t *testing.T, filter *ProbeFilter) string {
+	t.Helper()
+	filter.FilterFile("testdata/probes.json.bz2")
+	probes := make(chan AsyncProbeResult)
+	go filter.GetProbes(probes)
+	ids := make([]uint, 0)
+	for probe := range probes {
+		if probe.Error != nil {
+			t.Fatalf("error reading the probe archive: %v", probe.Error)
+		}
+		ids = append(ids, probe.Probe.ID)
+	}
+	return fmt.Sprint(ids)
+}
+
+// Test reading probes from an archive and filtering them locally
+func TestProbeArchive(t *testing.T) {
+	assertEqual(t, archiveProbeIDs(t, NewProbeFilter()), "[1 2 3 4 5]", "all probes")
+
+	filter := NewProbeFilter()
+	filter.FilterCountry("NL")
+	filter.FilterASN(3333)
+	filter.FilterStatus(ProbeStatusConnected)
+	assertEqual(t, archiveProbeIDs(t, filter), "[1 2]", "country, ASN and status")
+
+	filter = NewProbeFilter()
+	filter.FilterASN6(64500)
+	assertEqual(t, archiveProbeIDs(t, filter), "[3]", "IPv6 ASN")
+
+	filter = NewProbeFilter()
+	filter.FilterPrefixV4(netip.MustParsePrefix("192.0.0.0/16"))
+	assertEqual(t, archiveProbeIDs(t, filter), "[1 5]", "IPv4 prefix")
+
+	filter = NewProbeFilter()
+	filter.FilterPrefixV6(netip.MustParsePrefix("2001:db8:1::/64"))
+	assertEqual(t, archiveProbeIDs(t, filter), "[2]", "IPv6 prefix")
+
+	filter = NewProbeFilter()
+	filter.FilterTags([]string{"home"})
+	assertEqual(t, archiveProbeIDs(t, filter), "[1 5]", "tag slugs and tag objects")
+	filter.FilterTags([]string{"system-ipv4-works"})
+	assertEqual(t, archiveProbeIDs(t, filter), "[1]", "multiple tags")
+
+	filter = NewProbeFilter()
+	filter.FilterRadius(52.37, 4.89, 50)
+	assertEqual(t, archiveProbeIDs(t, filter), "[1 2]", "radius")
+
+	filter = NewProbeFilter()
+	filter.FilterLatitudeGt(52.2)
+	filter.FilterAnchor(false)
+	assertEqual(t, archiveProbeIDs(t, filter), "[1 3]", "latitude and anchor")
+
+	filter = NewProbeFilter()
+	filter.FilterIDin([]uint{2, 3, 4})
+	filter.FilterPublic(true)
+	filter.Sort("-id")
+	filter.Limit(1)
+	assertEqual(t, archiveProbeIDs(t, filter), "[4]", "ID list, public, sorting and limit")
+
+	filter = NewProbeFilter()
+	filter.FilterID(2)
+	assertEqual(t, archiveProbeIDs(t, filter), "[2]", "single ID")
+
+	// the archive format is turned into the API format
+	filter = NewProbeFilter()
+	filter.FilterFile("testdata/probes.json.bz2")
+	probes := make(chan AsyncProbeResult)
+	go filter.GetProbes(probes)
+	all := make([]Probe, 0)
+	for probe := range probes {
+		all = append(all, probe.Probe)
+	}
+	assertEqual(t, all[1].Status.Name, "Connected", "status name")
+	assertEqual(t, all[1].StatusSince.String(), "2023-07-22T04:26:40Z", "status since")
+	assertEqual(t, all[1].Location.Coordinates[1], float32(52.0907), "latitude")
+	assertEqual(t, all[0].Tags[0].Slug, "home", "tag slug")
+	assertEqual(t, all[4].Tags[0].Name, "Home", "tag name")
+	assertEqual(t, all[3].FirstConnected == nil, true, "never connected")
+
+	filter = NewProbeFilter()
+	filter.FilterFile("testdata/probes.json.bz2")
+	filter.FilterCountry("NL")
+	count, err := filter.GetProbeCount()
+	if err != nil {
+		t.Fatalf("error counting probes: %v", err)
+	}
+	assertEqual(t, count, uint(3), "probes counted")
+
+	filter = NewProbeFilter()
+	filter.FilterFile("testdata/ping.txt")
+	probes = make(chan AsyncProbeResult)
+	go filter.GetProbes(probes)
+	if probe := <-probes; probe.Error == nil {
+		t.Errorf("results should not be read as a probe archive")
+	}
+	for range probes {
+	}
+}
+
+// Test that probe listings follow the pages, with and without a limit
+func TestGetProbesLimit(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "" {
+			fmt.Fprintf(w, `{"count":3,"next":"%s/probes/?page=2","results":[{"id":1},{"id":2}]}`, server.URL)
+		} else {
+			fmt.Fprint(w, `{"count":3,"next":null,"results":[{"id":3}]}`)
+		}
+	}))
+	defer server.Close()
+	oldbase := apiBaseURL
+	SetAPIBase(server.URL + "/")
+	defer SetAPIBase(oldbase)
+
+	listed := func(filter *ProbeFilter) string {
+		probes := make(chan AsyncProbeResult)
+		go filter.GetProbes(probes)
+		ids := make([]uint, 0)
+		for probe := range probes {
+			if probe.Error != nil {
+				t.Fatalf("error listing probes: %v", probe.Error)
+			}
+			ids = append(ids, probe.Probe.ID)
+		}
+		return fmt.Sprint(ids)
+	}
+
+	assertEqual(t, listed(NewProbeFilter()), "[1 2 3]", "probes without a limit")
+	filter := NewProbeFilter()
+	filter.Limit(2)
+	assertEqual(t, listed(filter), "[1 2]", "probes with a limit")
+}
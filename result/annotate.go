@@ -0,0 +1,286 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// AddressAnnotation is what is known about the origin of an address
+type AddressAnnotation struct {
+	Prefix netip.Prefix // most specific covering prefix; invalid if there's none
+	ASNs   []uint       // origin AS(es); empty if the prefix is not announced
+	Holder string       // name of the origin AS, if known
+	IXP    bool         // the address is on an IXP peering LAN
+}
+
+// Origin returns the (first) origin AS, or 0 if there's none
+func (ann *AddressAnnotation) Origin() uint {
+	if len(ann.ASNs) == 0 {
+		return 0
+	}
+	return ann.ASNs[0]
+}
+
+// Announced tells if the address is covered by an announced prefix
+func (ann *AddressAnnotation) Announced() bool {
+	return len(ann.ASNs) > 0
+}
+
+// AddressAnnotator maps addresses to their origin
+type AddressAnnotator interface {
+	Annotate(addr netip.Addr) (AddressAnnotation, error)
+}
+
+// PrefixTable is a local longest prefix match table of prefixes and their
+// origin ASes, typically loaded from a RIB dump. It is safe to use from
+// multiple goroutines
+type PrefixTable struct {
+	mutex   sync.RWMutex
+	entries map[netip.Prefix]AddressAnnotation // keyed by masked prefix
+	lengths [2][]int                           // prefix lengths present, longest first, for IPv4 and IPv6
+}
+
+// NewPrefixTable makes an empty prefix table
+func NewPrefixTable() *PrefixTable {
+	return &PrefixTable{entries: make(map[netip.Prefix]AddressAnnotation)}
+}
+
+// Add adds a prefix with its origin AS(es), replacing earlier entries for
+// the same prefix
+func (table *PrefixTable) Add(prefix netip.Prefix, asns ...uint) {
+	table.set(prefix, func(ann *AddressAnnotation) {
+		ann.ASNs = asns
+	})
+}
+
+// AddIXP marks a prefix as an IXP peering LAN
+func (table *PrefixTable) AddIXP(prefix netip.Prefix) {
+	table.set(prefix, func(ann *AddressAnnotation) {
+		ann.IXP = true
+	})
+}
+
+// AddAnnotation adds a prefix with all its details
+func (table *PrefixTable) AddAnnotation(ann AddressAnnotation) {
+	table.set(ann.Prefix, func(old *AddressAnnotation) {
+		*old = ann
+	})
+}
+
+func (table *PrefixTable) set(prefix netip.Prefix, update func(*AddressAnnotation)) {
+	prefix = prefix.Masked()
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	ann, ok := table.entries[prefix]
+	update(&ann)
+	ann.Prefix = prefix
+	table.entries[prefix] = ann
+	if !ok {
+		af := familyIndex(prefix.Addr())
+		if !slices.Contains(table.lengths[af], prefix.Bits()) {
+			table.lengths[af] = append(table.lengths[af], prefix.Bits())
+			slices.Sort(table.lengths[af])
+			slices.Reverse(table.lengths[af])
+		}
+	}
+}
+
+// Len returns the number of prefixes in the table
+func (table *PrefixTable) Len() int {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	return len(table.entries)
+}
+
+// Lookup finds the most specific prefix covering an address; the
+// second return value is false if there's none
+func (table *PrefixTable) Lookup(addr netip.Addr) (AddressAnnotation, bool) {
+	addr = addr.Unmap()
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+
+	for _, bits := range table.lengths[familyIndex(addr)] {
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if ann, ok := table.entries[prefix]; ok {
+			return ann, true
+		}
+	}
+	return AddressAnnotation{}, false
+}
+
+// Annotate makes the table an AddressAnnotator; addresses not covered by
+// any prefix get an empty annotation
+func (table *PrefixTable) Annotate(addr netip.Addr) (AddressAnnotation, error) {
+	ann, _ := table.Lookup(addr)
+	return ann, nil
+}
+
+// Load reads prefixes from a text file. Supported formats are CAIDA's
+// pfx2as ("192.0.2.0<tab>24<tab>64500") and "192.0.2.0/24 64500". Multiple
+// origins can be separated by "_" or ","; AS sets ("{64500,64501}") are
+// accepted as well. Empty lines and lines starting with "#" are skipped
+func (table *PrefixTable) Load(from io.Reader) error {
+	scanner := bufio.NewScanner(from)
+	var lineno uint
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		prefix, asns, err := parsePrefixLine(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", lineno, err)
+		}
+		table.Add(prefix, asns...)
+	}
+	return scanner.Err()
+}
+
+func parsePrefixLine(line string) (netip.Prefix, []uint, error) {
+	fields := strings.Fields(line)
+	var pfx, origins string
+	switch {
+	case len(fields) == 3:
+		pfx, origins = fields[0]+"/"+fields[1], fields[2]
+	case len(fields) == 2 && strings.Contains(fields[0], "/"):
+		pfx, origins = fields[0], fields[1]
+	default:
+		return netip.Prefix{}, nil, fmt.Errorf("unrecognised prefix line %q", line)
+	}
+	prefix, err := netip.ParsePrefix(pfx)
+	if err != nil {
+		return netip.Prefix{}, nil, err
+	}
+	asns := make([]uint, 0)
+	for _, asn := range strings.FieldsFunc(origins, func(r rune) bool {
+		return r == '_' || r == ',' || r == '{' || r == '}'
+	}) {
+		n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(asn), "AS"), 10, 32)
+		if err != nil {
+			return netip.Prefix{}, nil, fmt.Errorf("invalid ASN %q", asn)
+		}
+		if !slices.Contains(asns, uint(n)) {
+			asns = append(asns, uint(n))
+		}
+	}
+	return prefix, asns, nil
+}
+
+func familyIndex(addr netip.Addr) int {
+	if addr.Is4() {
+		return 0
+	}
+	return 1
+}
+
+// ASHopKind tells what kind of hop an AS path element is
+type ASHopKind uint
+
+const (
+	ASHopAS          ASHopKind = iota // hops in an announced prefix of an AS
+	ASHopIXP                          // hops on an IXP peering LAN
+	ASHopPrivate                      // hops with private or otherwise special addresses
+	ASHopUnannounced                  // hops with public addresses that are not announced
+)
+
+var asHopKindNames = []string{"as", "ixp", "private", "unannounced"}
+
+func (kind ASHopKind) String() string {
+	if int(kind) < len(asHopKindNames) {
+		return asHopKindNames[kind]
+	}
+	return "unknown"
+}
+
+// ASPathHop is one element of an AS path: consecutive traceroute hops
+// of the same kind (and AS) are merged into one
+type ASPathHop struct {
+	Kind       ASHopKind    //
+	ASN        uint         // origin AS; for IXP hops the AS announcing the LAN, if any
+	Holder     string       // name of the AS, if known
+	HopNumbers []uint       // traceroute hops that belong here
+	Addresses  []netip.Addr // responders on those hops
+}
+
+// ASPath maps the responders of the traceroute to origin ASes, and merges
+// consecutive hops. Unresponsive hops are left out. For load balanced hops
+// the most frequent responder is used
+func (trace *TracerouteResult) ASPath(annotator AddressAnnotator) ([]ASPathHop, error) {
+	path := make([]ASPathHop, 0)
+	for _, hop := range trace.Path() {
+		if !hop.Responded() {
+			continue
+		}
+		elem := ASPathHop{HopNumbers: []uint{hop.HopNumber}, Addresses: []netip.Addr{hop.Address}}
+		if isSpecialAddress(hop.Address) {
+			elem.Kind = ASHopPrivate
+		} else {
+			ann, err := annotator.Annotate(hop.Address)
+			if err != nil {
+				return path, fmt.Errorf("msm %d probe %d hop %d: %v",
+					trace.MeasurementID, trace.ProbeID, hop.HopNumber, err)
+			}
+			switch {
+			case ann.IXP:
+				elem.Kind = ASHopIXP
+			case !ann.Announced():
+				elem.Kind = ASHopUnannounced
+			default:
+				elem.Kind = ASHopAS
+			}
+			elem.ASN = ann.Origin()
+			elem.Holder = ann.Holder
+		}
+
+		if n := len(path); n > 0 && path[n-1].Kind == elem.Kind && path[n-1].ASN == elem.ASN {
+			path[n-1].HopNumbers = append(path[n-1].HopNumbers, hop.HopNumber)
+			if !slices.Contains(path[n-1].Addresses, hop.Address) {
+				path[n-1].Addresses = append(path[n-1].Addresses, hop.Address)
+			}
+			continue
+		}
+		path = append(path, elem)
+	}
+	return path, nil
+}
+
+// ASNs returns the AS numbers along the path, without repetitions,
+// leaving out IXP, private and unannounced hops
+func (trace *TracerouteResult) ASNs(annotator AddressAnnotator) ([]uint, error) {
+	path, err := trace.ASPath(annotator)
+	asns := make([]uint, 0)
+	for _, elem := range path {
+		if elem.Kind == ASHopAS && (len(asns) == 0 || asns[len(asns)-1] != elem.ASN) {
+			asns = append(asns, elem.ASN)
+		}
+	}
+	return asns, err
+}
+
+// shared address space, RFC 6598
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isSpecialAddress tells if an address is private or otherwise not
+// supposed to show up in the global routing table
+func isSpecialAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() ||
+		!addr.IsGlobalUnicast() || sharedAddressSpace.Contains(addr)
+}
@@ -0,0 +1,94 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Test if the prefix table finds the most specific prefix
+func TestPrefixTable(t *testing.T) {
+	table := NewPrefixTable()
+	err := table.Load(strings.NewReader(`
+# CAIDA pfx2as style
+203.0.113.0	24	64500
+203.0.113.128	25	64501_64502
+2001:db8::	32	64503
+# prefix and origin
+198.51.100.0/24 AS64504
+192.0.2.0/24 {64505,64506}
+`))
+	if err != nil {
+		t.Fatalf("Error loading prefix table: %s", err)
+	}
+	table.AddIXP(netip.MustParsePrefix("198.51.100.0/26"))
+	assertEqual(t, table.Len(), 6, "number of prefixes")
+
+	ann, ok := table.Lookup(netip.MustParseAddr("203.0.113.200"))
+	assertEqual(t, ok, true, "address is covered")
+	assertEqual(t, ann.Prefix, netip.MustParsePrefix("203.0.113.128/25"), "most specific prefix")
+	assertEqual(t, slices.Equal(ann.ASNs, []uint{64501, 64502}), true, "MOAS origins")
+	ann, _ = table.Lookup(netip.MustParseAddr("203.0.113.1"))
+	assertEqual(t, ann.Origin(), uint(64500), "less specific origin")
+	ann, _ = table.Lookup(netip.MustParseAddr("2001:db8:1::1"))
+	assertEqual(t, ann.Origin(), uint(64503), "IPv6 origin")
+	ann, _ = table.Lookup(netip.MustParseAddr("::ffff:192.0.2.1"))
+	assertEqual(t, slices.Equal(ann.ASNs, []uint{64505, 64506}), true, "AS set origins for mapped address")
+	ann, _ = table.Lookup(netip.MustParseAddr("198.51.100.1"))
+	assertEqual(t, ann.IXP, true, "IXP prefix")
+	assertEqual(t, ann.Announced(), false, "IXP prefix is not announced itself")
+	_, ok = table.Lookup(netip.MustParseAddr("100.0.0.1"))
+	assertEqual(t, ok, false, "address is not covered")
+
+	err = table.Load(strings.NewReader("203.0.113.0 24 AS-FOO\n"))
+	if err == nil {
+		t.Fatalf("invalid ASN should be reported")
+	}
+}
+
+// Test if AS paths are built correctly from traceroutes
+func TestASPath(t *testing.T) {
+	var trace TracerouteResult
+	err := trace.Parse(`{"fw":5080,"msm_id":5001,"prb_id":11,"timestamp":1700000000,"type":"traceroute","af":4,"dst_addr":"192.0.2.1","result":[
+		{"hop":1,"result":[{"from":"192.168.1.1","rtt":1.2}]},
+		{"hop":2,"result":[{"from":"100.64.0.1","rtt":3.2}]},
+		{"hop":3,"result":[{"from":"203.0.113.1","rtt":5.1}]},
+		{"hop":4,"result":[{"x":"*"}]},
+		{"hop":5,"result":[{"from":"203.0.113.9","rtt":6.1}]},
+		{"hop":6,"result":[{"from":"198.51.100.7","rtt":7.1}]},
+		{"hop":7,"result":[{"from":"100.0.0.1","rtt":8.1}]},
+		{"hop":8,"result":[{"from":"192.0.2.1","rtt":9.1}]}
+	]}`)
+	if err != nil {
+		t.Fatalf("Error parsing traceroute result: %s", err)
+	}
+
+	table := NewPrefixTable()
+	table.Add(netip.MustParsePrefix("203.0.113.0/24"), 64500)
+	table.Add(netip.MustParsePrefix("192.0.2.0/24"), 64505)
+	table.AddIXP(netip.MustParsePrefix("198.51.100.0/24"))
+
+	path, err := trace.ASPath(table)
+	if err != nil {
+		t.Fatalf("Error building AS path: %s", err)
+	}
+	assertEqual(t, len(path), 5, "AS path length")
+	assertEqual(t, path[0].Kind, ASHopPrivate, "private hops")
+	assertEqual(t, slices.Equal(path[0].HopNumbers, []uint{1, 2}), true, "private hops are merged")
+	assertEqual(t, path[1].ASN, uint(64500), "first AS")
+	assertEqual(t, slices.Equal(path[1].HopNumbers, []uint{3, 5}), true, "hops in the first AS")
+	assertEqual(t, path[2].Kind.String(), "ixp", "IXP hop")
+	assertEqual(t, path[3].Kind, ASHopUnannounced, "unannounced hop")
+	assertEqual(t, path[4].ASN, uint(64505), "destination AS")
+
+	asns, err := trace.ASNs(table)
+	assertEqual(t, err, nil, "no error")
+	assertEqual(t, slices.Equal(asns, []uint{64500, 64505}), true, "AS numbers")
+}
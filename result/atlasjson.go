@@ -0,0 +1,147 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// KeepSource makes a parsed result keep its source in BaseResult.Raw,
+// and the top-level fields its parser doesn't know about (e.g. fields
+// added by newer firmware) in BaseResult.Unknown. MarshalJSON emits the
+// unknown fields too
+func KeepSource(res Result, from string) {
+	base := baseOf(res)
+	base.Raw = json.RawMessage(from)
+	base.Unknown = unknownFields(from, knownFields(res))
+}
+
+// atlasEncoder is implemented by results that know their API version
+type atlasEncoder interface {
+	api() any
+}
+
+// knownFields returns the top-level fields a result's parser knows about
+func knownFields(res Result) map[string]bool {
+	if enc, ok := res.(atlasEncoder); ok {
+		return jsonKeys(reflect.TypeOf(enc.api()))
+	}
+	return jsonKeys(reflect.TypeOf(BaseResult{}))
+}
+
+// unknownFields returns the top-level fields of a JSON object that are
+// not in known; nil if there are none or the input is not an object
+func unknownFields(from string, known map[string]bool) map[string]json.RawMessage {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal([]byte(from), &fields) != nil {
+		return nil
+	}
+	var unknown map[string]json.RawMessage
+	for key, value := range fields {
+		if known[key] {
+			continue
+		}
+		if unknown == nil {
+			unknown = make(map[string]json.RawMessage)
+		}
+		unknown[key] = value
+	}
+	return unknown
+}
+
+var jsonKeysCache sync.Map // reflect.Type -> map[string]bool
+
+// jsonKeys returns the JSON field names of a struct type, including
+// the ones in embedded structs
+func jsonKeys(t reflect.Type) map[string]bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if keys, ok := jsonKeysCache.Load(t); ok {
+		return keys.(map[string]bool)
+	}
+	keys := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			for key := range jsonKeys(field.Type) {
+				keys[key] = true
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		switch {
+		case name == "-":
+		case name != "":
+			keys[name] = true
+		case field.IsExported():
+			keys[field.Name] = true
+		}
+	}
+	jsonKeysCache.Store(t, keys)
+	return keys
+}
+
+// base fields that are left out if they are zero
+var optionalBaseFields = []string{"group_id", "bundle", "af", "lts"}
+
+// marshalAtlas encodes the API version of a result: empty and null
+// fields are left out, and unknown fields of the result are added back
+func marshalAtlas(api any, base *BaseResult) ([]byte, error) {
+	b, err := json.Marshal(api)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+	pruneEmpty(fields)
+	for _, key := range optionalBaseFields {
+		if fields[key] == json.Number("0") {
+			delete(fields, key)
+		}
+	}
+	for key, value := range base.Unknown {
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(fields); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// pruneEmpty removes null and "" members from objects, recursively
+func pruneEmpty(value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, member := range v {
+			if member == nil || member == "" {
+				delete(v, key)
+				continue
+			}
+			pruneEmpty(member)
+		}
+	case []any:
+		for _, item := range v {
+			pruneEmpty(item)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Test keeping the source and unknown fields, and re-emitting them
+func TestKeepSource(t *testing.T) {
+	input := `{"fw":5080,"lts":12,"msm_id":1001,"prb_id":11,"timestamp":1700000000,"type":"ping","af":4,"dst_addr":"192.0.2.1","src_addr":"198.51.100.2","from":"198.51.100.2","proto":"ICMP","ttl":55,"size":48,"step":240,"sent":3,"rcvd":2,"dup":0,"min":1.5,"avg":2,"max":2.5,"result":[{"rtt":1.5},{"x":"*"},{"rtt":2.5,"ttl":54}],"newfield":{"a":[1,2]}}`
+	res, err := Parse(input)
+	if err != nil {
+		t.Fatalf("Error parsing ping result: %v", err)
+	}
+	ping := res.(*PingResult)
+	assertEqual(t, ping.Raw == nil && ping.Unknown == nil, true, "nothing is kept by default")
+
+	KeepSource(res, input)
+	assertEqual(t, string(ping.Raw), input, "source")
+	assertEqual(t, len(ping.Unknown), 1, "number of unknown fields")
+	assertEqual(t, string(ping.Unknown["newfield"]), `{"a":[1,2]}`, "unknown field")
+
+	// anonymise and re-emit
+	ping.ProbeID = 99
+	out, err := json.Marshal(ping)
+	if err != nil {
+		t.Fatalf("Error encoding ping result: %v", err)
+	}
+	assertEqual(t, strings.Contains(string(out), `"newfield":{"a":[1,2]}`), true, "unknown field is emitted")
+	assertEqual(t, strings.Contains(string(out), `"result":[{"rtt":1.5},{"x":"*"},{"rtt":2.5,"ttl":54}]`), true, "order of replies and timeouts")
+	assertEqual(t, strings.Contains(string(out), `"null"`) || strings.Contains(string(out), `:null`), false, "no nulls")
+
+	again, err := Parse(string(out))
+	if err != nil {
+		t.Fatalf("Error parsing encoded ping result: %v\n%s", err, out)
+	}
+	ping2 := again.(*PingResult)
+	assertEqual(t, ping2.ProbeID, uint(99), "changed probe ID")
+	assertEqual(t, ping2.MeasurementID, uint(1001), "measurement ID")
+	assertEqual(t, ping2.TimeStamp.String(), ping.TimeStamp.String(), "timestamp")
+	assertEqual(t, *ping2.DestinationAddr, *ping.DestinationAddr, "destination")
+	assertEqual(t, ping2.Ttl, uint(55), "TTL")
+	assertEqual(t, *ping2.Step, uint(240), "step")
+	assertEqual(t, len(ping2.Replies), 2, "replies")
+	assertEqual(t, ping2.Replies[1].Ttl, uint(54), "per reply TTL")
+	assertEqual(t, ping2.Timeouts, uint(1), "timeouts")
+
+	// unknown result types keep everything
+	generic, err := Parse(`{"fw":5080,"msm_id":1001,"prb_id":11,"timestamp":1700000000,"type":"bogus","answer":42}`)
+	if err != nil {
+		t.Fatalf("Error parsing unknown result type: %v", err)
+	}
+	out, _ = json.Marshal(generic)
+	assertEqual(t, string(out), `{"answer":42,"fw":5080,"msm_id":1001,"prb_id":11,"timestamp":1700000000,"type":"bogus"}`, "generic result")
+}
+
+// Test if encoding the results in the fixtures and parsing them again
+// gives the same results
+func TestRoundTrip(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "testdata", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			res, err := Parse(scanner.Text())
+			if err != nil {
+				t.Errorf("%s:%d: error parsing: %v", name, line, err)
+				continue
+			}
+			out, err := json.Marshal(res)
+			if err != nil {
+				t.Errorf("%s:%d: error encoding: %v", name, line, err)
+				continue
+			}
+			again, err := Parse(string(out))
+			if err != nil {
+				t.Errorf("%s:%d: error parsing the encoded result: %v\n%s", name, line, err, out)
+				continue
+			}
+			if _, ok := res.(*GenericResult); ok {
+				// only the source differs, which is fine
+				KeepSource(again, scanner.Text())
+			}
+			normaliseIcmpExtensions(res)
+			normaliseIcmpExtensions(again)
+			if !reflect.DeepEqual(res, again) {
+				t.Errorf("%s:%d: round trip changed the result:\n%+v\n%+v\n%s", name, line, res, again, out)
+			}
+		}
+		file.Close()
+	}
+}
+
+// the raw object data in ICMP extensions is only the same in content
+func normaliseIcmpExtensions(res Result) {
+	trace, ok := res.(*TracerouteResult)
+	if !ok {
+		return
+	}
+	for _, hop := range trace.Hops {
+		for _, resp := range hop.Responses {
+			for _, ext := range resp.IcmpExtensions {
+				for i := range ext.Objects {
+					var data any
+					json.Unmarshal(ext.Objects[i].Data, &data)
+					ext.Objects[i].Data, _ = json.Marshal(data)
+				}
+			}
+		}
+	}
+}
+
+// Test encoding results that were made by hand
+func TestSyntheticResults(t *testing.T) {
+	dst := netip.MustParseAddr("192.0.2.1")
+	ping := &PingResult{
+		BaseResult: BaseResult{
+			FirmwareVersion: 5080,
+			MeasurementID:   1001,
+			ProbeID:         11,
+			Type:            "ping",
+			TimeStamp:       uniTime(time.Unix(1700000000, 0)),
+			DestinationAddr: &dst,
+			AddressFamily:   4,
+		},
+		Sent: 2, Received: 1,
+		Minimum: 12.5, Average: 12.5, Maximum: 12.5,
+		Replies:  []PingReply{{Rtt: 12.5, Source: dst, Ttl: 55}},
+		Timeouts: 1,
+	}
+	out, err := json.Marshal(ping)
+	if err != nil {
+		t.Fatalf("Error encoding ping result: %v", err)
+	}
+	res, err := Parse(string(out))
+	if err != nil {
+		t.Fatalf("Error parsing encoded ping result: %v\n%s", err, out)
+	}
+	again := res.(*PingResult)
+	assertEqual(t, again.ProbeID, uint(11), "probe ID")
+	assertEqual(t, again.GetTimeStamp().Unix(), int64(1700000000), "timestamp")
+	assertEqual(t, again.Replies[0], ping.Replies[0], "reply")
+	assertEqual(t, again.Timeouts, uint(1), "timeouts")
+	assertEqual(t, again.Median, 12.5, "median")
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	msg.Response = true
+	rr, _ := dns.NewRR("example.com. 300 IN A 192.0.2.80")
+	msg.Answer = append(msg.Answer, rr)
+	dnsres := &DnsResult{
+		BaseResult: BaseResult{FirmwareVersion: 5080, MeasurementID: 2001, ProbeID: 11, Type: "dns"},
+		Responses: []DnsResponse{{
+			TimeStamp:    time.Unix(1700000000, 0),
+			Destination:  netip.MustParseAddrPort("192.0.2.53:53"),
+			Protocol:     "UDP",
+			ResponseTime: 10,
+			AnswerMsg:    msg,
+		}},
+	}
+	out, err = json.Marshal(dnsres)
+	if err != nil {
+		t.Fatalf("Error encoding DNS result: %v", err)
+	}
+	assertEqual(t, dnsres.Responses[0].AnswerBuf == nil, true, "encoding does not change the result")
+	res, err = Parse(string(out))
+	if err != nil {
+		t.Fatalf("Error parsing encoded DNS result: %v\n%s", err, out)
+	}
+	resp := res.(*DnsResult).Responses[0]
+	assertEqual(t, resp.Destination, dnsres.Responses[0].Destination, "destination")
+	assertEqual(t, resp.AnswerCount, uint(1), "answer count")
+	assertEqual(t, resp.Answer[0].Data, "192.0.2.80", "answer")
+}
@@ -32,6 +32,9 @@ type BaseResult struct {
 	FromAddr        netip.Addr      `json:"from"`             // IP address of the probe as known by the infra
 	AddressFamily   uint            `json:"af"`               // 4 or 6
 	ResolveTime     *float64        `json:"ttr"`              // only if resolve-on-probe was used
+
+	Raw     json.RawMessage            `json:"-"` // the source of the result, if kept (see KeepSource)
+	Unknown map[string]json.RawMessage `json:"-"` // top-level fields the parser doesn't know about, if kept
 }
 
 func (result *BaseResult) Parse(from string) (err error) {
@@ -80,6 +83,12 @@ func (result *BaseResult) GetTimeStamp() time.Time {
 	return time.Time(result.TimeStamp)
 }
 
+// GetStoreTimeStamp returns when the result was stored by the API; zero
+// if that's unknown
+func (result *BaseResult) GetStoreTimeStamp() time.Time {
+	return time.Time(result.StoreTimeStamp)
+}
+
 func (result *BaseResult) GetProbeID() uint {
 	return result.ProbeID
 }
@@ -7,6 +7,7 @@
 package result
 
 import (
+	"errors"
 	"net/netip"
 	"testing"
 )
@@ -100,3 +101,35 @@ func TestBaseParser(t *testing.T) {
 	assertEqual(t, base.MeasurementName, "Meh", "error parsing base field value for msm_name")
 	assertEqual(t, base.Type, "meh", "error parsing base field value for type")
 }
+
+// Test if parsers report errors with context instead of crashing
+func TestParseErrors(t *testing.T) {
+	inputs := map[string]string{
+		"no type":       `{"fw":5080,"msm_id":1001,"prb_id":11}`,
+		"bad field":     `{"fw":5080,"msm_id":1001,"prb_id":11,"type":"ping","sent":"three"}`,
+		"bad cert":      `{"fw":5080,"msm_id":1001,"prb_id":11,"type":"sslcert","method":"TLS","cert":["garbage"]}`,
+		"bad ping item": `{"fw":5080,"msm_id":1001,"prb_id":11,"type":"ping","result":[{"rtt":"fast"}]}`,
+	}
+	for name, input := range inputs {
+		_, err := Parse(input)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("%s: expected a ParseError, got %v", name, err)
+		}
+		if perr.MeasurementID != 1001 || perr.ProbeID != 11 {
+			t.Errorf("%s: ParseError lacks context: %v", name, perr)
+		}
+	}
+
+	// missing optional fields should not crash the parsers
+	for _, input := range []string{
+		`{"fw":5080,"type":"ping","result":[{"rtt":1.5}]}`,
+		`{"fw":5080,"type":"traceroute","result":[{"hop":1},{"hop":2,"result":[{"from":"192.0.2.1"},{"x":"*"}]}]}`,
+		`{"fw":5080,"type":"sslcert","method":"TLS"}`,
+		`{"fw":5080,"type":"ntp","result":[{"x":"*"},{"rtt":0.1}]}`,
+	} {
+		if _, err := Parse(input); err != nil {
+			t.Errorf("unexpected error for %s: %v", input, err)
+		}
+	}
+}
@@ -10,8 +10,7 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
-	"fmt"
-	"log"
+	"errors"
 )
 
 type CertResult struct {
@@ -30,8 +29,8 @@ type CertResult struct {
 // CertAlert is an error that could be sent by the server
 // see RFC 5246 section 7.2
 type CertAlert struct {
-	Level       uint //
-	Description uint //
+	Level       uint `json:"level"`       //
+	Description uint `json:"description"` //
 }
 
 const (
@@ -43,14 +42,19 @@ func (result *CertResult) TypeName() string {
 	return "sslcert"
 }
 
+// MarshalJSON encodes the result in the format of the API
+func (cert *CertResult) MarshalJSON() ([]byte, error) {
+	return marshalAtlas(cert.api(), &cert.BaseResult)
+}
+
 func (cert *CertResult) Parse(from string) (err error) {
 	var icert certResult
 	err = json.Unmarshal([]byte(from), &icert)
 	if err != nil {
-		return err
+		return parseError(&icert.BaseResult, "sslcert", err)
 	}
 	if icert.Type != "sslcert" {
-		return fmt.Errorf("this is not a TLS/SSL certificate result (type=%s)", icert.Type)
+		return parseErrorf(&icert.BaseResult, "sslcert", "this is not a TLS/SSL certificate result (type=%s)", icert.Type)
 	}
 	cert.BaseResult = icert.BaseResult
 	cert.Alert = icert.Alert
@@ -60,16 +64,16 @@ func (cert *CertResult) Parse(from string) (err error) {
 			cert.DnsError = *icert.DnsError
 		} else {
 			// we use dnserror as a hint that there's no real data
-			cert.Method = *icert.Method
-			cert.ReplyTime = *icert.ReplyTime
-			cert.ConnectTime = *icert.ConnectTime
-			// if there's an alert, there's no other real data
+			cert.Method = valueOr(icert.Method, "")
+			cert.ReplyTime = valueOr(icert.ReplyTime, 0)
+			cert.ConnectTime = valueOr(icert.ConnectTime, 0)
+			cert.ServerCipher = valueOr(icert.ServerCipher, "")
+			cert.ProtocolVersion = valueOr(icert.ProtocolVersion, "")
+			// if there's an alert, there are no certificates
 			if icert.Alert == nil {
-				cert.ServerCipher = *icert.ServerCipher
-				cert.ProtocolVersion = *icert.ProtocolVersion
 				cert.Certificates, err = icert.Certificates()
 				if err != nil {
-					return nil
+					return parseError(&icert.BaseResult, "sslcert", err)
 				}
 			}
 		}
@@ -102,7 +106,7 @@ func (result *certResult) Certificates() (list []x509.Certificate, err error) {
 	for _, item := range *result.RawCertificates {
 		block, _ := pem.Decode([]byte(item))
 		if block == nil || block.Type != "CERTIFICATE" {
-			log.Fatal("failed to decode PEM block containing certificate")
+			return list, errors.New("failed to decode PEM block containing certificate")
 		}
 		var cert *x509.Certificate
 		cert, err = x509.ParseCertificate(block.Bytes)
@@ -113,3 +117,39 @@ func (result *certResult) Certificates() (list []x509.Certificate, err error) {
 	}
 	return list, nil
 }
+
+// api converts a certificate result to the API version
+func (cert *CertResult) api() any {
+	icert := certResult{
+		BaseResult: cert.BaseResult,
+		Alert:      cert.Alert,
+		Error:      cert.Error,
+	}
+	if cert.DnsError != "" {
+		icert.DnsError = &cert.DnsError
+	}
+	if cert.Error != nil || cert.DnsError != "" {
+		return &icert
+	}
+	icert.Method = &cert.Method
+	icert.ReplyTime = &cert.ReplyTime
+	icert.ConnectTime = &cert.ConnectTime
+	if cert.Alert == nil {
+		icert.ServerCipher = &cert.ServerCipher
+		icert.ProtocolVersion = &cert.ProtocolVersion
+		certs := make([]string, 0, len(cert.Certificates))
+		for _, c := range cert.Certificates {
+			certs = append(certs, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})))
+		}
+		icert.RawCertificates = &certs
+		return &icert
+	}
+	// alerts may come with or without a version and a cipher
+	if cert.ServerCipher != "" {
+		icert.ServerCipher = &cert.ServerCipher
+	}
+	if cert.ProtocolVersion != "" {
+		icert.ProtocolVersion = &cert.ProtocolVersion
+	}
+	return &icert
+}
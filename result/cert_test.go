@@ -0,0 +1,139 @@
+/*
+  (C) 2022 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// make a certificate signed by parent (or self-signed if parent is nil)
+func makeTestCert(t *testing.T, cn string, names []string, ca bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Unix(1690000000, 0),
+		NotAfter:              time.Unix(1700000000+30*86400, 0),
+		DNSNames:              names,
+		IsCA:                  ca,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// make an sslcert result with a chain
+func makeCertResult(t *testing.T, probe uint, chain ...string) *CertResult {
+	t.Helper()
+	certs, _ := json.Marshal(chain)
+	var cert CertResult
+	err := cert.Parse(fmt.Sprintf(`{"fw":5080,"msm_id":15001,"prb_id":%d,"timestamp":1700000000,"type":"sslcert",
+		"dst_name":"www.example.com","dst_addr":"192.0.2.43","dst_port":"443","af":4,
+		"method":"TLS","ver":"1.2","rt":20.0,"ttc":10.0,"server_cipher":"C02F","cert":%s}`, probe, certs))
+	if err != nil {
+		t.Fatalf("Error parsing sslcert result: %s", err)
+	}
+	return &cert
+}
+
+// Test chain verification, hostname checks and chain comparison
+func TestCertAnalysis(t *testing.T) {
+	root, rootKey, _ := makeTestCert(t, "Test Root", nil, true, nil, nil)
+	inter, interKey, interPEM := makeTestCert(t, "Test Intermediate", nil, true, root, rootKey)
+	_, _, leafPEM := makeTestCert(t, "www.example.com", []string{"www.example.com", "example.com"}, false, inter, interKey)
+
+	cert := makeCertResult(t, 1, leafPEM, interPEM)
+	assertEqual(t, len(cert.Certificates), 2, "wrong number of certificates")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	verification := cert.Verify(CertVerifyOptions{Roots: roots})
+	if !verification.Valid() {
+		t.Fatalf("chain should be valid: %v / %v", verification.ChainError, verification.HostnameError)
+	}
+	assertEqual(t, verification.Hostname, "www.example.com", "wrong hostname used for verification")
+	assertEqual(t, len(verification.Chains[0]), 3, "wrong verified chain length")
+	assertEqual(t, verification.Leaf.KeyType, "ECDSA", "wrong key type")
+	assertEqual(t, verification.Leaf.KeySize, 256, "wrong key size")
+	assertEqual(t, verification.Leaf.DaysRemaining, 30, "wrong number of days remaining")
+	assertEqual(t, verification.Leaf.SignatureAlgorithm, "ECDSA-SHA256", "wrong signature algorithm")
+
+	if cert.Verify(CertVerifyOptions{Roots: roots, Hostname: "www.example.net"}).HostnameError == nil {
+		t.Errorf("hostname mismatch was not detected")
+	}
+	if cert.Verify(CertVerifyOptions{Roots: x509.NewCertPool()}).ChainError == nil {
+		t.Errorf("untrusted chain was not detected")
+	}
+	if cert.Verify(CertVerifyOptions{Roots: roots, CurrentTime: time.Unix(1800000000, 0)}).ChainError == nil {
+		t.Errorf("expired chain was not detected")
+	}
+	assertEqual(t, cert.MatchesHostname("example.com"), true, "SAN matching failed")
+
+	// an interceptor with its own "root"
+	fake, fakeKey, _ := makeTestCert(t, "Interceptor", nil, true, nil, nil)
+	_, _, fakeLeafPEM := makeTestCert(t, "www.example.com", []string{"www.example.com"}, false, fake, fakeKey)
+
+	comparer := NewCertChainComparer()
+	comparer.Add(cert)
+	comparer.Add(makeCertResult(t, 2, leafPEM, interPEM))
+	comparer.Add(makeCertResult(t, 3, fakeLeafPEM))
+	comparer.Add(makeCertResult(t, 4, leafPEM, interPEM))
+	_, count := comparer.Majority()
+	assertEqual(t, count, uint(3), "wrong majority count")
+	deviations := comparer.Deviations()
+	assertEqual(t, len(deviations), 1, "wrong number of deviations")
+	assertEqual(t, deviations[0].ProbeID, uint(3), "wrong deviating probe")
+	assertEqual(t, fmt.Sprint(deviations[0].Differences), "[0 1]", "wrong deviating positions")
+}
+
+// Test if alerts keep the version and cipher when encoded
+func TestCertAlertEncoding(t *testing.T) {
+	var cert CertResult
+	err := cert.Parse(`{"fw":5080,"msm_id":15001,"prb_id":5,"timestamp":1700000000,"type":"sslcert",
+		"dst_name":"www.example.com","dst_addr":"192.0.2.43","dst_port":"443","af":4,
+		"method":"TLS","ver":"1.2","rt":20.0,"ttc":10.0,"server_cipher":"C02F","alert":{"level":2,"description":40}}`)
+	if err != nil {
+		t.Fatalf("Error parsing sslcert result: %s", err)
+	}
+	out, err := json.Marshal(&cert)
+	if err != nil {
+		t.Fatalf("Error encoding sslcert result: %s", err)
+	}
+	var again CertResult
+	if err := again.Parse(string(out)); err != nil {
+		t.Fatalf("Error parsing encoded sslcert result: %s\n%s", err, out)
+	}
+	assertEqual(t, again.ProtocolVersion, "1.2", "version of an alert")
+	assertEqual(t, again.ServerCipher, "C02F", "cipher of an alert")
+	assertEqual(t, again.Alert.Description, uint(40), "alert description")
+}
@@ -0,0 +1,271 @@
+/*
+  (C) 2022 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CertInfo is an overview of one certificate
+type CertInfo struct {
+	Subject            string    //
+	Issuer             string    //
+	SerialNumber       string    //
+	NotBefore          time.Time //
+	NotAfter           time.Time //
+	DaysRemaining      int       // until expiry, counted from the time of the measurement; negative if expired
+	KeyType            string    // RSA, ECDSA, Ed25519 or unknown
+	KeySize            int       // in bits
+	SignatureAlgorithm string    //
+	DNSNames           []string  // from the SAN extension
+	IPAddresses        []string  // from the SAN extension
+	IsCA               bool      //
+	Fingerprint        string    // SHA256 of the certificate, hex
+}
+
+// CertVerifyOptions are the knobs for certificate chain verification
+type CertVerifyOptions struct {
+	Roots       *x509.CertPool // trusted roots; nil means the system roots
+	Hostname    string         // name to check; "" means the measurement's target name
+	CurrentTime time.Time      // zero means the time of the measurement
+}
+
+// CertVerification is the outcome of verifying a certificate chain
+type CertVerification struct {
+	Hostname      string                // the name that was checked
+	Chains        [][]*x509.Certificate // verified chains, if any
+	ChainError    error                 // why the chain could not be verified, nil if it could
+	HostnameError error                 // why the hostname does not match the leaf, nil if it does
+	Leaf          *CertInfo             // overview of the leaf certificate
+}
+
+// Valid tells if both the chain and the hostname check out
+func (v *CertVerification) Valid() bool {
+	return v.ChainError == nil && v.HostnameError == nil
+}
+
+// CertificateInfo makes an overview of a certificate, with expiry counted from a point in time
+func CertificateInfo(cert *x509.Certificate, at time.Time) CertInfo {
+	fp := sha256.Sum256(cert.Raw)
+	info := CertInfo{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		DaysRemaining:      int(cert.NotAfter.Sub(at).Hours() / 24),
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		DNSNames:           cert.DNSNames,
+		IPAddresses:        make([]string, 0),
+		IsCA:               cert.IsCA,
+		Fingerprint:        hex.EncodeToString(fp[:]),
+	}
+	if cert.SerialNumber != nil {
+		info.SerialNumber = cert.SerialNumber.Text(16)
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	info.KeyType, info.KeySize = publicKeyDetails(cert.PublicKey)
+	return info
+}
+
+func publicKeyDetails(key any) (string, int) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return "RSA", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	default:
+		return "unknown", 0
+	}
+}
+
+// ChainInfo gives an overview of all certificates sent by the server
+func (cert *CertResult) ChainInfo() []CertInfo {
+	infos := make([]CertInfo, 0, len(cert.Certificates))
+	for i := range cert.Certificates {
+		infos = append(infos, CertificateInfo(&cert.Certificates[i], cert.GetTimeStamp()))
+	}
+	return infos
+}
+
+// Leaf returns the server's own certificate, or nil if there's none
+func (cert *CertResult) Leaf() *x509.Certificate {
+	if len(cert.Certificates) == 0 {
+		return nil
+	}
+	return &cert.Certificates[0]
+}
+
+// Hostname returns the name the certificate is expected to be valid for:
+// the target name of the measurement, unless that is an IP address
+func (cert *CertResult) Hostname() string {
+	if _, err := netip.ParseAddr(cert.DestinationName); err == nil {
+		return ""
+	}
+	return cert.DestinationName
+}
+
+// MatchesHostname tells if the leaf certificate is valid for a name
+// (or an IP address), according to its subject alternative names
+func (cert *CertResult) MatchesHostname(name string) bool {
+	leaf := cert.Leaf()
+	return leaf != nil && leaf.VerifyHostname(name) == nil
+}
+
+// Verify verifies the certificate chain sent by the server against a set
+// of trusted roots, and checks the leaf certificate against the hostname
+func (cert *CertResult) Verify(opts CertVerifyOptions) CertVerification {
+	var verification CertVerification
+
+	at := opts.CurrentTime
+	if at.IsZero() {
+		at = cert.GetTimeStamp()
+	}
+	verification.Hostname = opts.Hostname
+	if verification.Hostname == "" {
+		verification.Hostname = cert.Hostname()
+	}
+
+	leaf := cert.Leaf()
+	if leaf == nil {
+		verification.ChainError = certError(cert, "no certificates in result")
+		verification.HostnameError = verification.ChainError
+		return verification
+	}
+	info := CertificateInfo(leaf, at)
+	verification.Leaf = &info
+
+	intermediates := x509.NewCertPool()
+	for i := 1; i < len(cert.Certificates); i++ {
+		intermediates.AddCert(&cert.Certificates[i])
+	}
+	verification.Chains, verification.ChainError = leaf.Verify(x509.VerifyOptions{
+		Roots:         opts.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+	})
+
+	if verification.Hostname == "" {
+		verification.HostnameError = certError(cert, "no hostname to verify against")
+	} else {
+		verification.HostnameError = leaf.VerifyHostname(verification.Hostname)
+	}
+
+	return verification
+}
+
+// ChainFingerprints returns the SHA256 fingerprints of the certificates
+// sent by the server, in the order they were sent
+func (cert *CertResult) ChainFingerprints() []string {
+	fps := make([]string, 0, len(cert.Certificates))
+	for i := range cert.Certificates {
+		fp := sha256.Sum256(cert.Certificates[i].Raw)
+		fps = append(fps, hex.EncodeToString(fp[:]))
+	}
+	return fps
+}
+
+// CertChainDeviation describes a result where the server sent a chain
+// that differs from what most probes saw
+type CertChainDeviation struct {
+	MeasurementID uint      //
+	ProbeID       uint      //
+	TimeStamp     time.Time //
+	Fingerprints  []string  // what this probe saw
+	Majority      []string  // what most probes saw
+	Differences   []int     // positions in the chain that differ
+}
+
+// CertChainComparer collects certificate chains seen by probes, and
+// reports the ones that differ from the majority. This helps detecting
+// TLS interception seen from some networks
+type CertChainComparer struct {
+	seen   []CertChainDeviation // all observations; Majority and Differences are filled in later
+	counts map[string]uint      // number of times each chain was seen
+}
+
+// NewCertChainComparer prepares a new comparer
+func NewCertChainComparer() *CertChainComparer {
+	return &CertChainComparer{
+		seen:   make([]CertChainDeviation, 0),
+		counts: make(map[string]uint),
+	}
+}
+
+// Add adds a result to the comparison; results without certificates are ignored
+func (cc *CertChainComparer) Add(cert *CertResult) {
+	fps := cert.ChainFingerprints()
+	if len(fps) == 0 {
+		return
+	}
+	cc.seen = append(cc.seen, CertChainDeviation{
+		MeasurementID: cert.MeasurementID,
+		ProbeID:       cert.ProbeID,
+		TimeStamp:     cert.GetTimeStamp(),
+		Fingerprints:  fps,
+	})
+	cc.counts[strings.Join(fps, ",")]++
+}
+
+// Majority returns the chain seen most often (ties are broken by the
+// fingerprints, for stability) and how many times it was seen
+func (cc *CertChainComparer) Majority() ([]string, uint) {
+	keys := make([]string, 0, len(cc.counts))
+	for key := range cc.counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	best := ""
+	var bestCount uint
+	for _, key := range keys {
+		if cc.counts[key] > bestCount {
+			best, bestCount = key, cc.counts[key]
+		}
+	}
+	if best == "" {
+		return nil, 0
+	}
+	return strings.Split(best, ","), bestCount
+}
+
+// Deviations lists the results where the chain differs from the majority
+func (cc *CertChainComparer) Deviations() []CertChainDeviation {
+	majority, _ := cc.Majority()
+	deviations := make([]CertChainDeviation, 0)
+	for _, obs := range cc.seen {
+		diff := make([]int, 0)
+		for i := 0; i < max(len(obs.Fingerprints), len(majority)); i++ {
+			if i >= len(obs.Fingerprints) || i >= len(majority) || obs.Fingerprints[i] != majority[i] {
+				diff = append(diff, i)
+			}
+		}
+		if len(diff) > 0 {
+			obs.Majority = majority
+			obs.Differences = diff
+			deviations = append(deviations, obs)
+		}
+	}
+	return deviations
+}
+
+// certError makes an error that carries the measurement and probe IDs
+func certError(cert *CertResult, msg string) error {
+	return fmt.Errorf("msm %d probe %d: %s", cert.MeasurementID, cert.ProbeID, msg)
+}
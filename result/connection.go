@@ -8,7 +8,6 @@ package result
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/netip"
 )
 
@@ -24,14 +23,19 @@ func (result *ConnectionResult) TypeName() string {
 	return "connection"
 }
 
+// MarshalJSON encodes the result in the format of the API
+func (conn *ConnectionResult) MarshalJSON() ([]byte, error) {
+	return marshalAtlas(conn.api(), &conn.BaseResult)
+}
+
 func (conn *ConnectionResult) Parse(from string) (err error) {
 	var iconn connectionResult
 	err = json.Unmarshal([]byte(from), &iconn)
 	if err != nil {
-		return err
+		return parseError(&iconn.BaseResult, "connection", err)
 	}
 	if iconn.Type != "connection" {
-		return fmt.Errorf("this is not a connection result (type=%s)", iconn.Type)
+		return parseErrorf(&iconn.BaseResult, "connection", "this is not a connection result (type=%s)", iconn.Type)
 	}
 	conn.BaseResult = iconn.BaseResult
 	conn.Event = iconn.Event
@@ -43,13 +47,18 @@ func (conn *ConnectionResult) Parse(from string) (err error) {
 }
 
 //////////////////////////////////////////////////////
-// API version of an uptime result
+// API version of a connection result
 
 // this is the JSON structure as reported by the API
 type connectionResult struct {
 	BaseResult
-	Event      string       `json:"event"`      //
-	Controller string       `json:"controller"` //
-	Asn        uint         `json:"asn"`        //
-	Prefix     netip.Prefix `json:"prefix"`     //
+	Event      string       `json:"event"`         //
+	Controller string       `json:"controller"`    //
+	Asn        uint         `json:"asn,omitempty"` // not on disconnect events
+	Prefix     netip.Prefix `json:"prefix"`        //
+}
+
+// api converts a connection result to the API version
+func (conn *ConnectionResult) api() any {
+	return &connectionResult{conn.BaseResult, conn.Event, conn.Controller, conn.Asn, conn.Prefix}
 }
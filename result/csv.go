@@ -0,0 +1,691 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSV export flattens results into rows. Every schema starts with the
+// same base columns:
+//
+//	msm_id, prb_id, timestamp, type, fw, af, src_addr, from, dst_name, dst_addr
+//
+// and continues with its own columns:
+//
+//	base            any result, one row per result; no extra columns
+//	ping            one row per ping result:
+//	                proto, size, ttl, sent, rcvd, dup, min, avg, median, max,
+//	                timeouts, errors, loss, jitter
+//	ping-reply      one row per ping reply:
+//	                reply, rtt, reply_src, reply_ttl, duplicate
+//	traceroute      one row per traceroute result:
+//	                proto, paris_id, size, endtime, hops, last_hop, last_hop_from,
+//	                last_hop_rtt, unreachable, loop
+//	traceroute-hop  one row per hop response (or per hop, if it had none):
+//	                hop, hop_error, reply, reply_from, rtt, reply_size, reply_ttl,
+//	                err, error, timeout, late, ittl, mtu, mpls
+//	dns-response    one row per DNS response (or per result, if it had none):
+//	                response, resolver, proto, rt, size, id, rcode, ancount,
+//	                nscount, arcount, nsid, answers, error
+//	dns-answer      one row per resource record in a DNS response:
+//	                response, resolver, rcode, section, name, class, rrtype,
+//	                ttl, data
+//	http-reply      one row per HTTP reply:
+//	                reply, reply_af, reply_src, reply_dst, method, ver, res,
+//	                hsize, bsize, rt, ttr, ttc, ttfb, server, content_type,
+//	                location, dnserr, err
+//	ntp             one row per NTP result:
+//	                proto, version, li, mode, stratum, poll, precision,
+//	                root_delay, root_dispersion, ref_id, replies, timeouts,
+//	                offset, delay, synchronized
+//	sslcert         one row per certificate result:
+//	                method, ver, cipher, ttc, rt, certs, leaf_subject,
+//	                leaf_issuer, leaf_not_after, leaf_days_remaining,
+//	                hostname_match, alert_level, alert_description, dnserr, err
+//	uptime          uptime
+//	connection      event, controller, asn, prefix
+//	wifi            ssid, bssid, ip, key_mgmt, state, connect_time, error
+//
+// Times are in ISO8601 (UTC), missing values and unavailable statistics
+// (e.g. the minimum RTT of a ping without replies) are empty. The field
+// names follow those of the API where there is one
+
+// CsvBaseColumns are the columns every CSV schema starts with
+var CsvBaseColumns = []string{
+	"msm_id", "prb_id", "timestamp", "type", "fw", "af", "src_addr", "from", "dst_name", "dst_addr",
+}
+
+// one CSV schema: the type of results it applies to ("" means any), the
+// columns after the base columns and how to make rows out of a result
+type csvSchema struct {
+	resultType string
+	columns    []string
+	rows       func(res Result) [][]string
+}
+
+var csvSchemas = map[string]csvSchema{
+	"base": {"", nil, func(res Result) [][]string {
+		return [][]string{nil}
+	}},
+	"ping": {"ping", []string{
+		"proto", "size", "ttl", "sent", "rcvd", "dup", "min", "avg", "median", "max",
+		"timeouts", "errors", "loss", "jitter",
+	}, csvPingRows},
+	"ping-reply": {"ping", []string{
+		"reply", "rtt", "reply_src", "reply_ttl", "duplicate",
+	}, csvPingReplyRows},
+	"traceroute": {"traceroute", []string{
+		"proto", "paris_id", "size", "endtime", "hops", "last_hop", "last_hop_from",
+		"last_hop_rtt", "unreachable", "loop",
+	}, csvTracerouteRows},
+	"traceroute-hop": {"traceroute", []string{
+		"hop", "hop_error", "reply", "reply_from", "rtt", "reply_size", "reply_ttl",
+		"err", "error", "timeout", "late", "ittl", "mtu", "mpls",
+	}, csvTracerouteHopRows},
+	"dns-response": {"dns", []string{
+		"response", "resolver", "proto", "rt", "size", "id", "rcode", "ancount",
+		"nscount", "arcount", "nsid", "answers", "error",
+	}, csvDnsResponseRows},
+	"dns-answer": {"dns", []string{
+		"response", "resolver", "rcode", "section", "name", "class", "rrtype", "ttl", "data",
+	}, csvDnsAnswerRows},
+	"http-reply": {"http", []string{
+		"reply", "reply_af", "reply_src", "reply_dst", "method", "ver", "res",
+		"hsize", "bsize", "rt", "ttr", "ttc", "ttfb", "server", "content_type",
+		"location", "dnserr", "err",
+	}, csvHttpReplyRows},
+	"ntp": {"ntp", []string{
+		"proto", "version", "li", "mode", "stratum", "poll", "precision",
+		"root_delay", "root_dispersion", "ref_id", "replies", "timeouts",
+		"offset", "delay", "synchronized",
+	}, csvNtpRows},
+	"sslcert": {"sslcert", []string{
+		"method", "ver", "cipher", "ttc", "rt", "certs", "leaf_subject",
+		"leaf_issuer", "leaf_not_after", "leaf_days_remaining",
+		"hostname_match", "alert_level", "alert_description", "dnserr", "err",
+	}, csvCertRows},
+	"uptime": {"uptime", []string{"uptime"}, csvUptimeRows},
+	"connection": {"connection", []string{
+		"event", "controller", "asn", "prefix",
+	}, csvConnectionRows},
+	"wifi": {"wifi", []string{
+		"ssid", "bssid", "ip", "key_mgmt", "state", "connect_time", "error",
+	}, csvWifiRows},
+}
+
+// CsvSchemas returns the names of the available CSV schemas
+func CsvSchemas() []string {
+	names := make([]string, 0, len(csvSchemas))
+	for name := range csvSchemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CsvColumns returns all the columns of a CSV schema, in their default order
+func CsvColumns(schema string) ([]string, error) {
+	s, ok := csvSchemas[schema]
+	if !ok {
+		return nil, fmt.Errorf("unknown CSV schema: %s", schema)
+	}
+	return slices.Concat(CsvBaseColumns, s.columns), nil
+}
+
+// CsvRows returns the rows a result makes in a CSV schema, with all the
+// columns of the schema (see CsvColumns). Results of other types make no rows
+func CsvRows(schema string, res Result) ([][]string, error) {
+	s, ok := csvSchemas[schema]
+	if !ok {
+		return nil, fmt.Errorf("unknown CSV schema: %s", schema)
+	}
+	if s.resultType != "" && res.TypeName() != s.resultType {
+		return nil, nil
+	}
+	base := csvBaseRow(baseOf(res))
+	rows := s.rows(res)
+	for i, row := range rows {
+		rows[i] = slices.Concat(base, row)
+	}
+	return rows, nil
+}
+
+// CsvWriter writes results as CSV (or TSV) rows using one of the schemas
+// above. Results of other types are skipped. It is not safe for
+// concurrent use
+type CsvWriter struct {
+	out        *csv.Writer //
+	name       string      // name of the schema
+	schema     csvSchema   //
+	all        []string    // all columns of the schema
+	columns    []int       // the columns to write, as indexes into all
+	header     bool        // write a header line
+	headerDone bool        //
+	Skipped    uint        // number of results not matching the schema
+	Errors     uint        // number of errors seen
+}
+
+// NewCsvWriter prepares a CSV writer that writes rows in the named schema
+// to w. By default all columns of the schema are written, with a header
+func NewCsvWriter(w io.Writer, schema string) (*CsvWriter, error) {
+	all, err := CsvColumns(schema)
+	if err != nil {
+		return nil, err
+	}
+	writer := &CsvWriter{
+		out:    csv.NewWriter(w),
+		name:   schema,
+		schema: csvSchemas[schema],
+		all:    all,
+		header: true,
+	}
+	writer.columns = make([]int, len(all))
+	for i := range all {
+		writer.columns[i] = i
+	}
+	return writer, nil
+}
+
+// Columns selects which columns to write, and in what order
+func (writer *CsvWriter) Columns(columns ...string) error {
+	if writer.headerDone {
+		return fmt.Errorf("columns cannot be changed after writing has started")
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("no columns selected")
+	}
+	selected := make([]int, 0, len(columns))
+	for _, column := range columns {
+		i := slices.Index(writer.all, column)
+		if i < 0 {
+			return fmt.Errorf("unknown column for this schema: %s", column)
+		}
+		selected = append(selected, i)
+	}
+	writer.columns = selected
+	return nil
+}
+
+// Header sets whether a header line is written (default: yes)
+func (writer *CsvWriter) Header(header bool) {
+	writer.header = header
+}
+
+// Comma sets the field delimiter, e.g. '\t' for TSV (default: ',')
+func (writer *CsvWriter) Comma(comma rune) {
+	writer.out.Comma = comma
+}
+
+// Write writes the rows for one result. Results of a type that doesn't
+// match the schema are skipped
+func (writer *CsvWriter) Write(res Result) error {
+	if err := writer.writeHeader(); err != nil {
+		return err
+	}
+	if writer.schema.resultType != "" && res.TypeName() != writer.schema.resultType {
+		writer.Skipped++
+		return nil
+	}
+
+	rows, err := CsvRows(writer.name, res)
+	if err != nil {
+		return err
+	}
+	line := make([]string, len(writer.columns))
+	for _, row := range rows {
+		for i, column := range writer.columns {
+			line[i] = row[column]
+		}
+		if err := writer.out.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Consume reads results from a channel until it is closed and writes
+// them, then flushes the output. Errors on the channel are counted. It
+// returns the first error of writing, but keeps draining the channel
+func (writer *CsvWriter) Consume(results chan AsyncResult) error {
+	var failed error
+	for res := range results {
+		if res.Error != nil {
+			writer.Errors++
+			continue
+		}
+		if failed == nil {
+			failed = writer.Write(*res.Result)
+		}
+	}
+	if err := writer.Flush(); failed == nil {
+		failed = err
+	}
+	return failed
+}
+
+// Flush writes any buffered data (and the header, if nothing was written
+// yet) to the underlying writer
+func (writer *CsvWriter) Flush() error {
+	if err := writer.writeHeader(); err != nil {
+		return err
+	}
+	writer.out.Flush()
+	return writer.out.Error()
+}
+
+// writeHeader writes the header line, once, if asked to
+func (writer *CsvWriter) writeHeader() error {
+	if writer.headerDone {
+		return nil
+	}
+	writer.headerDone = true
+	if !writer.header {
+		return nil
+	}
+	header := make([]string, len(writer.columns))
+	for i, column := range writer.columns {
+		header[i] = writer.all[column]
+	}
+	return writer.out.Write(header)
+}
+
+func csvBaseRow(base *BaseResult) []string {
+	return []string{
+		csvUint(base.MeasurementID),
+		csvUint(base.ProbeID),
+		csvTime(time.Time(base.TimeStamp)),
+		base.Type,
+		csvUint(base.GetFirmwareVersion()),
+		csvUint(base.AddressFamily),
+		csvAddr(base.SourceAddr),
+		csvAddr(base.FromAddr),
+		base.DestinationName,
+		csvAddrPtr(base.DestinationAddr),
+	}
+}
+
+func csvPingRows(res Result) [][]string {
+	ping := res.(*PingResult)
+	return [][]string{{
+		ping.Protocol,
+		csvUint(ping.PacketSize),
+		csvNonZero(ping.Ttl),
+		csvUint(ping.Sent),
+		csvUint(ping.Received),
+		csvUint(ping.Duplicates),
+		csvStat(ping.Minimum),
+		csvStat(ping.Average),
+		csvStat(ping.Median),
+		csvStat(ping.Maximum),
+		csvUint(ping.Timeouts),
+		csvUint(uint(len(ping.Errors))),
+		csvStat(ping.LossRatio()),
+		csvStat(ping.Jitter()),
+	}}
+}
+
+func csvPingReplyRows(res Result) [][]string {
+	ping := res.(*PingResult)
+	rows := make([][]string, 0, len(ping.Replies))
+	for i, reply := range ping.Replies {
+		rows = append(rows, []string{
+			strconv.Itoa(i),
+			csvFloat(reply.Rtt),
+			csvAddr(reply.Source),
+			csvUint(reply.Ttl),
+			strconv.FormatBool(reply.Duplicate),
+		})
+	}
+	return rows
+}
+
+func csvTracerouteRows(res Result) [][]string {
+	trace := res.(*TracerouteResult)
+	lastHop, lastFrom, lastRtt := "", "", ""
+	if hop, ok := trace.LastRespondingHop(); ok {
+		lastHop = csvUint(hop.HopNumber)
+		lastFrom = csvAddr(hop.Address)
+		lastRtt = csvStat(hop.MinRtt)
+	}
+	return [][]string{{
+		trace.Protocol,
+		csvUint(trace.ParisID),
+		csvUint(trace.PacketSize),
+		csvTime(time.Time(trace.EndTime)),
+		csvUint(uint(len(trace.Hops))),
+		lastHop,
+		lastFrom,
+		lastRtt,
+		trace.UnreachableError(),
+		strconv.FormatBool(trace.HasLoop()),
+	}}
+}
+
+func csvTracerouteHopRows(res Result) [][]string {
+	trace := res.(*TracerouteResult)
+	rows := make([][]string, 0, len(trace.Hops)*3)
+	for _, hop := range trace.Hops {
+		hopNumber := csvUint(hop.HopNumber)
+		hopError := ""
+		if hop.SendError != nil {
+			hopError = *hop.SendError
+		}
+		if len(hop.Responses) == 0 {
+			rows = append(rows, []string{hopNumber, hopError, "", "", "", "", "", "", "", "", "", "", "", ""})
+			continue
+		}
+		for i, resp := range hop.Responses {
+			from, rtt, size, ttl := "", "", "", ""
+			if !resp.Timeout && resp.Error == nil {
+				from = csvAddr(resp.From)
+				if resp.Late == nil {
+					rtt = csvFloat(resp.Rtt)
+				}
+				size = csvUint(resp.Size)
+				ttl = strconv.Itoa(resp.Ttl)
+			}
+			labels := make([]string, 0)
+			for _, mpls := range resp.MplsLabelStack() {
+				labels = append(labels, csvUint(mpls.Label))
+			}
+			rows = append(rows, []string{
+				hopNumber,
+				hopError,
+				strconv.Itoa(i),
+				from,
+				rtt,
+				size,
+				ttl,
+				resp.ErrorCode,
+				csvString(resp.Error),
+				strconv.FormatBool(resp.Timeout),
+				csvUintPtr(resp.Late),
+				csvUintPtr(resp.ITtl),
+				csvUintPtr(resp.Mtu),
+				strings.Join(labels, "/"),
+			})
+		}
+	}
+	return rows
+}
+
+func csvDnsResponseRows(res Result) [][]string {
+	dns := res.(*DnsResult)
+	if len(dns.Responses) == 0 {
+		return [][]string{{"", "", "", "", "", "", "", "", "", "", "", "", csvDnsErrors(dns.Error)}}
+	}
+	rows := make([][]string, 0, len(dns.Responses))
+	for i := range dns.Responses {
+		resp := &dns.Responses[i]
+		row := []string{strconv.Itoa(i), csvAddrPort(resp.Destination), resp.Protocol}
+		if len(resp.Error) == 0 {
+			row = append(row,
+				csvFloat(resp.ResponseTime),
+				csvUint(resp.ResponseSize),
+				csvUint(resp.QueryID),
+				resp.RcodeName(),
+				csvUint(resp.AnswerCount),
+				csvUint(resp.NameServerCount),
+				csvUint(resp.AdditionalCount),
+				resp.Nsid(),
+				strings.Join(resp.AnswerSet(), " | "),
+				"",
+			)
+		} else {
+			row = append(row, "", "", "", "", "", "", "", "", "", csvDnsErrors(resp.Error))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func csvDnsAnswerRows(res Result) [][]string {
+	dns := res.(*DnsResult)
+	rows := make([][]string, 0)
+	for i := range dns.Responses {
+		resp := &dns.Responses[i]
+		if len(resp.Error) != 0 {
+			continue
+		}
+		for _, section := range []struct {
+			name    string
+			answers []DnsAnswer
+		}{
+			{"answer", resp.Answer},
+			{"authority", resp.Ns},
+			{"additional", resp.Extra},
+		} {
+			for _, answer := range section.answers {
+				rows = append(rows, []string{
+					strconv.Itoa(i),
+					csvAddrPort(resp.Destination),
+					resp.RcodeName(),
+					section.name,
+					answer.Name,
+					dnsClassName(answer.Class),
+					dnsTypeName(answer.Type),
+					strconv.Itoa(answer.Ttl),
+					answer.Data,
+				})
+			}
+		}
+	}
+	return rows
+}
+
+func csvHttpReplyRows(res Result) [][]string {
+	http := res.(*HttpResult)
+	rows := make([][]string, 0, len(http.Replies))
+	for i := range http.Replies {
+		reply := &http.Replies[i]
+		ttr := ""
+		if reply.TimeToResolve != nil {
+			ttr = csvFloat(*reply.TimeToResolve)
+		}
+		rows = append(rows, []string{
+			strconv.Itoa(i),
+			csvUint(reply.AddressFamily),
+			csvAddr(reply.SourceAddr),
+			csvAddr(reply.DestinationAddr),
+			reply.Method,
+			reply.Version,
+			csvUint(reply.ResultCode),
+			csvUint(reply.HeaderSize),
+			csvUint(reply.BodySize),
+			csvFloat(reply.ReplyTime),
+			ttr,
+			csvFloat(reply.TimeToConnect),
+			csvFloat(reply.TimeToFirstByte),
+			reply.Server(),
+			reply.ContentType(),
+			reply.Location(),
+			reply.DnsError,
+			reply.Error,
+		})
+	}
+	return rows
+}
+
+func csvNtpRows(res Result) [][]string {
+	ntp := res.(*NtpResult)
+	offset, delay := "", ""
+	if val, ok := ntp.Offset(); ok {
+		offset = csvFloat(val)
+	}
+	if val, ok := ntp.Delay(); ok {
+		delay = csvFloat(val)
+	}
+	return [][]string{{
+		ntp.Protocol,
+		csvUint(ntp.Version),
+		ntp.LeapIndicator,
+		ntp.Mode,
+		csvUint(ntp.Stratum),
+		csvUint(ntp.PollInterval),
+		csvFloat(ntp.Precision),
+		csvFloat(ntp.RootDelay),
+		csvFloat(ntp.RootDispersion),
+		ntp.ReferenceID,
+		csvUint(uint(len(ntp.Replies))),
+		csvUint(uint(len(ntp.Errors))),
+		offset,
+		delay,
+		strconv.FormatBool(ntp.Synchronized()),
+	}}
+}
+
+func csvCertRows(res Result) [][]string {
+	cert := res.(*CertResult)
+	subject, issuer, notAfter, remaining, match := "", "", "", "", ""
+	if leaf := cert.Leaf(); leaf != nil {
+		info := CertificateInfo(leaf, cert.GetTimeStamp())
+		subject = info.Subject
+		issuer = info.Issuer
+		notAfter = csvTime(info.NotAfter)
+		remaining = strconv.Itoa(info.DaysRemaining)
+		if cert.Hostname() != "" {
+			match = strconv.FormatBool(cert.MatchesHostname(cert.Hostname()))
+		}
+	}
+	level, description := "", ""
+	if cert.Alert != nil {
+		level = csvUint(cert.Alert.Level)
+		description = csvUint(cert.Alert.Description)
+	}
+	return [][]string{{
+		cert.Method,
+		cert.ProtocolVersion,
+		cert.ServerCipher,
+		csvFloat(cert.ConnectTime),
+		csvFloat(cert.ReplyTime),
+		csvUint(uint(len(cert.Certificates))),
+		subject,
+		issuer,
+		notAfter,
+		remaining,
+		match,
+		level,
+		description,
+		cert.DnsError,
+		csvString(cert.Error),
+	}}
+}
+
+func csvUptimeRows(res Result) [][]string {
+	return [][]string{{csvUint(res.(*UptimeResult).Uptime)}}
+}
+
+func csvConnectionRows(res Result) [][]string {
+	conn := res.(*ConnectionResult)
+	prefix := ""
+	if conn.Prefix.IsValid() {
+		prefix = conn.Prefix.String()
+	}
+	return [][]string{{conn.Event, conn.Controller, csvNonZero(conn.Asn), prefix}}
+}
+
+func csvWifiRows(res Result) [][]string {
+	wifi := res.(*WifiResult)
+	return [][]string{{
+		wifi.Ssid,
+		wifi.Bssid,
+		csvAddr(wifi.IPAddress),
+		wifi.KeyManagement,
+		wifi.State,
+		csvNonZero(wifi.ConnectTime),
+		wifi.Error,
+	}}
+}
+
+// csvDnsErrors turns DNS errors into one field
+func csvDnsErrors(errors []DnsError) string {
+	texts := make([]string, 0, len(errors))
+	for _, e := range errors {
+		if e.Timeout != 0 {
+			texts = append(texts, fmt.Sprintf("timeout %d", e.Timeout))
+		}
+		if e.AddrInfo != "" {
+			texts = append(texts, e.AddrInfo)
+		}
+	}
+	return strings.Join(texts, " | ")
+}
+
+func csvUint(val uint) string {
+	return strconv.FormatUint(uint64(val), 10)
+}
+
+// csvNonZero formats a value that is 0 if not available
+func csvNonZero(val uint) string {
+	if val == 0 {
+		return ""
+	}
+	return csvUint(val)
+}
+
+func csvUintPtr(val *uint) string {
+	if val == nil {
+		return ""
+	}
+	return csvUint(*val)
+}
+
+func csvFloat(val float64) string {
+	return strconv.FormatFloat(val, 'f', -1, 64)
+}
+
+// csvStat formats a statistic that is -1 if not available
+func csvStat(val float64) string {
+	if val < 0 {
+		return ""
+	}
+	return csvFloat(val)
+}
+
+func csvString(val *string) string {
+	if val == nil {
+		return ""
+	}
+	return *val
+}
+
+func csvTime(val time.Time) string {
+	if val.IsZero() {
+		return ""
+	}
+	return val.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+func csvAddr(addr netip.Addr) string {
+	if !addr.IsValid() {
+		return ""
+	}
+	return addr.String()
+}
+
+func csvAddrPtr(addr *netip.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return csvAddr(*addr)
+}
+
+func csvAddrPort(addr netip.AddrPort) string {
+	if !addr.IsValid() {
+		return ""
+	}
+	return addr.String()
+}
@@ -0,0 +1,210 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+)
+
+// mixedResults reads all the results from the mixed fixture
+func mixedResults(t *testing.T) []Result {
+	file, err := os.Open("../testdata/mixed.txt")
+	if err != nil {
+		t.Fatalf("error opening fixture: %v", err)
+	}
+	defer file.Close()
+
+	results := make([]Result, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		res, err := Parse(scanner.Text())
+		if err != nil {
+			t.Fatalf("error parsing fixture: %v", err)
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// writeCsv writes the results with a CSV writer set up by setup
+func writeCsv(t *testing.T, results []Result, schema string, setup func(*CsvWriter)) (string, *CsvWriter) {
+	var out strings.Builder
+	writer, err := NewCsvWriter(&out, schema)
+	if err != nil {
+		t.Fatalf("error creating CSV writer: %v", err)
+	}
+	if setup != nil {
+		setup(writer)
+	}
+	ch := make(chan AsyncResult)
+	go func() {
+		for _, res := range results {
+			ch <- AsyncResult{Result: &res}
+		}
+		ch <- AsyncResult{Error: os.ErrNotExist}
+		close(ch)
+	}()
+	if err := writer.Consume(ch); err != nil {
+		t.Fatalf("error writing CSV: %v", err)
+	}
+	return out.String(), writer
+}
+
+// Test the per type schemas on the mixed fixture
+func TestCsvSchemas(t *testing.T) {
+	results := mixedResults(t)
+	base := []string{"msm_id", "prb_id", "timestamp", "type", "fw", "af", "src_addr", "from", "dst_name", "dst_addr"}
+
+	out, writer := writeCsv(t, results, "ping", func(w *CsvWriter) {
+		w.Columns(append(base[:2:2], "sent", "rcvd", "min", "median", "ttl", "loss", "jitter")...)
+	})
+	assertEqual(t, out, `msm_id,prb_id,sent,rcvd,min,median,ttl,loss,jitter
+1002,21,4,3,10.1,10.4,58,0.25,0
+1003,22,2,0,,,,1,
+`, "ping rows")
+	assertEqual(t, writer.Skipped, uint(11), "skipped non-ping results")
+	assertEqual(t, writer.Errors, uint(1), "errors seen")
+
+	out, _ = writeCsv(t, results, "ping-reply", func(w *CsvWriter) {
+		w.Columns("prb_id", "reply", "rtt", "reply_src", "reply_ttl", "duplicate")
+	})
+	assertEqual(t, out, `prb_id,reply,rtt,reply_src,reply_ttl,duplicate
+21,0,10.1,2001:db8::1,58,false
+21,1,11,2001:db8::1,57,false
+21,2,10.4,2001:db8::2,58,true
+`, "ping reply rows")
+
+	out, _ = writeCsv(t, results, "traceroute", func(w *CsvWriter) {
+		w.Columns("msm_id", "endtime", "hops", "last_hop", "last_hop_from", "last_hop_rtt", "loop")
+	})
+	assertEqual(t, out, `msm_id,endtime,hops,last_hop,last_hop_from,last_hop_rtt,loop
+5002,2023-11-14T22:21:50Z,5,3,2001:db8:3::1,7.5,false
+`, "traceroute rows")
+
+	out, _ = writeCsv(t, results, "traceroute-hop", func(w *CsvWriter) {
+		w.Columns("hop", "hop_error", "reply", "reply_from", "rtt", "err", "error", "timeout", "late", "mtu", "mpls")
+	})
+	assertEqual(t, out, `hop,hop_error,reply,reply_from,rtt,err,error,timeout,late,mtu,mpls
+1,,0,2001:db8:1::1,1.2,,,false,,,
+1,,1,,,,,true,,,
+1,,2,2001:db8:1::1,1.4,,,false,,,
+2,,0,2001:db8:2::1,5.2,,,false,,,24001
+2,,1,2001:db8:2::1,5,,,false,,,
+3,,0,2001:db8:3::1,7.5,A,,false,,1480,
+3,,1,2001:db8:3::1,7.7,3,,false,,,
+3,,2,,,,sendto failed,false,,,
+4,Network is unreachable,,,,,,,,,
+255,,0,,,,,true,,,
+255,,1,,,,,true,,,
+255,,2,,,,,true,,,
+`, "traceroute hop rows")
+
+	out, _ = writeCsv(t, results, "dns-response", func(w *CsvWriter) {
+		w.Columns("msm_id", "response", "resolver", "rt", "rcode", "ancount", "nsid", "error")
+	})
+	assertEqual(t, out, `msm_id,response,resolver,rt,rcode,ancount,nsid,error
+2002,0,192.0.2.53:53,18.5,NOERR,2,ns1.example,
+2003,0,192.0.2.53:53,,,,,timeout 5000
+2003,1,[2001:db8::53]:5353,30.25,NOERR,2,ns1.example,
+2004,,,,,,,Name or service not known
+`, "DNS response rows")
+
+	out, _ = writeCsv(t, results, "dns-answer", func(w *CsvWriter) {
+		w.Columns("msm_id", "response", "section", "name", "class", "rrtype", "ttl", "data")
+	})
+	assertEqual(t, out, `msm_id,response,section,name,class,rrtype,ttl,data
+2002,0,answer,example.com.,IN,A,300,93.184.216.34
+2002,0,answer,example.com.,IN,A,300,93.184.216.35
+2002,0,authority,example.com.,IN,NS,86400,a.iana-servers.net.
+2002,0,additional,.,4096,OPT,0,
+2003,1,answer,example.com.,IN,A,300,93.184.216.34
+2003,1,answer,example.com.,IN,A,300,93.184.216.35
+2003,1,authority,example.com.,IN,NS,86400,a.iana-servers.net.
+2003,1,additional,.,4096,OPT,0,
+`, "DNS answer rows")
+
+	out, _ = writeCsv(t, results, "http-reply", func(w *CsvWriter) {
+		w.Columns("reply", "reply_dst", "method", "res", "rt", "ttr", "content_type")
+	})
+	assertEqual(t, out, `reply,reply_dst,method,res,rt,ttr,content_type
+0,192.0.2.80,HEAD,200,45.5,4.5,text/html
+`, "HTTP reply rows")
+
+	out, _ = writeCsv(t, results, "sslcert", func(w *CsvWriter) {
+		w.Columns("prb_id", "method", "ver", "ttc", "certs", "alert_level", "alert_description", "dnserr", "err")
+	})
+	assertEqual(t, out, `prb_id,method,ver,ttc,certs,alert_level,alert_description,dnserr,err
+21,TLS,1.2,20.25,0,2,40,,
+22,,,0,0,,,non-recoverable failure in name resolution,
+23,,,0,0,,,,connect: timeout
+`, "certificate rows")
+
+	out, _ = writeCsv(t, results, "ntp", func(w *CsvWriter) {
+		w.Columns("msm_id", "replies", "timeouts", "offset", "synchronized")
+	})
+	assertEqual(t, out, "msm_id,replies,timeouts,offset,synchronized\n14002,0,3,,false\n", "NTP rows")
+
+	out, _ = writeCsv(t, results, "connection", nil)
+	assertEqual(t, out, `msm_id,prb_id,timestamp,type,fw,af,src_addr,from,dst_name,dst_addr,event,controller,asn,prefix
+7002,21,2023-11-14T22:33:20Z,connection,5080,0,,,,,disconnect,ctr-fra01,,
+`, "connection rows")
+
+	out, writer = writeCsv(t, results, "base", func(w *CsvWriter) {
+		w.Columns("type", "msm_id")
+	})
+	assertEqual(t, strings.Count(out, "\n"), 14, "base schema rows")
+	assertEqual(t, strings.HasSuffix(out, "future,9002\n"), true, "base schema covers unknown types")
+	assertEqual(t, writer.Skipped, uint(0), "base schema skips nothing")
+}
+
+// Test header, delimiter and column selection options
+func TestCsvOptions(t *testing.T) {
+	results := mixedResults(t)
+
+	out, _ := writeCsv(t, results[:1], "ping", func(w *CsvWriter) {
+		w.Header(false)
+		w.Comma('\t')
+		w.Columns("dst_name", "avg", "proto")
+	})
+	assertEqual(t, out, "example.net\t10.5\tICMP\n", "TSV without header")
+
+	// an empty export still has a header
+	out, _ = writeCsv(t, nil, "uptime", nil)
+	assertEqual(t, out, "msm_id,prb_id,timestamp,type,fw,af,src_addr,from,dst_name,dst_addr,uptime\n", "header only")
+
+	// values with delimiters are quoted
+	out, _ = writeCsv(t, results[3:4], "dns-response", func(w *CsvWriter) {
+		w.Header(false)
+		w.Columns("answers")
+		w.Comma(' ')
+	})
+	assertEqual(t, out, "\"example.com. IN A 93.184.216.34 | example.com. IN A 93.184.216.35\"\n", "quoted value")
+
+	var sink strings.Builder
+	if _, err := NewCsvWriter(&sink, "nonesuch"); err == nil {
+		t.Errorf("unknown schema should be rejected")
+	}
+	writer, _ := NewCsvWriter(&sink, "ntp")
+	if err := writer.Columns("msm_id", "rtt"); err == nil {
+		t.Errorf("unknown column should be rejected")
+	}
+	writer.Write(results[0])
+	if err := writer.Columns("msm_id"); err == nil {
+		t.Errorf("columns should not change after writing started")
+	}
+
+	for _, schema := range CsvSchemas() {
+		columns, err := CsvColumns(schema)
+		if err != nil || len(columns) < len(CsvBaseColumns) {
+			t.Errorf("bad columns for schema %s: %v", schema, err)
+		}
+	}
+}
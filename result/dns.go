@@ -38,17 +38,22 @@ type DnsResponse struct {
 	AddressFamily uint           //
 	Protocol      string         //
 	RetryCount    uint           //
+	LastTimeSync  int            // only in "resultset" responses
+	SubID         uint           // sequence number of this response, only in "resultset" responses
+	SubMax        uint           // total number of responses, only in "resultset" responses
 	QueryBuf      []byte         //
+	QueryMsg      *dns.Msg       // the decoded QueryBuf, nil if there was none (or it was invalid)
 	ResponseTime  float64        //
 	ResponseSize  uint           //
 
 	// overview
-	QueryID         uint   //
-	QueriesCount    uint   //
-	AnswerCount     uint   //
-	NameServerCount uint   //
-	AdditionalCount uint   //
-	Edsn0Nsid       []byte //
+	QueryID         uint      //
+	QueriesCount    uint      //
+	AnswerCount     uint      //
+	NameServerCount uint      //
+	AdditionalCount uint      //
+	Edsn0Nsid       []byte    //
+	Edns0           *DnsEdns0 // EDNS0 details, nil if the response had no OPT record
 
 	// various bits
 	Response           bool //
@@ -64,6 +69,7 @@ type DnsResponse struct {
 
 	// details
 	AnswerBuf []byte      //
+	AnswerMsg *dns.Msg    // the decoded AnswerBuf, nil if there was none
 	Question  DnsQuestion //
 	Answer    []DnsAnswer //
 	Ns        []DnsAnswer //
@@ -87,6 +93,7 @@ type DnsAnswer struct {
 	Name  string
 	Ttl   int
 	Data  string
+	RR    dns.RR // the decoded record, see also RecordsOf()
 }
 
 // DnsError is an error that may have been reported
@@ -97,28 +104,38 @@ type DnsError struct {
 
 const (
 	// this is not a full list!
-	DnsTypeNONE   = 0 // if not filled in
-	DnsTypeA      = 1
-	DnsTypeNS     = 2
-	DnsTypeCNAME  = 5
-	DnsTypeSOA    = 6
-	DnsTypePTR    = 12
-	DnsTypeMX     = 15
-	DnsTypeTXT    = 16
-	DnsTypeSIG    = 24
-	DnsTypeKEY    = 25
-	DnsTypeAAAA   = 28
-	DnsTypeLOC    = 29
-	DnsTypeNAPTR  = 35
-	DnsTypeOPT    = 41
-	DnsTypeDS     = 43
-	DnsTypeRRSIG  = 46
-	DnsTypeNSEC   = 47
-	DnsTypeDNSKEY = 48
-	DnsTypeNSEC3  = 50
-	DnsTypeTLSA   = 52
-	DnsTypeHTTPS  = 65
-	DnsTypeSPF    = 99
+	DnsTypeNONE       = 0 // if not filled in
+	DnsTypeA          = 1
+	DnsTypeNS         = 2
+	DnsTypeCNAME      = 5
+	DnsTypeSOA        = 6
+	DnsTypePTR        = 12
+	DnsTypeHINFO      = 13
+	DnsTypeMX         = 15
+	DnsTypeTXT        = 16
+	DnsTypeSIG        = 24
+	DnsTypeKEY        = 25
+	DnsTypeAAAA       = 28
+	DnsTypeLOC        = 29
+	DnsTypeSRV        = 33
+	DnsTypeNAPTR      = 35
+	DnsTypeDNAME      = 39
+	DnsTypeOPT        = 41
+	DnsTypeDS         = 43
+	DnsTypeSSHFP      = 44
+	DnsTypeRRSIG      = 46
+	DnsTypeNSEC       = 47
+	DnsTypeDNSKEY     = 48
+	DnsTypeNSEC3      = 50
+	DnsTypeNSEC3PARAM = 51
+	DnsTypeTLSA       = 52
+	DnsTypeCDS        = 59
+	DnsTypeCDNSKEY    = 60
+	DnsTypeZONEMD     = 63
+	DnsTypeSVCB       = 64
+	DnsTypeHTTPS      = 65
+	DnsTypeSPF        = 99
+	DnsTypeCAA        = 257
 
 	// this is not a full list!
 	DnsClassNONE  = 0 // if not filled in
@@ -140,28 +157,38 @@ const (
 
 // DnsTypeNames translates record types to their names
 var DnsTypeNames = map[int]string{
-	DnsTypeNONE:   "N/A",
-	DnsTypeA:      "A",
-	DnsTypeNS:     "NS",
-	DnsTypeCNAME:  "CNAME",
-	DnsTypeSOA:    "SOA",
-	DnsTypePTR:    "PTR",
-	DnsTypeMX:     "MX",
-	DnsTypeTXT:    "TXT",
-	DnsTypeSIG:    "SIG",
-	DnsTypeKEY:    "KEY",
-	DnsTypeAAAA:   "AAAA",
-	DnsTypeLOC:    "LOC",
-	DnsTypeNAPTR:  "NAPTR",
-	DnsTypeOPT:    "OPT",
-	DnsTypeDS:     "DS",
-	DnsTypeRRSIG:  "RRSIG",
-	DnsTypeNSEC:   "NSEC",
-	DnsTypeDNSKEY: "DNSKEY",
-	DnsTypeNSEC3:  "NSEC3",
-	DnsTypeTLSA:   "TLSA",
-	DnsTypeHTTPS:  "HTTPS",
-	DnsTypeSPF:    "SPF",
+	DnsTypeNONE:       "N/A",
+	DnsTypeA:          "A",
+	DnsTypeNS:         "NS",
+	DnsTypeCNAME:      "CNAME",
+	DnsTypeSOA:        "SOA",
+	DnsTypePTR:        "PTR",
+	DnsTypeHINFO:      "HINFO",
+	DnsTypeMX:         "MX",
+	DnsTypeTXT:        "TXT",
+	DnsTypeSIG:        "SIG",
+	DnsTypeKEY:        "KEY",
+	DnsTypeAAAA:       "AAAA",
+	DnsTypeLOC:        "LOC",
+	DnsTypeSRV:        "SRV",
+	DnsTypeNAPTR:      "NAPTR",
+	DnsTypeDNAME:      "DNAME",
+	DnsTypeOPT:        "OPT",
+	DnsTypeDS:         "DS",
+	DnsTypeSSHFP:      "SSHFP",
+	DnsTypeRRSIG:      "RRSIG",
+	DnsTypeNSEC:       "NSEC",
+	DnsTypeDNSKEY:     "DNSKEY",
+	DnsTypeNSEC3:      "NSEC3",
+	DnsTypeNSEC3PARAM: "NSEC3PARAM",
+	DnsTypeTLSA:       "TLSA",
+	DnsTypeCDS:        "CDS",
+	DnsTypeCDNSKEY:    "CDNSKEY",
+	DnsTypeZONEMD:     "ZONEMD",
+	DnsTypeSVCB:       "SVCB",
+	DnsTypeHTTPS:      "HTTPS",
+	DnsTypeSPF:        "SPF",
+	DnsTypeCAA:        "CAA",
 }
 
 // DnsClassNames translates record classes to their names
@@ -194,10 +221,10 @@ func (dns *DnsResult) Parse(from string) (err error) {
 	var idns dnsResult
 	err = json.Unmarshal([]byte(from), &idns)
 	if err != nil {
-		return err
+		return parseError(&idns.BaseResult, "dns", err)
 	}
 	if idns.Type != "dns" {
-		return fmt.Errorf("this is not a DNS result (type=%s)", idns.Type)
+		return parseErrorf(&idns.BaseResult, "dns", "this is not a DNS result (type=%s)", idns.Type)
 	}
 	dns.BaseResult = idns.BaseResult
 
@@ -212,12 +239,16 @@ func (dns *DnsResult) Parse(from string) (err error) {
 	if idns.RawResult != nil {
 		qbuf, err := decodeBuf(idns.RawQBuf)
 		if err != nil {
-			return fmt.Errorf("error decoding qbuf: %s", err.Error())
+			return parseErrorf(&idns.BaseResult, "dns", "error decoding qbuf: %s", err.Error())
+		}
+		var dst netip.Addr
+		if idns.DestinationAddr != nil {
+			dst = *idns.DestinationAddr
 		}
 		de, err := makeDnsResponse(
 			time.Time(idns.TimeStamp),
 			idns.SourceAddr,
-			netip.AddrPortFrom(*idns.DestinationAddr, 53),
+			netip.AddrPortFrom(dst, 53),
 			idns.AddressFamily,
 			idns.Protocol,
 			idns.Error,
@@ -226,7 +257,7 @@ func (dns *DnsResult) Parse(from string) (err error) {
 			*idns.RawResult,
 		)
 		if err != nil {
-			return err
+			return parseError(&idns.BaseResult, "dns", err)
 		}
 		dns.Responses = append(dns.Responses, de)
 	}
@@ -238,7 +269,11 @@ func (dns *DnsResult) Parse(from string) (err error) {
 		}
 		qbuf, err := decodeBuf(rs.RawQBuf)
 		if err != nil {
-			return fmt.Errorf("error decoding qbuf: %s", err.Error())
+			return parseErrorf(&idns.BaseResult, "dns", "error decoding qbuf: %s", err.Error())
+		}
+		var answer dnsAnswer
+		if rs.Answer != nil {
+			answer = *rs.Answer
 		}
 		de, err := makeDnsResponse(
 			time.Time(rs.Time),
@@ -249,16 +284,69 @@ func (dns *DnsResult) Parse(from string) (err error) {
 			rs.Error,
 			retrycount,
 			qbuf,
-			rs.Answer,
+			answer,
 		)
 		if err != nil {
-			return err
+			return parseError(&idns.BaseResult, "dns", err)
 		}
+		de.LastTimeSync = rs.LastTimeSync
+		de.SubID = rs.SubID
+		de.SubMax = rs.SubMax
 		dns.Responses = append(dns.Responses, de)
 	}
 	return nil
 }
 
+// MarshalJSON encodes the result in the format of the API. A single
+// response from the destination of the measurement is encoded as "result",
+// otherwise the responses are encoded as "resultset". For responses made
+// by hand, QueryMsg and AnswerMsg are packed if QueryBuf and AnswerBuf
+// are empty
+func (dns *DnsResult) MarshalJSON() ([]byte, error) {
+	packed := *dns
+	packed.Responses = make([]DnsResponse, len(dns.Responses))
+	for i := range dns.Responses {
+		resp, err := dns.Responses[i].packed()
+		if err != nil {
+			return nil, err
+		}
+		packed.Responses[i] = resp
+	}
+	return marshalAtlas(packed.api(), &packed.BaseResult)
+}
+
+// packed returns a copy of the response with QueryBuf and AnswerBuf
+// filled in from QueryMsg and AnswerMsg if needed
+func (resp *DnsResponse) packed() (DnsResponse, error) {
+	packed := *resp
+	var err error
+	if len(packed.QueryBuf) == 0 && packed.QueryMsg != nil {
+		packed.QueryBuf, err = packed.QueryMsg.Pack()
+		if err != nil {
+			return packed, fmt.Errorf("error packing query: %v", err)
+		}
+	}
+	if len(packed.AnswerBuf) == 0 && packed.AnswerMsg != nil {
+		packed.AnswerBuf, err = packed.AnswerMsg.Pack()
+		if err != nil {
+			return packed, fmt.Errorf("error packing answer: %v", err)
+		}
+		if packed.ResponseSize == 0 {
+			packed.ResponseSize = uint(len(packed.AnswerBuf))
+		}
+		if packed.QueryID == 0 {
+			packed.QueryID = uint(packed.AnswerMsg.Id)
+		}
+		if packed.QueriesCount+packed.AnswerCount+packed.NameServerCount+packed.AdditionalCount == 0 {
+			packed.QueriesCount = uint(len(packed.AnswerMsg.Question))
+			packed.AnswerCount = uint(len(packed.AnswerMsg.Answer))
+			packed.NameServerCount = uint(len(packed.AnswerMsg.Ns))
+			packed.AdditionalCount = uint(len(packed.AnswerMsg.Extra))
+		}
+	}
+	return packed, nil
+}
+
 // Filter filters out the desired class/type answers from all answers
 func (result *DnsResult) Filter(class int, typ int) []DnsAnswer {
 	answers := make([]DnsAnswer, 0)
@@ -325,18 +413,18 @@ type dnsResponse struct {
 	SubID           uint       `json:"subid"`    //
 	SubMax          uint       `json:"submax"`   //
 	RawQBuf         *string    `json:"qbuf"`     //
-	Answer          dnsAnswer  `json:"result"`   //
+	Answer          *dnsAnswer `json:"result"`   //
 }
 
 type dnsAnswer struct {
 	ResponseTime    float64      `json:"rt"`      //
 	ResponseSize    uint         `json:"size"`    //
 	Abuf            string       `json:"abuf"`    //
-	QueryID         uint         `json:"id"`      //
-	AnswerCount     uint         `json:"ancount"` //
-	QueriesCount    uint         `json:"qdcount"` //
-	NameServerCount uint         `json:"nscount"` //
-	AdditionalCount uint         `json:"arcount"` //
+	QueryID         uint         `json:"ID"`      //
+	AnswerCount     uint         `json:"ANCOUNT"` //
+	QueriesCount    uint         `json:"QDCOUNT"` //
+	NameServerCount uint         `json:"NSCOUNT"` //
+	AdditionalCount uint         `json:"ARCOUNT"` //
 	ResourceRecords *[]dnsRecord `json:"answers"` //
 	Ttl6            *uint        `json:"ttl"`     //
 }
@@ -352,8 +440,93 @@ type dnsRecord struct {
 }
 
 type dnsError struct {
-	Timeout  uint   `json:"timeout"`
-	AddrInfo string `json:"getaddrinfo"`
+	Timeout  uint   `json:"timeout,omitempty"`
+	AddrInfo string `json:"getaddrinfo,omitempty"`
+}
+
+// api converts a DNS result to the API version
+func (result *DnsResult) api() any {
+	idns := dnsResult{BaseResult: result.BaseResult}
+	if len(result.Error) > 0 {
+		idns.Error = &dnsError{result.Error[0].Timeout, result.Error[0].AddrInfo}
+	}
+	if len(result.Responses) == 1 && result.singleResponse() {
+		resp := &result.Responses[0]
+		idns.Protocol = resp.Protocol
+		idns.RetryCount = resp.RetryCount
+		idns.RawQBuf = encodeBuf(resp.QueryBuf)
+		answer := resp.api()
+		idns.RawResult = &answer
+		return &idns
+	}
+	if len(result.Responses) == 0 {
+		return &idns
+	}
+	idns.RawResultSet = make([]dnsResponse, 0, len(result.Responses))
+	for i := range result.Responses {
+		resp := &result.Responses[i]
+		retry := resp.RetryCount
+		irs := dnsResponse{
+			Time:            uniTime(resp.TimeStamp),
+			LastTimeSync:    resp.LastTimeSync,
+			SourceAddr:      resp.SourceAddr,
+			DestinationAddr: resp.Destination.Addr(),
+			DestinationPort: strconv.Itoa(int(resp.Destination.Port())),
+			AddressFamily:   resp.AddressFamily,
+			Protocol:        resp.Protocol,
+			RetryCount:      &retry,
+			SubID:           resp.SubID,
+			SubMax:          resp.SubMax,
+			RawQBuf:         encodeBuf(resp.QueryBuf),
+		}
+		if len(resp.Error) > 0 {
+			irs.Error = &dnsError{resp.Error[0].Timeout, resp.Error[0].AddrInfo}
+		}
+		if len(resp.Error) == 0 || len(resp.AnswerBuf) > 0 {
+			answer := resp.api()
+			irs.Answer = &answer
+		}
+		idns.RawResultSet = append(idns.RawResultSet, irs)
+	}
+	return &idns
+}
+
+// singleResponse tells if the response is from the destination of the
+// measurement, i.e. it can be encoded as "result" rather than "resultset"
+func (result *DnsResult) singleResponse() bool {
+	resp := &result.Responses[0]
+	return result.DestinationAddr != nil &&
+		resp.Destination == netip.AddrPortFrom(*result.DestinationAddr, 53) &&
+		resp.SourceAddr == result.SourceAddr &&
+		resp.AddressFamily == result.AddressFamily &&
+		resp.TimeStamp.Equal(time.Time(result.TimeStamp))
+}
+
+// api converts the details of a response to the API version
+func (resp *DnsResponse) api() dnsAnswer {
+	ans := dnsAnswer{
+		ResponseTime:    resp.ResponseTime,
+		ResponseSize:    resp.ResponseSize,
+		Abuf:            base64.StdEncoding.EncodeToString(resp.AnswerBuf),
+		QueryID:         resp.QueryID,
+		AnswerCount:     resp.AnswerCount,
+		QueriesCount:    resp.QueriesCount,
+		NameServerCount: resp.NameServerCount,
+		AdditionalCount: resp.AdditionalCount,
+	}
+	if resp.Ttl6 != 0 {
+		ans.Ttl6 = &resp.Ttl6
+	}
+	return ans
+}
+
+// encode a qbuf or an abuf (from []byte to base64 string); nil if empty
+func encodeBuf(buf []byte) *string {
+	if len(buf) == 0 {
+		return nil
+	}
+	encoded := base64.StdEncoding.EncodeToString(buf)
+	return &encoded
 }
 
 // decode an qbuf or an abuf (from base64 string to []byte) if possible
@@ -390,6 +563,12 @@ func makeDnsResponse(
 	de.AddressFamily = af
 	de.Protocol = proto
 	de.QueryBuf = qbuf
+	if len(qbuf) > 0 {
+		var query dns.Msg
+		if query.Unpack(qbuf) == nil {
+			de.QueryMsg = &query
+		}
+	}
 	de.RetryCount = rc
 	de.ResponseTime = ans.ResponseTime
 	de.ResponseSize = ans.ResponseSize
@@ -420,8 +599,9 @@ func makeDnsResponse(
 	var parsed dns.Msg
 	err = parsed.Unpack(de.AnswerBuf)
 	if err != nil {
-		return de, fmt.Errorf("error parsing abuf: " + err.Error())
+		return de, fmt.Errorf("error parsing abuf: %s", err.Error())
 	}
+	de.AnswerMsg = &parsed
 
 	// concatenate the (simplified) answers from all categories
 	makeAnswers := func(rrs []dns.RR) []DnsAnswer {
@@ -451,20 +631,20 @@ func makeDnsResponse(
 			case *dns.TXT:
 				rdata = strings.Join(rtype.Txt, ", ")
 			case *dns.OPT:
-				for _, opt := range rtype.Option {
-					switch o := opt.(type) {
-					case *dns.EDNS0_NSID:
-						de.Edsn0Nsid = decodeNsid(o.Nsid)
-					}
-				}
+				de.Edns0 = makeEdns0(rtype)
+				de.Edsn0Nsid = de.Edns0.Nsid
+			default:
+				// the presentation format of the RDATA
+				rdata = strings.TrimPrefix(ans.String(), ah.String())
 			}
 			list = append(list,
 				DnsAnswer{
-					int(ah.Class),
-					int(ah.Rrtype),
-					ah.Name,
-					int(ah.Ttl),
-					rdata,
+					Class: int(ah.Class),
+					Type:  int(ah.Rrtype),
+					Name:  ah.Name,
+					Ttl:   int(ah.Ttl),
+					Data:  rdata,
+					RR:    ans,
 				},
 			)
 		}
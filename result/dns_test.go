@@ -0,0 +1,205 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// makeDnsResult makes a DNS result for a probe with an answer built
+// from records in zone file format
+func makeDnsResult(t *testing.T, probe uint, rt float64, ad bool, nsid string, records ...string) *DnsResult {
+	t.Helper()
+	msg := new(dns.Msg)
+	msg.SetQuestion("Example.com.", dns.TypeA)
+	msg.Response = true
+	msg.AuthenticatedData = ad
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("bad test record %q: %s", record, err)
+		}
+		msg.Answer = append(msg.Answer, rr)
+	}
+	if nsid != "" {
+		opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+		opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: fmt.Sprintf("%x", nsid)})
+		msg.Extra = append(msg.Extra, opt)
+	}
+	abuf, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("error packing test message: %s", err)
+	}
+
+	var result DnsResult
+	err = result.Parse(fmt.Sprintf(`{"fw":5080,"msm_id":2001,"prb_id":%d,"timestamp":1700000000,"type":"dns","af":4,"dst_addr":"192.0.2.53","proto":"UDP",
+		"result":{"rt":%f,"size":%d,"abuf":"%s","ID":1,"ANCOUNT":%d,"QDCOUNT":1}}`,
+		probe, rt, len(abuf), base64.StdEncoding.EncodeToString(abuf), len(records)))
+	if err != nil {
+		t.Fatalf("Error parsing DNS result: %s", err)
+	}
+	return &result
+}
+
+// Test answer set normalisation, comparison and aggregation
+func TestDnsAnalysis(t *testing.T) {
+	results := []*DnsResult{
+		makeDnsResult(t, 11, 10, true, "ams1", "example.com. 300 IN A 192.0.2.1", "example.com. 300 IN A 192.0.2.2"),
+		// same answers, other order, case and TTLs
+		makeDnsResult(t, 12, 20, true, "ams1", "EXAMPLE.com. 60 IN A 192.0.2.2", "example.com. 200 IN A 192.0.2.1"),
+		makeDnsResult(t, 13, 30, false, "fra1", "example.com. 300 IN A 192.0.2.1", "example.com. 300 IN A 192.0.2.2"),
+		// hijacked
+		makeDnsResult(t, 14, 40, false, "", "example.com. 3600 IN A 203.0.113.66"),
+	}
+
+	resp := &results[1].Responses[0]
+	assertEqual(t, slices.Equal(resp.AnswerSet(), []string{"example.com. IN A 192.0.2.1", "example.com. IN A 192.0.2.2"}), true, "normalised answer set")
+	assertEqual(t, resp.AnswerSetKey(), results[0].Responses[0].AnswerSetKey(), "answer sets are equal")
+	assertEqual(t, resp.RcodeName(), "NOERR", "rcode name")
+	assertEqual(t, resp.Nsid(), "ams1", "NSID")
+	ttl := resp.TtlStats()
+	assertEqual(t, ttl.Minimum, 60, "minimum TTL")
+	assertEqual(t, ttl.Average(), 130.0, "average TTL")
+
+	comparer := NewDnsAnswerComparer()
+	for _, result := range results {
+		comparer.Add(result)
+	}
+	assertEqual(t, slices.Equal(comparer.Questions(), []string{"example.com. IN A"}), true, "questions")
+	_, count := comparer.Majority("example.com. IN A")
+	assertEqual(t, count, uint(3), "majority count")
+	deviations := comparer.Deviations()
+	assertEqual(t, len(deviations), 1, "number of deviations")
+	assertEqual(t, deviations[0].ProbeID, uint(14), "deviating probe")
+	assertEqual(t, deviations[0].Overlaps, false, "no overlap with the majority")
+
+	agg := NewDnsAggregator()
+	queue := make(chan AsyncResult)
+	go func() {
+		for _, dns := range results {
+			var res Result = dns
+			queue <- AsyncResult{Result: &res}
+		}
+		var failed DnsResult
+		failed.Parse(`{"fw":5080,"msm_id":2001,"prb_id":15,"timestamp":1700000000,"type":"dns","error":{"timeout":5000}}`)
+		var res Result = &failed
+		queue <- AsyncResult{Result: &res}
+		close(queue)
+	}()
+	agg.Consume(queue)
+
+	assertEqual(t, agg.Results, uint(5), "number of results")
+	assertEqual(t, agg.Failures, uint(1), "number of failures")
+	assertEqual(t, agg.Rcodes[DnsRcodeNOERR], uint(4), "NOERROR responses")
+	assertEqual(t, agg.RcodeNames()["NOERR"], uint(4), "NOERROR responses by name")
+	assertEqual(t, agg.AuthenticatedDataRatio(), 0.5, "AD ratio")
+	assertEqual(t, agg.Ttl.Maximum, 3600, "maximum TTL")
+	assertEqual(t, agg.Comparer == nil, true, "no per-response comparison by default")
+	assertEqual(t, slices.Equal(agg.Questions(), []string{"example.com. IN A"}), true, "questions in the aggregator")
+	sets := agg.AnswerSets("example.com. IN A")
+	assertEqual(t, len(sets), 2, "number of answer sets")
+	assertEqual(t, sets[0].Responses, uint(3), "majority answer set")
+	assertEqual(t, sets[0].Fingerprint, results[0].Responses[0].AnswerSetFingerprint(), "fingerprint of the majority")
+	aggDeviations := agg.Deviations()
+	assertEqual(t, len(aggDeviations), 1, "deviations via the aggregator")
+	assertEqual(t, aggDeviations[0].Responses, uint(1), "responses with the deviating answer set")
+	assertEqual(t, aggDeviations[0].Answers[0], "example.com. IN A 203.0.113.66", "deviating answer set")
+	assertEqual(t, aggDeviations[0].Overlaps, false, "no overlap with the majority in the aggregator")
+	if p50 := agg.ResponseTimePercentile(50); p50 < 19.5 || p50 > 20.5 {
+		t.Errorf("median response time is off: %f", p50)
+	}
+	groups := agg.NsidGroups()
+	assertEqual(t, len(groups), 2, "number of NSID groups")
+	assertEqual(t, groups[0].Nsid, "ams1", "biggest NSID group")
+	assertEqual(t, slices.Equal(groups[0].Probes, []uint{11, 12}), true, "probes in NSID group")
+}
+
+// Test access to the full messages, typed records and EDNS0 options
+func TestDnsRecords(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeANY)
+	msg.Response = true
+	for _, record := range []string{
+		"example.com. 300 IN MX 10 mail.example.com.",
+		"example.com. 300 IN MX 20 backup.example.com.",
+		"example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 2023010101 7200 3600 1209600 300",
+		`example.com. 300 IN TXT "v=spf1 -all" "second string"`,
+		"example.com. 300 IN CAA 0 issue \"letsencrypt.org\"",
+	} {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("bad test record %q: %s", record, err)
+		}
+		msg.Answer = append(msg.Answer, rr)
+	}
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	opt.SetUDPSize(1232)
+	opt.SetDo()
+	opt.Option = append(opt.Option,
+		&dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: "616d7331"},
+		&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, SourceScope: 16, Address: net.ParseIP("198.51.100.0").To4()},
+		&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708aabbccddeeff0011"},
+		&dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeDNSBogus, ExtraText: "signature expired"},
+	)
+	msg.Extra = append(msg.Extra, opt)
+	abuf, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("error packing test message: %s", err)
+	}
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeANY)
+	qbuf, _ := query.Pack()
+
+	var result DnsResult
+	err = result.Parse(fmt.Sprintf(`{"fw":5080,"msm_id":2001,"prb_id":11,"timestamp":1700000000,"type":"dns","af":4,"dst_addr":"192.0.2.53","proto":"UDP","qbuf":"%s",
+		"result":{"rt":10,"size":%d,"abuf":"%s","ID":1,"ANCOUNT":5,"QDCOUNT":1}}`,
+		base64.StdEncoding.EncodeToString(qbuf), len(abuf), base64.StdEncoding.EncodeToString(abuf)))
+	if err != nil {
+		t.Fatalf("Error parsing DNS result: %s", err)
+	}
+	resp := &result.Responses[0]
+
+	assertEqual(t, resp.AnswerMsg != nil, true, "decoded abuf")
+	assertEqual(t, len(resp.AnswerMsg.Answer), 5, "answers in the decoded abuf")
+	assertEqual(t, resp.QueryMsg != nil && resp.QueryMsg.Question[0].Qtype == dns.TypeANY, true, "decoded qbuf")
+	assertEqual(t, len(resp.AnswerRRs()), 5, "answer records")
+
+	mx := RecordsOf[*dns.MX](resp.Answer)
+	assertEqual(t, len(mx), 2, "MX records")
+	assertEqual(t, mx[1].Preference, uint16(20), "MX preference")
+	soa := RecordsOf[*dns.SOA](resp.Answer)
+	assertEqual(t, soa[0].Serial, uint32(2023010101), "SOA serial")
+	txt := RecordsOf[*dns.TXT](resp.Answer)
+	assertEqual(t, len(txt[0].Txt), 2, "TXT strings")
+	assertEqual(t, resp.Answer[0].Data, "10 mail.example.com.", "MX data")
+	assertEqual(t, resp.Answer[4].Data, `0 issue "letsencrypt.org"`, "CAA data")
+	assertEqual(t, DnsTypeNames[resp.Answer[4].Type], "CAA", "CAA type name")
+
+	edns := resp.Edns0
+	if edns == nil {
+		t.Fatalf("EDNS0 details are missing")
+	}
+	assertEqual(t, edns.UDPSize, uint16(1232), "UDP size")
+	assertEqual(t, edns.DnssecOK, true, "DO bit")
+	assertEqual(t, string(edns.Nsid), "ams1", "NSID")
+	assertEqual(t, string(resp.Edsn0Nsid), "ams1", "NSID (old field)")
+	assertEqual(t, edns.ClientSubnet.Prefix, netip.MustParsePrefix("198.51.100.0/24"), "client subnet")
+	assertEqual(t, edns.ClientSubnet.ScopeLength, uint8(16), "client subnet scope")
+	assertEqual(t, hex.EncodeToString(edns.ClientCookie), "0102030405060708", "client cookie")
+	assertEqual(t, hex.EncodeToString(edns.ServerCookie), "aabbccddeeff0011", "server cookie")
+	assertEqual(t, len(edns.ExtendedErrors), 1, "extended errors")
+	assertEqual(t, edns.ExtendedErrors[0].Name, "DNSSEC Bogus", "extended error name")
+	assertEqual(t, edns.ExtendedErrors[0].ExtraText, "signature expired", "extended error text")
+}
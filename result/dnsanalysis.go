@@ -0,0 +1,487 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// RcodeName returns the name of the response code, e.g. "NXDOMAIN"
+func (resp *DnsResponse) RcodeName() string {
+	if name, ok := DnsRcodeNames[resp.Rcode]; ok {
+		return name
+	}
+	return fmt.Sprint(resp.Rcode)
+}
+
+// Succeeded tells if there was an answer at all (i.e. no timeout or
+// other error)
+func (resp *DnsResponse) Succeeded() bool {
+	return len(resp.Error) == 0 && len(resp.AnswerBuf) > 0
+}
+
+// Nsid returns the NSID (RFC 5001) reported by the server, if any
+func (resp *DnsResponse) Nsid() string {
+	return string(resp.Edsn0Nsid)
+}
+
+// Nsids returns the different NSIDs reported in the responses
+func (result *DnsResult) Nsids() []string {
+	nsids := make([]string, 0)
+	for i := range result.Responses {
+		nsid := result.Responses[i].Nsid()
+		if nsid != "" && !slices.Contains(nsids, nsid) {
+			nsids = append(nsids, nsid)
+		}
+	}
+	return nsids
+}
+
+// record types where the data is a domain name, thus case-insensitive
+var dnsNameDataTypes = []int{DnsTypeCNAME, DnsTypeNS, DnsTypePTR}
+
+// normalised returns the answer as "name class type data", with names
+// lower-cased and without the TTL
+func (answer *DnsAnswer) normalised() string {
+	data := answer.Data
+	if slices.Contains(dnsNameDataTypes, answer.Type) {
+		data = strings.ToLower(data)
+	}
+	return strings.ToLower(answer.Name) + " " + dnsClassName(answer.Class) + " " + dnsTypeName(answer.Type) + " " + data
+}
+
+// dnsClassName returns the name of a record class, or its number if unknown
+func dnsClassName(class int) string {
+	if name, ok := DnsClassNames[class]; ok {
+		return name
+	}
+	return fmt.Sprint(class)
+}
+
+// dnsTypeName returns the name of a record type, or its number if unknown
+func dnsTypeName(typ int) string {
+	if name, ok := DnsTypeNames[typ]; ok {
+		return name
+	}
+	if name := dns.TypeToString[uint16(typ)]; name != "" {
+		return name
+	}
+	return fmt.Sprint(typ)
+}
+
+// AnswerSet returns the records in the answer section in a normalised
+// form: sorted, without duplicates and TTLs, names lower-cased. This
+// makes answers from different servers or probes comparable
+func (resp *DnsResponse) AnswerSet() []string {
+	set := make([]string, 0, len(resp.Answer))
+	for i := range resp.Answer {
+		set = append(set, resp.Answer[i].normalised())
+	}
+	sort.Strings(set)
+	return slices.Compact(set)
+}
+
+// AnswerSetKey returns the normalised answer set as one string
+func (resp *DnsResponse) AnswerSetKey() string {
+	return strings.Join(resp.AnswerSet(), "\n")
+}
+
+// AnswerSetFingerprint returns a short hash of the normalised answer set;
+// responses with the same answer set have the same fingerprint
+func (resp *DnsResponse) AnswerSetFingerprint() string {
+	sum := sha256.Sum256([]byte(resp.AnswerSetKey()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// questionKey returns the question of the response as "name class type"
+func (resp *DnsResponse) questionKey() string {
+	question := (&DnsAnswer{
+		Class: resp.Question.Class,
+		Type:  resp.Question.Type,
+		Name:  resp.Question.Name,
+	}).normalised()
+	return strings.TrimSpace(question)
+}
+
+// DnsTtlStats are statistics about TTLs of records
+type DnsTtlStats struct {
+	Count   uint    // number of records
+	Minimum int     // -1 if N/A
+	Maximum int     // -1 if N/A
+	sum     float64 //
+}
+
+func newDnsTtlStats() DnsTtlStats {
+	return DnsTtlStats{Minimum: -1, Maximum: -1}
+}
+
+func (stats *DnsTtlStats) add(ttl int) {
+	if stats.Count == 0 || ttl < stats.Minimum {
+		stats.Minimum = ttl
+	}
+	if stats.Count == 0 || ttl > stats.Maximum {
+		stats.Maximum = ttl
+	}
+	stats.Count++
+	stats.sum += float64(ttl)
+}
+
+// Average returns the average TTL; -1 if N/A
+func (stats *DnsTtlStats) Average() float64 {
+	if stats.Count == 0 {
+		return -1
+	}
+	return stats.sum / float64(stats.Count)
+}
+
+// TtlStats returns statistics about the TTLs in the answer section
+func (resp *DnsResponse) TtlStats() DnsTtlStats {
+	stats := newDnsTtlStats()
+	for _, answer := range resp.Answer {
+		stats.add(answer.Ttl)
+	}
+	return stats
+}
+
+// DnsAnswerDeviation describes a response with an answer set that
+// differs from what most probes got for the same question
+type DnsAnswerDeviation struct {
+	MeasurementID uint           //
+	ProbeID       uint           //
+	TimeStamp     time.Time      //
+	Destination   netip.AddrPort // the server or resolver that answered
+	Question      string         // "name class type"
+	Answers       []string       // the normalised answer set of this response
+	Majority      []string       // the normalised answer set most probes got
+	Overlaps      bool           // the two sets have records in common, e.g. CDN variation
+}
+
+// DnsAnswerComparer collects answer sets for questions seen by probes,
+// and reports the responses that differ from the majority. This helps
+// detecting hijacking or split-horizon setups. It keeps every response,
+// so for long runs DnsAggregator.Deviations() is the better fit
+type DnsAnswerComparer struct {
+	seen   []DnsAnswerDeviation       // all observations; Majority and Overlaps are filled in later
+	counts map[string]map[string]uint // question -> answer set key -> count
+}
+
+// NewDnsAnswerComparer prepares a new comparer
+func NewDnsAnswerComparer() *DnsAnswerComparer {
+	return &DnsAnswerComparer{
+		seen:   make([]DnsAnswerDeviation, 0),
+		counts: make(map[string]map[string]uint),
+	}
+}
+
+// Add adds all successful responses of a result to the comparison
+func (dc *DnsAnswerComparer) Add(result *DnsResult) {
+	for i := range result.Responses {
+		resp := &result.Responses[i]
+		if !resp.Succeeded() || resp.Question.Name == "" {
+			continue
+		}
+		question := resp.questionKey()
+		answers := resp.AnswerSet()
+		dc.seen = append(dc.seen, DnsAnswerDeviation{
+			MeasurementID: result.MeasurementID,
+			ProbeID:       result.ProbeID,
+			TimeStamp:     resp.TimeStamp,
+			Destination:   resp.Destination,
+			Question:      question,
+			Answers:       answers,
+		})
+		if dc.counts[question] == nil {
+			dc.counts[question] = make(map[string]uint)
+		}
+		dc.counts[question][strings.Join(answers, "\n")]++
+	}
+}
+
+// Questions returns the questions seen so far, as "name class type"
+func (dc *DnsAnswerComparer) Questions() []string {
+	questions := make([]string, 0, len(dc.counts))
+	for question := range dc.counts {
+		questions = append(questions, question)
+	}
+	sort.Strings(questions)
+	return questions
+}
+
+// Majority returns the answer set seen most often for a question (ties
+// are broken by the answers, for stability) and how many times it was seen
+func (dc *DnsAnswerComparer) Majority(question string) ([]string, uint) {
+	keys := make([]string, 0, len(dc.counts[question]))
+	for key := range dc.counts[question] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	best := ""
+	var bestCount uint
+	for _, key := range keys {
+		if dc.counts[question][key] > bestCount {
+			best, bestCount = key, dc.counts[question][key]
+		}
+	}
+	if bestCount == 0 {
+		return nil, 0
+	}
+	if best == "" {
+		return make([]string, 0), bestCount
+	}
+	return strings.Split(best, "\n"), bestCount
+}
+
+// Deviations lists the responses where the answer set differs from the majority
+func (dc *DnsAnswerComparer) Deviations() []DnsAnswerDeviation {
+	majorities := make(map[string][]string)
+	for question := range dc.counts {
+		majorities[question], _ = dc.Majority(question)
+	}
+	deviations := make([]DnsAnswerDeviation, 0)
+	for _, obs := range dc.seen {
+		majority := majorities[obs.Question]
+		if slices.Equal(obs.Answers, majority) {
+			continue
+		}
+		obs.Majority = majority
+		obs.Overlaps = slices.ContainsFunc(obs.Answers, func(a string) bool {
+			return slices.Contains(majority, a)
+		})
+		deviations = append(deviations, obs)
+	}
+	return deviations
+}
+
+// DnsNsidGroup collects responses that reported the same NSID, which
+// usually identifies an anycast instance
+type DnsNsidGroup struct {
+	Nsid      string //
+	Responses uint   //
+	Probes    []uint // probes that reached this instance, in increasing order
+}
+
+// DnsAggregator summarises DNS results in a streaming fashion. It is not
+// safe for concurrent use
+type DnsAggregator struct {
+	Results           uint               // number of DNS results seen
+	Responses         uint               // number of responses in them
+	Failures          uint               // responses (or results without responses) with a timeout or other error
+	AuthenticatedData uint               // responses with the AD bit set
+	Truncated         uint               // responses with the TC bit set
+	Rcodes            map[int]uint       // response code -> number of responses
+	Ttl               DnsTtlStats        // TTLs of the records in the answer sections
+	Skipped           uint               // number of non-DNS results seen
+	Errors            uint               // number of errors seen
+	Comparer          *DnsAnswerComparer // nil by default; set it to also compare every single response (memory grows with the input)
+	succeeded         uint
+	answerSets        map[string]map[string]*DnsAnswerSetCount // question -> fingerprint -> answer set
+	nsids             map[string]*DnsNsidGroup
+	responseTimes     rttSketch
+}
+
+// DnsAnswerSetCount is an answer set to a question, and how many
+// responses had it
+type DnsAnswerSetCount struct {
+	Question    string   // "name class type"
+	Fingerprint string   // see DnsResponse.AnswerSetFingerprint()
+	Answers     []string // the normalised answer set
+	Responses   uint     //
+}
+
+// DnsAnswerSetDeviation is an answer set to a question that differs from
+// the one most responses had
+type DnsAnswerSetDeviation struct {
+	DnsAnswerSetCount
+	Majority []string // the normalised answer set most responses had
+	Overlaps bool     // the two sets have records in common, e.g. CDN variation
+}
+
+// NewDnsAggregator prepares an aggregator
+func NewDnsAggregator() *DnsAggregator {
+	return &DnsAggregator{
+		Rcodes:        make(map[int]uint),
+		Ttl:           newDnsTtlStats(),
+		answerSets:    make(map[string]map[string]*DnsAnswerSetCount),
+		nsids:         make(map[string]*DnsNsidGroup),
+		responseTimes: newRttSketch(),
+	}
+}
+
+// Add adds a DNS result to the summaries
+func (agg *DnsAggregator) Add(result *DnsResult) {
+	agg.Results++
+	if agg.Comparer != nil {
+		agg.Comparer.Add(result)
+	}
+	if len(result.Responses) == 0 && len(result.Error) > 0 {
+		agg.Failures++
+	}
+	for i := range result.Responses {
+		resp := &result.Responses[i]
+		agg.Responses++
+		if !resp.Succeeded() {
+			agg.Failures++
+			continue
+		}
+		agg.succeeded++
+		agg.Rcodes[resp.Rcode]++
+		if resp.AuthenticatedData {
+			agg.AuthenticatedData++
+		}
+		if resp.Truncated {
+			agg.Truncated++
+		}
+		if resp.Question.Name != "" {
+			agg.countAnswerSet(resp)
+		}
+		for _, answer := range resp.Answer {
+			agg.Ttl.add(answer.Ttl)
+		}
+		agg.responseTimes.add(resp.ResponseTime)
+
+		if nsid := resp.Nsid(); nsid != "" {
+			group, ok := agg.nsids[nsid]
+			if !ok {
+				group = &DnsNsidGroup{Nsid: nsid, Probes: make([]uint, 0)}
+				agg.nsids[nsid] = group
+			}
+			group.Responses++
+			if idx, found := slices.BinarySearch(group.Probes, result.ProbeID); !found {
+				group.Probes = slices.Insert(group.Probes, idx, result.ProbeID)
+			}
+		}
+	}
+}
+
+// Consume reads results from a channel until it is closed, and adds the
+// DNS results to the summaries. Other results and errors are counted
+func (agg *DnsAggregator) Consume(results chan AsyncResult) {
+	for res := range results {
+		if res.Error != nil {
+			agg.Errors++
+			continue
+		}
+		dns, ok := (*res.Result).(*DnsResult)
+		if !ok {
+			agg.Skipped++
+			continue
+		}
+		agg.Add(dns)
+	}
+}
+
+// countAnswerSet counts the answer set of a successful response
+func (agg *DnsAggregator) countAnswerSet(resp *DnsResponse) {
+	question := resp.questionKey()
+	sets, ok := agg.answerSets[question]
+	if !ok {
+		sets = make(map[string]*DnsAnswerSetCount)
+		agg.answerSets[question] = sets
+	}
+	fingerprint := resp.AnswerSetFingerprint()
+	set, ok := sets[fingerprint]
+	if !ok {
+		set = &DnsAnswerSetCount{Question: question, Fingerprint: fingerprint, Answers: resp.AnswerSet()}
+		sets[fingerprint] = set
+	}
+	set.Responses++
+}
+
+// Questions returns the questions seen so far, as "name class type"
+func (agg *DnsAggregator) Questions() []string {
+	questions := make([]string, 0, len(agg.answerSets))
+	for question := range agg.answerSets {
+		questions = append(questions, question)
+	}
+	sort.Strings(questions)
+	return questions
+}
+
+// AnswerSets returns the different answer sets to a question, the most
+// common one first (ties are broken by the fingerprints, for stability)
+func (agg *DnsAggregator) AnswerSets(question string) []DnsAnswerSetCount {
+	sets := make([]DnsAnswerSetCount, 0, len(agg.answerSets[question]))
+	for _, set := range agg.answerSets[question] {
+		sets = append(sets, *set)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		if sets[i].Responses != sets[j].Responses {
+			return sets[i].Responses > sets[j].Responses
+		}
+		return sets[i].Fingerprint < sets[j].Fingerprint
+	})
+	return sets
+}
+
+// Deviations returns the answer sets that differ from the most common
+// one to the same question, for all questions
+func (agg *DnsAggregator) Deviations() []DnsAnswerSetDeviation {
+	deviations := make([]DnsAnswerSetDeviation, 0)
+	for _, question := range agg.Questions() {
+		sets := agg.AnswerSets(question)
+		majority := sets[0].Answers
+		for _, set := range sets[1:] {
+			deviations = append(deviations, DnsAnswerSetDeviation{
+				DnsAnswerSetCount: set,
+				Majority:          majority,
+				Overlaps: slices.ContainsFunc(set.Answers, func(a string) bool {
+					return slices.Contains(majority, a)
+				}),
+			})
+		}
+	}
+	return deviations
+}
+
+// RcodeNames returns the number of responses per response code name
+func (agg *DnsAggregator) RcodeNames() map[string]uint {
+	names := make(map[string]uint)
+	for rcode, n := range agg.Rcodes {
+		names[(&DnsResponse{Rcode: rcode}).RcodeName()] += n
+	}
+	return names
+}
+
+// AuthenticatedDataRatio returns the ratio of successful responses with
+// the AD bit set (0..1); -1 if N/A
+func (agg *DnsAggregator) AuthenticatedDataRatio() float64 {
+	if agg.succeeded == 0 {
+		return -1
+	}
+	return float64(agg.AuthenticatedData) / float64(agg.succeeded)
+}
+
+// ResponseTimePercentile returns an estimate of the p-th percentile
+// (0..100) of the response times; -1 if N/A
+func (agg *DnsAggregator) ResponseTimePercentile(p float64) float64 {
+	return agg.responseTimes.quantile(math.Max(0, p) / 100)
+}
+
+// NsidGroups returns the responses grouped by NSID, biggest group first
+func (agg *DnsAggregator) NsidGroups() []DnsNsidGroup {
+	groups := make([]DnsNsidGroup, 0, len(agg.nsids))
+	for _, group := range agg.nsids {
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Responses != groups[j].Responses {
+			return groups[i].Responses > groups[j].Responses
+		}
+		return groups[i].Nsid < groups[j].Nsid
+	})
+	return groups
+}
@@ -0,0 +1,125 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"encoding/hex"
+	"net/netip"
+
+	"github.com/miekg/dns"
+)
+
+// DnsEdns0 holds the EDNS0 details of a response (RFC 6891)
+type DnsEdns0 struct {
+	UDPSize        uint16             // advertised UDP payload size
+	Version        uint8              //
+	ExtendedRcode  int                // upper bits of the response code
+	DnssecOK       bool               // the DO bit
+	Nsid           []byte             // name server identifier, RFC 5001
+	ClientSubnet   *DnsClientSubnet   // RFC 7871, nil if not present
+	ClientCookie   []byte             // RFC 7873, nil if not present
+	ServerCookie   []byte             // RFC 7873, nil if not present
+	ExtendedErrors []DnsExtendedError // RFC 8914
+	Options        []dns.EDNS0        // all options, as decoded
+}
+
+// DnsClientSubnet is the EDNS Client Subnet option (RFC 7871)
+type DnsClientSubnet struct {
+	Prefix      netip.Prefix // the client subnet, with the source prefix length
+	ScopeLength uint8        // the prefix length the answer is valid for
+}
+
+// DnsExtendedError is an extended DNS error (RFC 8914)
+type DnsExtendedError struct {
+	InfoCode  uint16 //
+	Name      string // e.g. "DNSSEC Bogus"
+	ExtraText string //
+}
+
+// makeEdns0 collects the EDNS0 details from an OPT record
+func makeEdns0(opt *dns.OPT) *DnsEdns0 {
+	edns := DnsEdns0{
+		UDPSize:        opt.UDPSize(),
+		Version:        opt.Version(),
+		ExtendedRcode:  opt.ExtendedRcode(),
+		DnssecOK:       opt.Do(),
+		ExtendedErrors: make([]DnsExtendedError, 0),
+		Options:        opt.Option,
+	}
+	for _, option := range opt.Option {
+		switch o := option.(type) {
+		case *dns.EDNS0_NSID:
+			edns.Nsid = decodeNsid(o.Nsid)
+		case *dns.EDNS0_SUBNET:
+			addr, ok := netip.AddrFromSlice(o.Address)
+			if !ok {
+				continue
+			}
+			if o.Family == 1 {
+				addr = addr.Unmap()
+			}
+			prefix, err := addr.Prefix(int(o.SourceNetmask))
+			if err != nil {
+				continue
+			}
+			edns.ClientSubnet = &DnsClientSubnet{prefix, o.SourceScope}
+		case *dns.EDNS0_COOKIE:
+			cookie, err := hex.DecodeString(o.Cookie)
+			if err != nil || len(cookie) < 8 {
+				continue
+			}
+			edns.ClientCookie = cookie[:8]
+			if len(cookie) > 8 {
+				edns.ServerCookie = cookie[8:]
+			}
+		case *dns.EDNS0_EDE:
+			edns.ExtendedErrors = append(edns.ExtendedErrors, DnsExtendedError{
+				o.InfoCode,
+				dns.ExtendedErrorCodeToString[o.InfoCode],
+				o.ExtraText,
+			})
+		}
+	}
+	return &edns
+}
+
+// RecordsOf picks the records of a specific type from a list of answers,
+// e.g. RecordsOf[*dns.MX](resp.Answer)
+func RecordsOf[T dns.RR](answers []DnsAnswer) []T {
+	records := make([]T, 0)
+	for _, answer := range answers {
+		if rr, ok := answer.RR.(T); ok {
+			records = append(records, rr)
+		}
+	}
+	return records
+}
+
+// AnswerRRs returns the records in the answer section
+func (resp *DnsResponse) AnswerRRs() []dns.RR {
+	return answerRRs(resp.Answer)
+}
+
+// NsRRs returns the records in the authority section
+func (resp *DnsResponse) NsRRs() []dns.RR {
+	return answerRRs(resp.Ns)
+}
+
+// ExtraRRs returns the records in the additional section
+func (resp *DnsResponse) ExtraRRs() []dns.RR {
+	return answerRRs(resp.Extra)
+}
+
+func answerRRs(answers []DnsAnswer) []dns.RR {
+	rrs := make([]dns.RR, 0, len(answers))
+	for _, answer := range answers {
+		if answer.RR != nil {
+			rrs = append(rrs, answer.RR)
+		}
+	}
+	return rrs
+}
@@ -0,0 +1,71 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// seedCorpus adds the results from the test fixtures to the fuzz corpus
+func seedCorpus(f *testing.F, pattern string) {
+	files, err := filepath.Glob(filepath.Join("..", "testdata", pattern))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			f.Add(scanner.Text())
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			f.Fatal(err)
+		}
+	}
+	// some malformed input as well
+	f.Add(`{}`)
+	f.Add(`{"fw":5080,"type":"ping","result":[{}]}`)
+	f.Add(`{"fw":5080,"type":"traceroute","result":[{"hop":1}]}`)
+	f.Add(`{"fw":5080,"type":"sslcert","cert":["garbage"]}`)
+	f.Add(`{"fw":5080,"event":"connect"}`)
+}
+
+// fuzzParser checks that a parser doesn't panic, and that errors carry
+// context about which result failed
+func fuzzParser(f *testing.F, pattern string, typehint string) {
+	seedCorpus(f, pattern)
+	f.Fuzz(func(t *testing.T, from string) {
+		_, err := ParseWithTypeHint(from, typehint)
+		if err != nil {
+			if _, ok := err.(*ParseError); !ok {
+				t.Errorf("error is not a ParseError: %v", err)
+			}
+		}
+	})
+}
+
+func FuzzParse(f *testing.F) {
+	fuzzParser(f, "*.txt", "")
+}
+
+func FuzzPing(f *testing.F)       { fuzzParser(f, "ping.txt", "ping") }
+func FuzzTraceroute(f *testing.F) { fuzzParser(f, "traceroute.txt", "traceroute") }
+func FuzzDns(f *testing.F)        { fuzzParser(f, "dns.txt", "dns") }
+func FuzzNtp(f *testing.F)        { fuzzParser(f, "ntp.txt", "ntp") }
+func FuzzCert(f *testing.F)       { fuzzParser(f, "sslcert.txt", "sslcert") }
+func FuzzHttp(f *testing.F)       { fuzzParser(f, "http.txt", "http") }
+func FuzzUptime(f *testing.F)     { fuzzParser(f, "uptime.txt", "uptime") }
+func FuzzWifi(f *testing.F)       { fuzzParser(f, "wifi.txt", "wifi") }
+func FuzzConnection(f *testing.F) { fuzzParser(f, "connection.txt", "connection") }
@@ -0,0 +1,44 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"encoding/json"
+)
+
+// GenericResult holds results of types that have no parser; the common
+// fields are parsed, the source is kept in Raw and everything else is
+// available in Unknown
+type GenericResult struct {
+	BaseResult
+}
+
+func (generic *GenericResult) Parse(from string) (err error) {
+	var base BaseResult
+	err = json.Unmarshal([]byte(from), &base)
+	if err != nil {
+		return parseError(&base, base.Type, err)
+	}
+	generic.BaseResult = base
+	KeepSource(generic, from)
+
+	return nil
+}
+
+// Decode unmarshals the raw result into v, e.g. an application specific struct
+func (generic *GenericResult) Decode(v any) error {
+	return json.Unmarshal(generic.Raw, v)
+}
+
+// MarshalJSON encodes the result in the format of the API
+func (generic *GenericResult) MarshalJSON() ([]byte, error) {
+	return marshalAtlas(generic.api(), &generic.BaseResult)
+}
+
+func (generic *GenericResult) api() any {
+	return &generic.BaseResult
+}
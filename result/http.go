@@ -10,27 +10,58 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/netip"
+	"strconv"
+	"time"
 )
 
+// HttpResult holds an HTTP result; the fields other than Replies are
+// the details of the first reply, for convenience
 type HttpResult struct {
 	BaseResult
-	Uri             string   //
-	HeaderSize      uint     //
-	Headers         []string //
-	BodySize        uint     //
-	Method          string   //
-	Version         string   //
-	ResultCode      uint     //
-	ReplyTime       float64  //
-	TimeToConnect   float64  //
-	TimeToFirstByte float64  //
-	DnsError        string   //
-	Error           string   //
-
-	//ReadTiming *HttpReadTiming `json:"readtiming"` //
-	//SubID         *uint               //
-	//SubMax        *uint           //
-	//Time *uniTime `json:"time"` //
+	Uri             string      //
+	HeaderSize      uint        //
+	Headers         []string    //
+	BodySize        uint        //
+	Method          string      //
+	Version         string      //
+	ResultCode      uint        //
+	ReplyTime       float64     //
+	TimeToConnect   float64     //
+	TimeToFirstByte float64     //
+	DnsError        string      //
+	Error           string      //
+	Replies         []HttpReply // all replies, e.g. for IPv4 and IPv6 or for subsequent requests
+}
+
+// HttpReply is one reply (to one request) in an HTTP result
+type HttpReply struct {
+	AddressFamily   uint             //
+	SourceAddr      netip.Addr       //
+	DestinationAddr netip.Addr       //
+	Method          string           //
+	Version         string           //
+	ResultCode      uint             //
+	HeaderSize      uint             //
+	Headers         []string         // raw header lines
+	Status          *HttpStatusLine  // parsed from the headers, if present
+	Header          HttpHeader       // parsed from the headers
+	BodySize        uint             //
+	ReplyTime       float64          // ms
+	TimeToResolve   *float64         // ms, if resolved on the probe
+	TimeToConnect   float64          // ms
+	TimeToFirstByte float64          // ms
+	DnsError        string           //
+	Error           string           //
+	SubID           *uint            // sequence number of this request, if there were more
+	SubMax          *uint            // total number of requests, if there were more
+	Time            *time.Time       // when this request was made, if there were more
+	ReadTiming      []HttpReadTiming // when data arrived, if extended timing was asked for
+}
+
+// HttpReadTiming is one data point of when (part of) the reply arrived
+type HttpReadTiming struct {
+	Offset    uint    // offset in the reply stream (bytes)
+	TimeSince float64 // time since starting to connect (ms)
 }
 
 func (result *HttpResult) TypeName() string {
@@ -41,38 +72,170 @@ func (http *HttpResult) Parse(from string) (err error) {
 	var ihttp httpResult
 	err = json.Unmarshal([]byte(from), &ihttp)
 	if err != nil {
-		return err
+		return parseError(&ihttp.BaseResult, "http", err)
 	}
 	if ihttp.Type != "http" {
-		return fmt.Errorf("this is not a HTTP result (type=%s)", ihttp.Type)
+		return parseErrorf(&ihttp.BaseResult, "http", "this is not a HTTP result (type=%s)", ihttp.Type)
 	}
 
 	http.BaseResult = ihttp.BaseResult
 	http.Uri = ihttp.Uri
-	if len(ihttp.RawHttpReply) >= 1 {
-		// only deal with response 1 for now
+	http.Replies = make([]HttpReply, 0, len(ihttp.RawHttpReply))
+	for _, resp := range ihttp.RawHttpReply {
+		http.Replies = append(http.Replies, resp.reply())
+	}
 
-		resp := ihttp.RawHttpReply[0]
-		if resp.Headers != nil {
-			http.Headers = *resp.Headers
-		}
-		http.HeaderSize = resp.HeaderSize
-		http.BodySize = resp.BodySize
-		http.Method = resp.Method
-		http.Version = resp.Version
-		http.ResultCode = resp.ResultCode
-		http.ReplyTime = resp.ReplyTime
-		http.TimeToConnect = resp.TimeToConnect
-		http.TimeToFirstByte = resp.TimeToFirstByte
-		if resp.DnsError != nil {
-			http.DnsError = *resp.DnsError
+	http.useFirstReply()
+
+	return nil
+}
+
+// useFirstReply makes the details of the first reply available directly too
+func (http *HttpResult) useFirstReply() {
+	if first := http.FirstReply(); first != nil {
+		http.Headers = first.Headers
+		http.HeaderSize = first.HeaderSize
+		http.BodySize = first.BodySize
+		http.Method = first.Method
+		http.Version = first.Version
+		http.ResultCode = first.ResultCode
+		http.ReplyTime = first.ReplyTime
+		http.TimeToConnect = first.TimeToConnect
+		http.TimeToFirstByte = first.TimeToFirstByte
+		http.DnsError = first.DnsError
+		http.Error = first.Error
+	}
+}
+
+// MarshalJSON encodes the result in the format of the API, from Replies
+func (http *HttpResult) MarshalJSON() ([]byte, error) {
+	return marshalAtlas(http.api(), &http.BaseResult)
+}
+
+// FirstReply returns the first reply, or nil if there are no replies
+func (http *HttpResult) FirstReply() *HttpReply {
+	if len(http.Replies) == 0 {
+		return nil
+	}
+	return &http.Replies[0]
+}
+
+// RepliesFrom returns the replies from a particular address family (4 or 6)
+func (http *HttpResult) RepliesFrom(af uint) []HttpReply {
+	replies := make([]HttpReply, 0)
+	for _, reply := range http.Replies {
+		if reply.AddressFamily == af {
+			replies = append(replies, reply)
 		}
-		if resp.Error != nil {
-			http.Error = *resp.Error
+	}
+	return replies
+}
+
+// TimeToLastByte returns the time (ms, since starting to connect) when the
+// last piece of the reply arrived, if extended read timing is available
+func (reply *HttpReply) TimeToLastByte() (float64, bool) {
+	if len(reply.ReadTiming) == 0 {
+		return 0, false
+	}
+	return reply.ReadTiming[len(reply.ReadTiming)-1].TimeSince, true
+}
+
+// reply converts the API version of one reply to the nicer one
+func (resp *rawHttpReply) reply() HttpReply {
+	reply := HttpReply{
+		AddressFamily:   resp.AddressFamily,
+		SourceAddr:      resp.SourceAddr,
+		DestinationAddr: resp.DestinationAddr,
+		Method:          resp.Method,
+		Version:         resp.Version,
+		ResultCode:      resp.ResultCode,
+		HeaderSize:      resp.HeaderSize,
+		Headers:         make([]string, 0),
+		BodySize:        resp.BodySize,
+		ReplyTime:       resp.ReplyTime,
+		TimeToResolve:   resp.TimeToResolve,
+		TimeToConnect:   resp.TimeToConnect,
+		TimeToFirstByte: resp.TimeToFirstByte,
+		SubID:           resp.SubID,
+		SubMax:          resp.SubMax,
+		ReadTiming:      make([]HttpReadTiming, 0),
+	}
+	if resp.Headers != nil {
+		reply.Headers = *resp.Headers
+	}
+	reply.Status, reply.Header = parseHttpHeaders(reply.Headers)
+	if resp.DnsError != nil {
+		reply.DnsError = *resp.DnsError
+	}
+	if resp.Error != nil {
+		reply.Error = *resp.Error
+	}
+	if resp.Time != nil {
+		t := time.Time(*resp.Time)
+		reply.Time = &t
+	}
+	if resp.ReadTiming != nil {
+		for _, rt := range *resp.ReadTiming {
+			reply.ReadTiming = append(reply.ReadTiming,
+				HttpReadTiming{uint(rt.Offset), float64(rt.TimeSince)},
+			)
 		}
 	}
+	return reply
+}
 
-	return nil
+// api converts an HTTP result to the API version
+func (http *HttpResult) api() any {
+	ihttp := httpResult{
+		BaseResult:   http.BaseResult,
+		Uri:          http.Uri,
+		RawHttpReply: make([]rawHttpReply, 0, len(http.Replies)),
+	}
+	for _, reply := range http.Replies {
+		ihttp.RawHttpReply = append(ihttp.RawHttpReply, reply.api())
+	}
+	return &ihttp
+}
+
+// api converts one reply to the API version
+func (reply *HttpReply) api() rawHttpReply {
+	resp := rawHttpReply{
+		AddressFamily:   reply.AddressFamily,
+		BodySize:        reply.BodySize,
+		DestinationAddr: reply.DestinationAddr,
+		HeaderSize:      reply.HeaderSize,
+		Method:          reply.Method,
+		ResultCode:      reply.ResultCode,
+		ReplyTime:       reply.ReplyTime,
+		SourceAddr:      reply.SourceAddr,
+		SubID:           reply.SubID,
+		SubMax:          reply.SubMax,
+		TimeToConnect:   reply.TimeToConnect,
+		TimeToFirstByte: reply.TimeToFirstByte,
+		TimeToResolve:   reply.TimeToResolve,
+		Version:         reply.Version,
+	}
+	if len(reply.Headers) > 0 {
+		resp.Headers = &reply.Headers
+	}
+	if reply.DnsError != "" {
+		resp.DnsError = &reply.DnsError
+	}
+	if reply.Error != "" {
+		resp.Error = &reply.Error
+	}
+	if reply.Time != nil {
+		t := uniTime(*reply.Time)
+		resp.Time = &t
+	}
+	if len(reply.ReadTiming) > 0 {
+		timing := make([]httpReadTiming, 0, len(reply.ReadTiming))
+		for _, rt := range reply.ReadTiming {
+			timing = append(timing, httpReadTiming{flexNumber(rt.Offset), flexNumber(rt.TimeSince)})
+		}
+		resp.ReadTiming = &timing
+	}
+	return resp
 }
 
 //////////////////////////////////////////////////////
@@ -85,25 +248,59 @@ type httpResult struct {
 }
 
 type rawHttpReply struct {
-	BodySize        uint            `json:"bsize"`      //
-	DnsError        *string         `json:"dnserr"`     //
-	DestinationAddr netip.Addr      `json:"dst_addr"`   //
-	Error           *string         `json:"err"`        //
-	Headers         *[]string       `json:"header"`     //
-	HeaderSize      uint            `json:"hsize"`      //
-	Method          string          `json:"method"`     //
-	ReadTiming      *httpReadTiming `json:"readtiming"` //
-	ResultCode      uint            `json:"res"`        //
-	ReplyTime       float64         `json:"rt"`         //
-	SubID           *uint           `json:"subid"`      //
-	SubMax          *uint           `json:"submax"`     //
-	Time            *uniTime        `json:"time"`       //
-	TimeToConnect   float64         `json:"ttc"`        //
-	TimeToFirstByte float64         `json:"ttfb"`       //
-	Version         string          `json:"ver"`        //
+	AddressFamily   uint              `json:"af"`         //
+	BodySize        uint              `json:"bsize"`      //
+	DnsError        *string           `json:"dnserr"`     //
+	DestinationAddr netip.Addr        `json:"dst_addr"`   //
+	Error           *string           `json:"err"`        //
+	Headers         *[]string         `json:"header"`     //
+	HeaderSize      uint              `json:"hsize"`      //
+	Method          string            `json:"method"`     //
+	ReadTiming      *[]httpReadTiming `json:"readtiming"` //
+	ResultCode      uint              `json:"res"`        //
+	ReplyTime       float64           `json:"rt"`         //
+	SourceAddr      netip.Addr        `json:"src_addr"`   //
+	SubID           *uint             `json:"subid"`      //
+	SubMax          *uint             `json:"submax"`     //
+	Time            *uniTime          `json:"time"`       //
+	TimeToConnect   float64           `json:"ttc"`        //
+	TimeToFirstByte float64           `json:"ttfb"`       //
+	TimeToResolve   *float64          `json:"ttr"`        //
+	Version         string            `json:"ver"`        //
 }
 
 type httpReadTiming struct {
-	Offset    uint `json:"o"` //
-	TimeSince uint `json:"t"` //
+	Offset    flexNumber `json:"o"` //
+	TimeSince flexNumber `json:"t"` //
+}
+
+// MarshalJSON encodes the offset as a string, like the API
+func (rt httpReadTiming) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Offset    string     `json:"o"`
+		TimeSince flexNumber `json:"t"`
+	}{strconv.FormatFloat(float64(rt.Offset), 'f', -1, 64), rt.TimeSince})
+}
+
+// flexNumber is a number that may be encoded as a string
+type flexNumber float64
+
+func (n *flexNumber) UnmarshalJSON(b []byte) error {
+	var val any
+	if err := json.Unmarshal(b, &val); err != nil {
+		return err
+	}
+	switch v := val.(type) {
+	case float64:
+		*n = flexNumber(v)
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("unable to parse number with value %v", v)
+		}
+		*n = flexNumber(f)
+	default:
+		return fmt.Errorf("unexpected number with type %T and value %v", v, v)
+	}
+	return nil
 }
@@ -0,0 +1,130 @@
+/*
+  (C) 2022 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"testing"
+)
+
+// Test if the HTTP parser keeps all replies and their details
+func TestHttpParser(t *testing.T) {
+	var http HttpResult
+	err := http.Parse(`
+{
+"fw":5080,
+"lts":14,
+"msm_id":12001,
+"prb_id":11,
+"timestamp":1700000000,
+"from":"198.51.100.2",
+"type":"http",
+"msm_name":"HTTPGet",
+"group_id":12001,
+"uri":"http://example.com/",
+"result":[
+	{"af":4,"bsize":1256,"dst_addr":"192.0.2.80","hsize":321,"method":"GET","res":200,"rt":25.5,"src_addr":"10.0.0.2","ttc":10.1,"ttfb":20.2,"ver":"1.1",
+	 "header":["HTTP/1.1 200 OK","Server: ECS (nyb/1D2E)","Content-Type: text/html"],
+	 "readtiming":[{"o":"0","t":20.2},{"o":"321","t":21.0},{"o":1577,"t":25.4}],
+	 "subid":1,"submax":2,"time":1700000000},
+	{"af":6,"dst_addr":"2001:db8::80","method":"GET","err":"connect: Network is unreachable","src_addr":"2001:db8::2",
+	 "subid":2,"submax":2,"time":1700000001}
+]
+}
+`)
+	if err != nil {
+		t.Fatalf("Error parsing HTTP result: %s", err)
+	}
+
+	assertEqual(t, len(http.Replies), 2, "wrong number of HTTP replies")
+	assertEqual(t, http.ResultCode, uint(200), "error in first reply convenience field res")
+	assertEqual(t, http.BodySize, uint(1256), "error in first reply convenience field bsize")
+	assertEqual(t, len(http.Headers), 3, "error in first reply convenience field header")
+
+	first := http.FirstReply()
+	assertEqual(t, first.DestinationAddr.String(), "192.0.2.80", "error parsing HTTP field value for dst_addr")
+	assertEqual(t, first.SourceAddr.String(), "10.0.0.2", "error parsing HTTP field value for src_addr")
+	assertEqual(t, *first.SubID, uint(1), "error parsing HTTP field value for subid")
+	assertEqual(t, *first.SubMax, uint(2), "error parsing HTTP field value for submax")
+	assertEqual(t, first.Time.Unix(), int64(1700000000), "error parsing HTTP field value for time")
+	assertEqual(t, len(first.ReadTiming), 3, "error parsing HTTP field value for readtiming")
+	assertEqual(t, first.ReadTiming[1].Offset, uint(321), "error parsing HTTP readtiming offset")
+	assertEqual(t, first.ReadTiming[2].Offset, uint(1577), "error parsing HTTP readtiming offset")
+	ttlb, ok := first.TimeToLastByte()
+	assertEqual(t, ok, true, "time to last byte should be available")
+	assertEqual(t, ttlb, 25.4, "error calculating time to last byte")
+
+	second := http.Replies[1]
+	assertEqual(t, second.AddressFamily, uint(6), "error parsing HTTP field value for af")
+	assertEqual(t, second.Error, "connect: Network is unreachable", "error parsing HTTP field value for err")
+	assertEqual(t, len(http.RepliesFrom(6)), 1, "wrong number of IPv6 replies")
+	_, ok = second.TimeToLastByte()
+	assertEqual(t, ok, false, "time to last byte should not be available")
+}
+
+// Test if HTTP headers are parsed and redirects are found
+func TestHttpHeaders(t *testing.T) {
+	var http HttpResult
+	err := http.Parse(`
+{
+"fw":5080,
+"msm_id":12002,
+"prb_id":11,
+"timestamp":1700000000,
+"type":"http",
+"uri":"http://example.com/start",
+"result":[
+	{"af":4,"dst_addr":"192.0.2.80","method":"GET","res":301,"ver":"1.1","subid":1,"submax":3,
+	 "header":["HTTP/1.1 301 Moved Permanently","location: /next","Server: nginx",""]},
+	{"af":4,"dst_addr":"192.0.2.80","method":"GET","res":302,"ver":"1.1","subid":2,"submax":3,
+	 "header":["HTTP/1.1 302 Found","Location: https://www.example.net/final",""]},
+	{"af":4,"dst_addr":"192.0.2.81","method":"GET","res":200,"ver":"1.1","subid":3,"submax":3,
+	 "header":["HTTP/1.1 200 OK","Content-Type: text/html; charset=UTF-8","X-Cache: MISS, HIT","X-Served-By: cache-ams21051-AMS","Age: 10","Set-Cookie: a=1","Set-Cookie: b=2","bogus line"]}
+]
+}
+`)
+	if err != nil {
+		t.Fatalf("Error parsing HTTP result: %s", err)
+	}
+
+	first := http.Replies[0]
+	assertEqual(t, first.Status.Code, uint(301), "error parsing status line code")
+	assertEqual(t, first.Status.Reason, "Moved Permanently", "error parsing status line reason")
+	assertEqual(t, first.Status.Version, "HTTP/1.1", "error parsing status line version")
+	assertEqual(t, first.Server(), "nginx", "error getting server")
+	assertEqual(t, first.Location(), "/next", "error getting location (case insensitive)")
+
+	last := http.Replies[2]
+	assertEqual(t, last.ContentType(), "text/html", "error getting content type")
+	assertEqual(t, len(last.Header.Values("set-cookie")), 2, "error getting multiple header values")
+	assertEqual(t, last.Header.Has("bogus line"), false, "non-header lines should be ignored")
+	hit, known := last.CacheHit()
+	assertEqual(t, hit && known, true, "error determining cache hit")
+	assertEqual(t, last.Edge(), "cache-ams21051-AMS", "error determining edge")
+	assertEqual(t, len(last.CacheHeaders()), 3, "error collecting cache headers")
+	assertEqual(t, last.IsRedirect(), false, "a 200 reply is not a redirect")
+
+	chain := http.RedirectChain()
+	assertEqual(t, len(chain), 2, "wrong redirect chain length")
+	assertEqual(t, chain[0].From, "http://example.com/start", "wrong redirect chain start")
+	assertEqual(t, chain[0].Location, "http://example.com/next", "relative location was not resolved")
+	assertEqual(t, chain[1].From, "http://example.com/start", "redirects are all from the URI")
+	assertEqual(t, chain[1].Location, "https://www.example.net/final", "wrong redirect chain end")
+
+	// the same redirect over IPv4 and IPv6
+	err = http.Parse(`{"fw":5080,"msm_id":12002,"prb_id":11,"timestamp":1700000000,"type":"http","uri":"http://example.com/",
+		"result":[
+		{"af":4,"dst_addr":"192.0.2.80","method":"GET","res":301,"ver":"1.1","header":["HTTP/1.1 301 Moved Permanently","Location: https://example.com/",""]},
+		{"af":6,"dst_addr":"2001:db8::80","method":"GET","res":301,"ver":"1.1","header":["HTTP/1.1 301 Moved Permanently","Location: https://example.com/",""]}
+	]}`)
+	if err != nil {
+		t.Fatalf("Error parsing HTTP result: %s", err)
+	}
+	chain = http.RedirectChain()
+	assertEqual(t, len(chain), 2, "wrong number of parallel redirects")
+	assertEqual(t, chain[1].From, "http://example.com/", "parallel redirects are not chained")
+	assertEqual(t, chain[1].Reply.AddressFamily, uint(6), "wrong reply of the second redirect")
+}
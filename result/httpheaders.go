@@ -0,0 +1,186 @@
+/*
+  (C) 2022 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// HttpStatusLine is the parsed first line of an HTTP reply header
+type HttpStatusLine struct {
+	Version string // e.g. "HTTP/1.1"
+	Code    uint   //
+	Reason  string // e.g. "OK"
+}
+
+// HttpHeader holds HTTP headers, keyed by canonical header names
+// Lookups via its methods are case-insensitive
+type HttpHeader map[string][]string
+
+// Get returns the first value for a header, or "" if there's none
+func (h HttpHeader) Get(name string) string {
+	values := h[textproto.CanonicalMIMEHeaderKey(name)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Values returns all values for a header
+func (h HttpHeader) Values(name string) []string {
+	return h[textproto.CanonicalMIMEHeaderKey(name)]
+}
+
+// Has tells if a header is present
+func (h HttpHeader) Has(name string) bool {
+	_, ok := h[textproto.CanonicalMIMEHeaderKey(name)]
+	return ok
+}
+
+// headers that say something about caching
+var httpCacheHeaders = []string{
+	"Age", "Cache-Control", "Expires", "ETag", "Last-Modified", "Pragma", "Vary",
+	"X-Cache", "X-Cache-Hits", "X-Cache-Status", "CF-Cache-Status", "CDN-Cache",
+	"X-Served-By", "Via",
+}
+
+// headers that identify which edge/node answered, in order of preference
+var httpEdgeHeaders = []string{
+	"X-Served-By", "CF-Ray", "X-Amz-Cf-Pop", "X-Edge-Location", "X-Cache", "Via", "Server",
+}
+
+// parseHttpHeaders turns the raw header lines of a reply into a status
+// line and a header map. Lines that don't look like headers are ignored
+func parseHttpHeaders(lines []string) (*HttpStatusLine, HttpHeader) {
+	header := make(HttpHeader)
+	var status *HttpStatusLine
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+		if i == 0 && strings.HasPrefix(line, "HTTP/") {
+			status = parseHttpStatusLine(line)
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found || name == "" || strings.ContainsAny(name, " \t") {
+			continue
+		}
+		key := textproto.CanonicalMIMEHeaderKey(name)
+		header[key] = append(header[key], strings.TrimSpace(value))
+	}
+	return status, header
+}
+
+func parseHttpStatusLine(line string) *HttpStatusLine {
+	parts := strings.SplitN(line, " ", 3)
+	status := HttpStatusLine{Version: parts[0]}
+	if len(parts) > 1 {
+		code, err := strconv.ParseUint(parts[1], 10, 0)
+		if err == nil {
+			status.Code = uint(code)
+		}
+	}
+	if len(parts) > 2 {
+		status.Reason = parts[2]
+	}
+	return &status
+}
+
+// Server returns the server software, as reported in the Server header
+func (reply *HttpReply) Server() string {
+	return reply.Header.Get("Server")
+}
+
+// ContentType returns the media type of the content, without parameters
+func (reply *HttpReply) ContentType() string {
+	ct, _, _ := strings.Cut(reply.Header.Get("Content-Type"), ";")
+	return strings.TrimSpace(strings.ToLower(ct))
+}
+
+// CacheHeaders returns the headers that relate to caching
+func (reply *HttpReply) CacheHeaders() HttpHeader {
+	cache := make(HttpHeader)
+	for _, name := range httpCacheHeaders {
+		if values := reply.Header.Values(name); values != nil {
+			cache[textproto.CanonicalMIMEHeaderKey(name)] = values
+		}
+	}
+	return cache
+}
+
+// CacheHit tells if a cache (CDN) says it served the reply from cache
+// The second return value is false if there's no cache status information
+func (reply *HttpReply) CacheHit() (hit bool, known bool) {
+	for _, name := range []string{"CF-Cache-Status", "X-Cache-Status", "X-Cache"} {
+		value := strings.ToUpper(reply.Header.Get(name))
+		if value == "" {
+			continue
+		}
+		// X-Cache can list multiple layers, e.g. "MISS, HIT"; the last one is nearest
+		layers := strings.Split(value, ",")
+		last := strings.TrimSpace(layers[len(layers)-1])
+		return strings.Contains(last, "HIT"), true
+	}
+	return false, false
+}
+
+// Edge returns an identifier of the edge or cache node that answered,
+// based on the usual CDN headers; "" if there's no such information
+func (reply *HttpReply) Edge() string {
+	for _, name := range httpEdgeHeaders {
+		if value := reply.Header.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// IsRedirect tells if the reply is a redirect (3xx with a Location)
+func (reply *HttpReply) IsRedirect() bool {
+	return reply.ResultCode >= 300 && reply.ResultCode < 400 && reply.Header.Has("Location")
+}
+
+// Location returns the redirect target, "" if there's none
+func (reply *HttpReply) Location() string {
+	return reply.Header.Get("Location")
+}
+
+// HttpRedirect is a redirect in one of the replies
+type HttpRedirect struct {
+	Reply    *HttpReply // the reply that redirected
+	From     string     // the URL that was requested
+	Location string     // where the reply redirected to (absolute URL if it can be resolved)
+}
+
+// RedirectChain lists the redirects in the replies, in order
+// The probe does not follow redirects: the replies are separate requests
+// to the URI of the result (e.g. over IPv4 and IPv6, or repeated ones), so
+// every redirect is from that URI, and relative locations are resolved
+// against it
+func (http *HttpResult) RedirectChain() []HttpRedirect {
+	chain := make([]HttpRedirect, 0)
+	base, baseErr := url.Parse(http.Uri)
+	for i := range http.Replies {
+		reply := &http.Replies[i]
+		if !reply.IsRedirect() {
+			continue
+		}
+		location := reply.Location()
+		if baseErr == nil {
+			if target, err := base.Parse(location); err == nil {
+				location = target.String()
+			}
+		}
+		chain = append(chain, HttpRedirect{reply, http.Uri, location})
+	}
+	return chain
+}
@@ -0,0 +1,162 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+// ICMP extension object classes
+const (
+	IcmpExtClassMpls          = 1 // MPLS label stack, RFC 4950
+	IcmpExtClassInterfaceInfo = 2 // interface information, RFC 5837
+)
+
+// IsMpls tells if the object is an MPLS label stack
+func (obj *IcmpExtensionObject) IsMpls() bool {
+	return obj.Class == IcmpExtClassMpls && obj.Type == 1
+}
+
+// IsInterfaceInfo tells if the object is interface information (RFC 5837)
+func (obj *IcmpExtensionObject) IsInterfaceInfo() bool {
+	return obj.Class == IcmpExtClassInterfaceInfo
+}
+
+// InterfaceRole returns which interface the interface information is
+// about: "incoming", "sub-IP", "outgoing" or "next-hop"; "" if the
+// object is not interface information
+func (obj *IcmpExtensionObject) InterfaceRole() string {
+	if !obj.IsInterfaceInfo() {
+		return ""
+	}
+	// the top two bits of the C-Type
+	return []string{"incoming", "sub-IP", "outgoing", "next-hop"}[(obj.Type>>6)&3]
+}
+
+// MplsLabelStack returns the MPLS label stack quoted in the reply, top of
+// the stack first; empty if the reply did not carry one
+func (hopdata *TraceRouteHopData) MplsLabelStack() []MplsObject {
+	stack := make([]MplsObject, 0)
+	for _, ext := range hopdata.IcmpExtensions {
+		for _, obj := range ext.Objects {
+			if obj.IsMpls() {
+				stack = append(stack, obj.MplsObject...)
+			}
+		}
+	}
+	return stack
+}
+
+// QuotedTtl returns the TTL of the packet that triggered the reply, as
+// quoted in the reply. It is normally 1; a bigger value means the packet
+// was forwarded without its TTL being decremented, usually inside an
+// MPLS tunnel that does not reveal its labels
+func (hopdata *TraceRouteHopData) QuotedTtl() uint {
+	if hopdata.ITtl == nil {
+		return 1
+	}
+	return *hopdata.ITtl
+}
+
+// the usual initial TTLs of MPLS labels
+var mplsInitialTtls = []uint{64, 128, 255}
+
+// HiddenHops estimates the number of routers that were hidden from the
+// traceroute, in case the reply came from the end of an MPLS tunnel that
+// does not propagate the TTL: the quoted label then still carries the
+// remainder of the TTL the tunnel started with. That is taken to be the
+// nearest of the usual initial TTLs (64, 128, 255) at or above the quoted
+// one. It returns false if there's no estimate, e.g. there are no labels
+func (hopdata *TraceRouteHopData) HiddenHops() (uint, bool) {
+	stack := hopdata.MplsLabelStack()
+	if len(stack) == 0 {
+		return 0, false
+	}
+	if stack[0].Ttl <= 1 {
+		return 0, true // the TTL was propagated
+	}
+	for _, initial := range mplsInitialTtls {
+		if stack[0].Ttl <= initial {
+			return initial - stack[0].Ttl, true
+		}
+	}
+	return 0, false
+}
+
+// MplsLabelStack returns the first MPLS label stack reported for this hop
+func (hop *TracerouteHop) MplsLabelStack() []MplsObject {
+	for i := range hop.Responses {
+		if stack := hop.Responses[i].MplsLabelStack(); len(stack) > 0 {
+			return stack
+		}
+	}
+	return make([]MplsObject, 0)
+}
+
+// IsMpls tells if any reply for this hop carried an MPLS label stack
+func (hop *TracerouteHop) IsMpls() bool {
+	return len(hop.MplsLabelStack()) > 0
+}
+
+// QuotedTtl returns the biggest quoted TTL of the replies for this hop
+func (hop *TracerouteHop) QuotedTtl() uint {
+	var qttl uint
+	for i := range hop.Responses {
+		if hop.Responses[i].From.IsValid() {
+			qttl = max(qttl, hop.Responses[i].QuotedTtl())
+		}
+	}
+	return qttl
+}
+
+// HiddenHops returns the biggest estimate of hidden routers of the replies
+// for this hop; false if none of them has an estimate
+func (hop *TracerouteHop) HiddenHops() (uint, bool) {
+	var hidden uint
+	var known bool
+	for i := range hop.Responses {
+		if n, ok := hop.Responses[i].HiddenHops(); ok {
+			hidden = max(hidden, n)
+			known = true
+		}
+	}
+	return hidden, known
+}
+
+// MplsTunnel is a sequence of hops that seem to be inside an MPLS tunnel
+type MplsTunnel struct {
+	FirstHop    uint           // hop number of the first hop in the tunnel
+	LastHop     uint           // hop number of the last hop in the tunnel
+	Explicit    bool           // the routers revealed their label stacks (RFC 4950)
+	HiddenHops  uint           // estimated number of routers not seen at all, for the hops that have an estimate
+	LabelStacks [][]MplsObject // per hop in the tunnel, empty for hops that didn't reveal labels
+}
+
+// MplsTunnels lists the MPLS tunnels along the path. Hops are considered
+// to be in a tunnel if they reveal MPLS labels, or if the quoted TTL
+// shows that the TTL was not decremented on the way (implicit tunnels)
+func (trace *TracerouteResult) MplsTunnels() []MplsTunnel {
+	tunnels := make([]MplsTunnel, 0)
+	var current *MplsTunnel
+	for i := range trace.Hops {
+		hop := &trace.Hops[i]
+		stack := hop.MplsLabelStack()
+		if len(stack) == 0 && hop.QuotedTtl() <= 1 {
+			current = nil
+			continue
+		}
+		if current == nil {
+			tunnels = append(tunnels, MplsTunnel{
+				FirstHop:    hop.HopNumber,
+				LabelStacks: make([][]MplsObject, 0),
+			})
+			current = &tunnels[len(tunnels)-1]
+		}
+		current.LastHop = hop.HopNumber
+		current.Explicit = current.Explicit || len(stack) > 0
+		hidden, _ := hop.HiddenHops()
+		current.HiddenHops += hidden
+		current.LabelStacks = append(current.LabelStacks, stack)
+	}
+	return tunnels
+}
@@ -8,7 +8,6 @@ package result
 
 import (
 	"encoding/json"
-	"fmt"
 )
 
 type NtpResult struct {
@@ -42,14 +41,20 @@ func (result *NtpResult) TypeName() string {
 	return "ntp"
 }
 
+// MarshalJSON encodes the result in the format of the API. Replies come
+// first, then errors
+func (ntp *NtpResult) MarshalJSON() ([]byte, error) {
+	return marshalAtlas(ntp.api(), &ntp.BaseResult)
+}
+
 func (ntp *NtpResult) Parse(from string) (err error) {
 	var intp ntpResult
 	err = json.Unmarshal([]byte(from), &intp)
 	if err != nil {
-		return err
+		return parseError(&intp.BaseResult, "ntp", err)
 	}
 	if intp.Type != "ntp" {
-		return fmt.Errorf("this is not an NTP result (type=%s)", intp.Type)
+		return parseErrorf(&intp.BaseResult, "ntp", "this is not an NTP result (type=%s)", intp.Type)
 	}
 	ntp.BaseResult = intp.BaseResult
 	ntp.Protocol = intp.Protocol
@@ -75,45 +80,89 @@ func (ntp *NtpResult) Parse(from string) (err error) {
 // this is the JSON structure as reported by the API
 type ntpResult struct {
 	BaseResult
-	Protocol           string  `json:"proto"`           //
-	Version            uint    `json:"version"`         //
-	LeapIndicator      string  `json:"li"`              // "no", "59", "61" or "unknown"
-	Mode               string  `json:"mode"`            //
-	Stratum            uint    `json:"stratum"`         //
-	PollInterval       uint    `json:"poll"`            //
-	Precision          float64 `json:"precision"`       //
-	RootDelay          float64 `json:"root-delay"`      //
-	RootDispersion     float64 `json:"root-dispersion"` //
-	ReferenceID        string  `json:"ref-id"`          //
-	ReferenceTimestamp float64 `json:"ref-ts"`          //
-	RawResult          []any   `json:"result"`          //
+	Protocol           string        `json:"proto"`                     //
+	Version            uint          `json:"version,omitempty"`         // these are missing if there were no replies
+	LeapIndicator      string        `json:"li"`                        // "no", "59", "61" or "unknown"
+	Mode               string        `json:"mode"`                      //
+	Stratum            uint          `json:"stratum,omitempty"`         //
+	PollInterval       uint          `json:"poll,omitempty"`            //
+	Precision          float64       `json:"precision,omitempty"`       //
+	RootDelay          float64       `json:"root-delay,omitempty"`      //
+	RootDispersion     float64       `json:"root-dispersion,omitempty"` //
+	ReferenceID        string        `json:"ref-id"`                    //
+	ReferenceTimestamp float64       `json:"ref-ts,omitempty"`          //
+	RawResult          []rawNtpReply `json:"result"`                    //
+}
+
+// one item in the result array: either a reply or a timeout/error
+type rawNtpReply struct {
+	Rtt               *float64 `json:"rtt"`         //
+	Offset            float64  `json:"offset"`      //
+	OriginTimestamp   float64  `json:"origin-ts"`   //
+	TransmitTimestamp float64  `json:"transmit-ts"` //
+	ReceiveTimestamp  float64  `json:"receive-ts"`  //
+	FinalTimestamp    float64  `json:"final-ts"`    //
+	Error             *string  `json:"x"`           // usually "*" for a timeout
+}
+
+// MarshalJSON leaves out the reply fields for errors
+func (item rawNtpReply) MarshalJSON() ([]byte, error) {
+	if item.Rtt == nil {
+		return json.Marshal(struct {
+			Error *string `json:"x"`
+		}{item.Error})
+	}
+	type plain rawNtpReply
+	return json.Marshal(plain(item))
+}
+
+// api converts an NTP result to the API version
+func (ntp *NtpResult) api() any {
+	intp := ntpResult{
+		BaseResult:         ntp.BaseResult,
+		Protocol:           ntp.Protocol,
+		Version:            ntp.Version,
+		LeapIndicator:      ntp.LeapIndicator,
+		Mode:               ntp.Mode,
+		Stratum:            ntp.Stratum,
+		PollInterval:       ntp.PollInterval,
+		Precision:          ntp.Precision,
+		RootDelay:          ntp.RootDelay,
+		RootDispersion:     ntp.RootDispersion,
+		ReferenceID:        ntp.ReferenceID,
+		ReferenceTimestamp: ntp.ReferenceTimestamp,
+		RawResult:          make([]rawNtpReply, 0, len(ntp.Replies)+len(ntp.Errors)),
+	}
+	for _, reply := range ntp.Replies {
+		intp.RawResult = append(intp.RawResult, rawNtpReply{
+			Rtt:               &reply.Rtt,
+			Offset:            reply.Offset,
+			OriginTimestamp:   reply.OriginTimestamp,
+			TransmitTimestamp: reply.TransmitTimestamp,
+			ReceiveTimestamp:  reply.ReceiveTimestamp,
+			FinalTimestamp:    reply.FinalTimestamp,
+		})
+	}
+	for _, e := range ntp.Errors {
+		intp.RawResult = append(intp.RawResult, rawNtpReply{Error: &e})
+	}
+	return &intp
 }
 
 func (result *ntpResult) Replies() []NtpReply {
 	r := make([]NtpReply, 0)
 	for _, item := range result.RawResult {
-		mapitem := item.(map[string]any)
-		if rtt, ok := mapitem["rtt"]; ok {
-			// fill in other fields of a reply struct
-			ntpr := NtpReply{Rtt: rtt.(float64)}
-			if offset, ok := mapitem["offset"]; ok {
-				ntpr.Offset = offset.(float64)
-			}
-			if origints, ok := mapitem["origin-ts"]; ok {
-				ntpr.OriginTimestamp = origints.(float64)
-			}
-			if transmitts, ok := mapitem["transmit-ts"]; ok {
-				ntpr.TransmitTimestamp = transmitts.(float64)
-			}
-			if receivets, ok := mapitem["receive-ts"]; ok {
-				ntpr.ReceiveTimestamp = receivets.(float64)
-			}
-			if finalts, ok := mapitem["final-ts"]; ok {
-				ntpr.FinalTimestamp = finalts.(float64)
-			}
-
-			r = append(r, ntpr)
+		if item.Rtt == nil {
+			continue
 		}
+		r = append(r, NtpReply{
+			OriginTimestamp:   item.OriginTimestamp,
+			TransmitTimestamp: item.TransmitTimestamp,
+			ReceiveTimestamp:  item.ReceiveTimestamp,
+			FinalTimestamp:    item.FinalTimestamp,
+			Offset:            item.Offset,
+			Rtt:               *item.Rtt,
+		})
 	}
 	return r
 }
@@ -121,9 +170,8 @@ func (result *ntpResult) Replies() []NtpReply {
 func (result *ntpResult) Errors() []string {
 	r := make([]string, 0)
 	for _, item := range result.RawResult {
-		mapitem := item.(map[string]any)
-		if err, ok := mapitem["x"]; ok {
-			r = append(r, err.(string))
+		if item.Error != nil {
+			r = append(r, *item.Error)
 		}
 	}
 	return r
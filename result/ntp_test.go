@@ -0,0 +1,93 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"testing"
+)
+
+// makeNtpResult makes an NTP result from a probe to a server
+func makeNtpResult(t *testing.T, probe uint, server string, stratum uint, refid string, replies string) *NtpResult {
+	t.Helper()
+	var ntp NtpResult
+	err := ntp.Parse(fmt.Sprintf(`{"fw":5080,"msm_id":14001,"prb_id":%d,"timestamp":1700000000,"type":"ntp","af":4,"dst_addr":"%s","proto":"UDP",
+		"version":4,"li":"no","mode":"server","stratum":%d,"root-delay":0.01,"root-dispersion":0.02,"ref-id":"%s","result":[%s]}`,
+		probe, server, stratum, refid, replies))
+	if err != nil {
+		t.Fatalf("Error parsing NTP result: %s", err)
+	}
+	return &ntp
+}
+
+// Test best sample selection, synchronisation distance and reference IDs
+func TestNtpAnalysis(t *testing.T) {
+	ntp := makeNtpResult(t, 11, "192.0.2.123", 2, "192.0.2.1",
+		`{"offset":0.003,"rtt":0.030},{"x":"*"},{"offset":0.001,"rtt":0.020},{"offset":0.002,"rtt":0.025}`)
+	best, ok := ntp.BestReply()
+	assertEqual(t, ok, true, "there is a best reply")
+	assertEqual(t, best.Rtt, 0.020, "best delay")
+	offset, _ := ntp.Offset()
+	assertEqual(t, offset, 0.001, "best offset")
+	assertEqual(t, ntp.SynchronizationDistance(), 0.025, "synchronisation distance")
+	assertEqual(t, ntp.Synchronized(), true, "synchronised")
+	ref := ntp.DecodedReferenceID()
+	assertEqual(t, ref.Kind, "ipv4", "reference ID kind")
+	assertEqual(t, ref.Address, netip.MustParseAddr("192.0.2.1"), "upstream server")
+
+	ref = makeNtpResult(t, 11, "192.0.2.123", 2, "c0000202", "").DecodedReferenceID()
+	assertEqual(t, ref.Address, netip.MustParseAddr("192.0.2.2"), "upstream server from hex")
+	ref = makeNtpResult(t, 11, "2001:db8::123", 2, "a1b2c3d4", "").DecodedReferenceID()
+	assertEqual(t, ref.Kind, "ipv6-hash", "reference ID kind for IPv6")
+	assertEqual(t, len(ref.Hash), 4, "hash length")
+	ref = makeNtpResult(t, 11, "192.0.2.123", 1, "GPS", "").DecodedReferenceID()
+	assertEqual(t, ref.Kind+" "+ref.Code, "source GPS", "reference source")
+	ref = makeNtpResult(t, 11, "192.0.2.123", 0, "52415445", "").DecodedReferenceID()
+	assertEqual(t, ref.Code, "RATE", "kiss code")
+	ref = makeNtpResult(t, 11, "192.0.2.123", 2, "LOCL", "").DecodedReferenceID()
+	assertEqual(t, ref.Kind+" "+ref.Code, "unknown LOCL", "unknown reference ID")
+	_, ok = makeNtpResult(t, 11, "192.0.2.123", 0, "RATE", `{"x":"*"}`).BestReply()
+	assertEqual(t, ok, false, "no best reply without replies")
+}
+
+// Test finding servers that disagree with the majority
+func TestNtpAggregator(t *testing.T) {
+	results := make(chan AsyncResult)
+	go func() {
+		defer close(results)
+		servers := map[string]float64{"192.0.2.1": 0.001, "192.0.2.2": -0.002, "192.0.2.3": 0.0005, "192.0.2.4": 0.250}
+		for server, offset := range servers {
+			for probe := uint(11); probe <= 13; probe++ {
+				var res Result = makeNtpResult(t, probe, server, 2, "192.0.2.100",
+					fmt.Sprintf(`{"offset":%f,"rtt":0.02},{"offset":1,"rtt":0.5}`, offset+(float64(probe)-12)/1000))
+				results <- AsyncResult{Result: &res}
+			}
+		}
+		var res Result = makeNtpResult(t, 14, "192.0.2.1", 2, "192.0.2.100", `{"x":"*"}`)
+		results <- AsyncResult{Result: &res}
+	}()
+
+	agg := NewNtpAggregator(0.1)
+	agg.Consume(results)
+
+	servers := agg.Servers()
+	assertEqual(t, len(servers), 4, "number of servers")
+	assertEqual(t, servers[0].Results, uint(4), "results for the first server")
+	assertEqual(t, servers[0].Unanswered, uint(1), "unanswered results for the first server")
+	assertEqual(t, servers[0].Probes, uint(4), "probes for the first server")
+	if math.Abs(servers[0].MedianOffset-0.001) > 1e-9 {
+		t.Errorf("median offset is off: %f", servers[0].MedianOffset)
+	}
+	assertEqual(t, servers[0].MedianDelay, 0.02, "median delay")
+	assertEqual(t, servers[0].SyncDistance, 0.025, "synchronisation distance")
+
+	outliers := agg.Outliers()
+	assertEqual(t, len(outliers), 1, "number of outliers")
+	assertEqual(t, outliers[0].Server, netip.MustParseAddr("192.0.2.4"), "outlier server")
+}
@@ -0,0 +1,250 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"encoding/hex"
+	"math"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// BestReply returns the reply with the smallest round trip delay, which
+// (like in NTP's clock filter) is the one with the most reliable offset;
+// the second return value is false if there were no replies
+func (ntp *NtpResult) BestReply() (NtpReply, bool) {
+	if len(ntp.Replies) == 0 {
+		return NtpReply{}, false
+	}
+	best := ntp.Replies[0]
+	for _, reply := range ntp.Replies[1:] {
+		if reply.Rtt < best.Rtt {
+			best = reply
+		}
+	}
+	return best, true
+}
+
+// Offset returns the clock offset of the best reply; the second return
+// value is false if there were no replies
+func (ntp *NtpResult) Offset() (float64, bool) {
+	best, ok := ntp.BestReply()
+	return best.Offset, ok
+}
+
+// Delay returns the round trip delay of the best reply; the second return
+// value is false if there were no replies
+func (ntp *NtpResult) Delay() (float64, bool) {
+	best, ok := ntp.BestReply()
+	return best.Rtt, ok
+}
+
+// SynchronizationDistance returns the server's estimate of its maximum
+// error relative to the primary reference: root delay / 2 + root dispersion
+func (ntp *NtpResult) SynchronizationDistance() float64 {
+	return ntp.RootDelay/2 + ntp.RootDispersion
+}
+
+// Synchronized tells if the server claims to be synchronised, i.e. its
+// leap indicator is not "unknown" (alarm) and its stratum is 1..15
+func (ntp *NtpResult) Synchronized() bool {
+	return ntp.LeapIndicator != "unknown" && ntp.Stratum >= 1 && ntp.Stratum <= 15
+}
+
+// NtpReferenceID is the decoded reference ID of an NTP server; its
+// meaning depends on the stratum (RFC 5905 section 7.3)
+type NtpReferenceID struct {
+	Kind    string     // "kiss" (stratum 0), "source" (stratum 1), "ipv4", "ipv6-hash" or "unknown"
+	Code    string     // kiss code (e.g. "RATE"), reference source (e.g. "GPS") or the raw ID for "unknown"
+	Address netip.Addr // the upstream server for "ipv4"
+	Hash    []byte     // first 4 bytes of the MD5 hash of the upstream IPv6 address for "ipv6-hash"
+}
+
+// DecodedReferenceID decodes the reference ID of the server. The Kind is
+// one of "kiss" or "source" for stratum 0 and 1, "ipv4" or "ipv6-hash" for
+// the upstream server of a higher stratum, or "unknown" if the ID is in
+// neither of those forms
+func (ntp *NtpResult) DecodedReferenceID() NtpReferenceID {
+	raw := strings.TrimSpace(ntp.ReferenceID)
+	bytes := refIDBytes(raw)
+
+	switch ntp.Stratum {
+	case 0, 1:
+		kind := "source"
+		if ntp.Stratum == 0 {
+			kind = "kiss"
+		}
+		code := raw
+		if bytes != nil {
+			code = strings.TrimRight(string(bytes), "\x00 ")
+		}
+		return NtpReferenceID{Kind: kind, Code: code}
+	}
+
+	if addr, err := netip.ParseAddr(raw); err == nil && addr.Is4() {
+		return NtpReferenceID{Kind: "ipv4", Address: addr}
+	}
+	if bytes == nil {
+		return NtpReferenceID{Kind: "unknown", Code: raw}
+	}
+	if ntp.DestinationAddr != nil && ntp.DestinationAddr.Is6() && !ntp.DestinationAddr.Is4In6() {
+		return NtpReferenceID{Kind: "ipv6-hash", Hash: bytes}
+	}
+	return NtpReferenceID{Kind: "ipv4", Address: netip.AddrFrom4([4]byte(bytes))}
+}
+
+// refIDBytes returns the 4 bytes of a reference ID given in hex (with or
+// without "0x"), or nil if it's not in that form
+func refIDBytes(raw string) []byte {
+	raw = strings.TrimPrefix(strings.ToLower(raw), "0x")
+	if len(raw) != 8 {
+		return nil
+	}
+	bytes, err := hex.DecodeString(raw)
+	if err != nil {
+		return nil
+	}
+	return bytes
+}
+
+// NtpServerStats summarises the results for one NTP server
+type NtpServerStats struct {
+	Server       netip.Addr //
+	Results      uint       // number of results
+	Unanswered   uint       // results without any reply
+	Probes       uint       // number of different probes
+	Stratum      uint       // as reported in the last result with replies
+	ReferenceID  string     // as reported in the last result with replies
+	MedianOffset float64    // median of the best offsets of the results; NaN if N/A
+	MedianDelay  float64    // median of the best delays of the results; NaN if N/A
+	SyncDistance float64    // median synchronisation distance; NaN if N/A
+	Deviation    float64    // MedianOffset relative to the consensus of all servers
+	Outlier      bool       // Deviation is bigger than the aggregator's threshold
+}
+
+// NtpAggregator collects NTP results per server, and flags the servers
+// whose offsets disagree with the majority. It is not safe for concurrent use
+type NtpAggregator struct {
+	threshold float64                       // seconds
+	servers   map[netip.Addr]*ntpServerData //
+	Skipped   uint                          // number of non-NTP results seen
+	Errors    uint                          // number of errors seen
+}
+
+type ntpServerData struct {
+	stats     NtpServerStats
+	probes    map[uint]bool
+	offsets   []float64
+	delays    []float64
+	distances []float64
+}
+
+// NewNtpAggregator prepares an aggregator; servers whose median offset
+// differs from the consensus by more than threshold (seconds) are outliers
+func NewNtpAggregator(threshold float64) *NtpAggregator {
+	return &NtpAggregator{
+		threshold: threshold,
+		servers:   make(map[netip.Addr]*ntpServerData),
+	}
+}
+
+// Add adds an NTP result to the summaries
+func (agg *NtpAggregator) Add(ntp *NtpResult) {
+	if ntp.DestinationAddr == nil {
+		agg.Skipped++
+		return
+	}
+	server := ntp.DestinationAddr.Unmap()
+	data, ok := agg.servers[server]
+	if !ok {
+		data = &ntpServerData{
+			stats:  NtpServerStats{Server: server},
+			probes: make(map[uint]bool),
+		}
+		agg.servers[server] = data
+	}
+	data.stats.Results++
+	data.probes[ntp.ProbeID] = true
+
+	best, ok := ntp.BestReply()
+	if !ok {
+		data.stats.Unanswered++
+		return
+	}
+	data.stats.Stratum = ntp.Stratum
+	data.stats.ReferenceID = ntp.ReferenceID
+	data.offsets = append(data.offsets, best.Offset)
+	data.delays = append(data.delays, best.Rtt)
+	data.distances = append(data.distances, ntp.SynchronizationDistance())
+}
+
+// Consume reads results from a channel until it is closed, and adds the
+// NTP results to the summaries. Other results and errors are counted
+func (agg *NtpAggregator) Consume(results chan AsyncResult) {
+	for res := range results {
+		if res.Error != nil {
+			agg.Errors++
+			continue
+		}
+		ntp, ok := (*res.Result).(*NtpResult)
+		if !ok {
+			agg.Skipped++
+			continue
+		}
+		agg.Add(ntp)
+	}
+}
+
+// Consensus returns the median of the servers' median offsets; NaN if N/A
+func (agg *NtpAggregator) Consensus() float64 {
+	medians := make([]float64, 0, len(agg.servers))
+	for _, data := range agg.servers {
+		if len(data.offsets) > 0 {
+			medians = append(medians, median(slices.Clone(data.offsets)))
+		}
+	}
+	return medianOrNaN(medians)
+}
+
+// Servers returns the summaries of all servers, ordered by address
+func (agg *NtpAggregator) Servers() []NtpServerStats {
+	consensus := agg.Consensus()
+	servers := make([]NtpServerStats, 0, len(agg.servers))
+	for _, data := range agg.servers {
+		stats := data.stats
+		stats.Probes = uint(len(data.probes))
+		stats.MedianOffset = medianOrNaN(slices.Clone(data.offsets))
+		stats.MedianDelay = medianOrNaN(slices.Clone(data.delays))
+		stats.SyncDistance = medianOrNaN(slices.Clone(data.distances))
+		stats.Deviation = stats.MedianOffset - consensus
+		stats.Outlier = math.Abs(stats.Deviation) > agg.threshold
+		servers = append(servers, stats)
+	}
+	slices.SortFunc(servers, func(a, b NtpServerStats) int {
+		return a.Server.Compare(b.Server)
+	})
+	return servers
+}
+
+// Outliers returns the summaries of the servers that disagree with the majority
+func (agg *NtpAggregator) Outliers() []NtpServerStats {
+	outliers := make([]NtpServerStats, 0)
+	for _, stats := range agg.Servers() {
+		if stats.Outlier {
+			outliers = append(outliers, stats)
+		}
+	}
+	return outliers
+}
+
+func medianOrNaN(vals []float64) float64 {
+	if len(vals) == 0 {
+		return math.NaN()
+	}
+	return median(vals)
+}
@@ -0,0 +1,1127 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+)
+
+// Parquet export stores results of one type in a Parquet file, one row
+// per result. The columns follow the result structs and the field names
+// of the API: every schema starts with the common fields (msm_id, prb_id,
+// timestamp, type, fw, af, dst_addr etc.), followed by the type specific
+// ones. Details such as ping replies, traceroute hops (with their
+// responses), DNS responses (with their answers) and HTTP replies are
+// nested lists. Times are UNIX epoch seconds, like in the API; addresses
+// are strings. The result type is stored in the file's metadata under
+// "goatapi.type", so the reader knows what to produce
+
+// ParquetTypes lists the result types that can be stored in Parquet files
+var ParquetTypes = []string{"ping", "traceroute", "dns", "http", "sslcert", "ntp"}
+
+const parquetTypeKey = "goatapi.type"
+
+// ParquetWriter writes results of one type to a Parquet file. Rows are
+// buffered and written as a row group when there are enough of them.
+// Results of other types are skipped. It is not safe for concurrent use
+type ParquetWriter struct {
+	out          io.Writer      //
+	resultType   string         //
+	rowGroupSize int            // number of rows in a row group
+	compression  compress.Codec //
+	sink         parquetSink    // created when the first row is written
+	Written      uint           // number of results written
+	Skipped      uint           // number of results of other types
+	Errors       uint           // number of errors seen
+}
+
+// NewParquetWriter prepares a Parquet writer for results of one type
+// (see ParquetTypes). By default row groups have 4096 rows, and are
+// compressed with zstd
+func NewParquetWriter(w io.Writer, resultType string) (*ParquetWriter, error) {
+	if !slices.Contains(ParquetTypes, resultType) {
+		return nil, fmt.Errorf("results of type %s cannot be stored in Parquet", resultType)
+	}
+	return &ParquetWriter{
+		out:          w,
+		resultType:   resultType,
+		rowGroupSize: 4096,
+		compression:  &parquet.Zstd,
+	}, nil
+}
+
+// RowGroupSize sets the number of rows (results) buffered and written as
+// one row group
+func (writer *ParquetWriter) RowGroupSize(rows int) error {
+	if writer.sink != nil {
+		return fmt.Errorf("row group size cannot be changed after writing has started")
+	}
+	if rows <= 0 {
+		return fmt.Errorf("invalid row group size: %d", rows)
+	}
+	writer.rowGroupSize = rows
+	return nil
+}
+
+// Compression sets the compression codec: "none", "snappy", "gzip",
+// "brotli", "lz4" or "zstd"
+func (writer *ParquetWriter) Compression(codec string) error {
+	if writer.sink != nil {
+		return fmt.Errorf("compression cannot be changed after writing has started")
+	}
+	codecs := map[string]compress.Codec{
+		"none":   &parquet.Uncompressed,
+		"snappy": &parquet.Snappy,
+		"gzip":   &parquet.Gzip,
+		"brotli": &parquet.Brotli,
+		"lz4":    &parquet.Lz4Raw,
+		"zstd":   &parquet.Zstd,
+	}
+	c, ok := codecs[codec]
+	if !ok {
+		return fmt.Errorf("unknown compression codec: %s", codec)
+	}
+	writer.compression = c
+	return nil
+}
+
+// Write adds one result to the file. Results of other types are skipped
+func (writer *ParquetWriter) Write(res Result) error {
+	if res.TypeName() != writer.resultType {
+		writer.Skipped++
+		return nil
+	}
+	if writer.sink == nil {
+		writer.start()
+	}
+	if err := writer.sink.add(res); err != nil {
+		return err
+	}
+	writer.Written++
+	return nil
+}
+
+// Consume reads results from a channel until it is closed and writes
+// them, then closes the writer. Errors on the channel are counted. It
+// returns the first error of writing, but keeps draining the channel
+func (writer *ParquetWriter) Consume(results chan AsyncResult) error {
+	var failed error
+	for res := range results {
+		if res.Error != nil {
+			writer.Errors++
+			continue
+		}
+		if failed == nil {
+			failed = writer.Write(*res.Result)
+		}
+	}
+	if err := writer.Close(); failed == nil {
+		failed = err
+	}
+	return failed
+}
+
+// Close writes the buffered rows and the file footer. It doesn't close
+// the underlying writer
+func (writer *ParquetWriter) Close() error {
+	if writer.sink == nil {
+		writer.start() // an empty file still has the schema
+	}
+	return writer.sink.close()
+}
+
+// start sets up the underlying Parquet writer with the options
+func (writer *ParquetWriter) start() {
+	writer.sink = newParquetSink(writer.resultType, writer.out, writer.rowGroupSize,
+		parquet.Compression(writer.compression),
+		parquet.KeyValueMetadata(parquetTypeKey, writer.resultType),
+	)
+}
+
+// ParquetReader reads results back from a Parquet file made by
+// ParquetWriter. It is not safe for concurrent use
+type ParquetReader struct {
+	resultType string        //
+	source     parquetSource //
+	closer     io.Closer     // the file, if the reader opened it
+}
+
+// NewParquetReader prepares a reader for a Parquet file made by ParquetWriter
+func NewParquetReader(r io.ReaderAt, size int64) (*ParquetReader, error) {
+	file, err := parquet.OpenFile(r, size)
+	if err != nil {
+		return nil, err
+	}
+	resultType, ok := file.Lookup(parquetTypeKey)
+	if !ok {
+		return nil, fmt.Errorf("not a file of results: no %s in the metadata", parquetTypeKey)
+	}
+	source := newParquetSource(resultType, file)
+	if source == nil {
+		return nil, fmt.Errorf("unsupported result type in Parquet file: %s", resultType)
+	}
+	return &ParquetReader{resultType: resultType, source: source}, nil
+}
+
+// OpenParquetFile opens a Parquet file made by ParquetWriter for reading
+func OpenParquetFile(filename string) (*ParquetReader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	reader, err := NewParquetReader(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	reader.closer = file
+	return reader, nil
+}
+
+// TypeName returns the type of the results in the file
+func (reader *ParquetReader) TypeName() string {
+	return reader.resultType
+}
+
+// Read returns the next result, or io.EOF if there are no more
+func (reader *ParquetReader) Read() (Result, error) {
+	return reader.source.next()
+}
+
+// Send reads all results and puts them on a channel, then closes the
+// channel. Reading stops at the first error, which is put on the channel
+func (reader *ParquetReader) Send(results chan AsyncResult) {
+	defer close(results)
+	for {
+		res, err := reader.Read()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			results <- AsyncResult{Result: nil, Error: err}
+			return
+		}
+		results <- AsyncResult{Result: &res, Error: nil}
+	}
+}
+
+// Close closes the file if it was opened by OpenParquetFile
+func (reader *ParquetReader) Close() error {
+	if reader.closer == nil {
+		return nil
+	}
+	return reader.closer.Close()
+}
+
+// parquetSink buffers rows of one type and writes them in row groups
+type parquetSink interface {
+	add(res Result) error
+	close() error
+}
+
+// parquetSource reads rows of one type and turns them into results
+type parquetSource interface {
+	next() (Result, error)
+}
+
+func newParquetSink(resultType string, w io.Writer, size int, options ...parquet.WriterOption) parquetSink {
+	switch resultType {
+	case "ping":
+		return newParquetTable(w, size, parquetPingRow, options)
+	case "traceroute":
+		return newParquetTable(w, size, parquetTracerouteRow, options)
+	case "dns":
+		return newParquetTable(w, size, parquetDnsRow, options)
+	case "http":
+		return newParquetTable(w, size, parquetHttpRow, options)
+	case "sslcert":
+		return newParquetTable(w, size, parquetCertRow, options)
+	case "ntp":
+		return newParquetTable(w, size, parquetNtpRow, options)
+	}
+	return nil
+}
+
+func newParquetSource(resultType string, file *parquet.File) parquetSource {
+	switch resultType {
+	case "ping":
+		return newParquetRows(file, (*parquetPing).result)
+	case "traceroute":
+		return newParquetRows(file, (*parquetTraceroute).result)
+	case "dns":
+		return newParquetRows(file, (*parquetDns).result)
+	case "http":
+		return newParquetRows(file, (*parquetHttp).result)
+	case "sslcert":
+		return newParquetRows(file, (*parquetCert).result)
+	case "ntp":
+		return newParquetRows(file, (*parquetNtp).result)
+	}
+	return nil
+}
+
+// parquetTable is a parquetSink for one row type
+type parquetTable[T any] struct {
+	writer *parquet.GenericWriter[T]
+	rows   []T
+	toRow  func(res Result) (T, error)
+}
+
+func newParquetTable[T any](
+	w io.Writer,
+	size int,
+	toRow func(res Result) (T, error),
+	options []parquet.WriterOption,
+) *parquetTable[T] {
+	return &parquetTable[T]{
+		writer: parquet.NewGenericWriter[T](w, options...),
+		rows:   make([]T, 0, size),
+		toRow:  toRow,
+	}
+}
+
+func (table *parquetTable[T]) add(res Result) error {
+	row, err := table.toRow(res)
+	if err != nil {
+		return err
+	}
+	table.rows = append(table.rows, row)
+	if len(table.rows) == cap(table.rows) {
+		return table.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a row group
+func (table *parquetTable[T]) flush() error {
+	if len(table.rows) == 0 {
+		return nil
+	}
+	if _, err := table.writer.Write(table.rows); err != nil {
+		return err
+	}
+	clear(table.rows)
+	table.rows = table.rows[:0]
+	return table.writer.Flush()
+}
+
+func (table *parquetTable[T]) close() error {
+	if err := table.flush(); err != nil {
+		return err
+	}
+	return table.writer.Close()
+}
+
+// parquetRows is a parquetSource for one row type
+type parquetRows[T any] struct {
+	reader   *parquet.GenericReader[T]
+	rows     []T
+	pos, end int
+	eof      bool
+	toResult func(row *T) (Result, error)
+}
+
+func newParquetRows[T any](file *parquet.File, toResult func(row *T) (Result, error)) *parquetRows[T] {
+	return &parquetRows[T]{
+		reader:   parquet.NewGenericReader[T](file),
+		rows:     make([]T, 64),
+		toResult: toResult,
+	}
+}
+
+func (rows *parquetRows[T]) next() (Result, error) {
+	for rows.pos == rows.end {
+		if rows.eof {
+			return nil, io.EOF
+		}
+		// start with clean rows, so that nothing is shared with the
+		// previous batch
+		clear(rows.rows)
+		n, err := rows.reader.Read(rows.rows)
+		if errors.Is(err, io.EOF) {
+			rows.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+		rows.pos, rows.end = 0, n
+	}
+	row := &rows.rows[rows.pos]
+	rows.pos++
+	return rows.toResult(row)
+}
+
+//////////////////////////////////////////////////////
+// Parquet version of results
+
+// the common fields of all results
+type parquetBase struct {
+	MeasurementID   int64   `parquet:"msm_id"`                    //
+	GroupID         int64   `parquet:"group_id,optional"`         //
+	ProbeID         int64   `parquet:"prb_id"`                    //
+	Type            string  `parquet:"type"`                      //
+	FirmwareVersion int64   `parquet:"fw"`                        //
+	CodeVersion     string  `parquet:"mver,optional"`             //
+	MeasurementName string  `parquet:"msm_name,optional"`         //
+	TimeStamp       int64   `parquet:"timestamp"`                 //
+	StoreTimeStamp  int64   `parquet:"stored_timestamp,optional"` //
+	Bundle          int64   `parquet:"bundle,optional"`           //
+	LastTimeSync    int64   `parquet:"lts"`                       //
+	DestinationName string  `parquet:"dst_name,optional"`         //
+	DestinationAddr string  `parquet:"dst_addr,optional"`         //
+	SourceAddr      string  `parquet:"src_addr,optional"`         //
+	FromAddr        string  `parquet:"from,optional"`             //
+	AddressFamily   int64   `parquet:"af,optional"`               //
+	ResolveTime     float64 `parquet:"ttr,optional"`              // 0 if not resolved on the probe
+}
+
+func makeParquetBase(base *BaseResult) parquetBase {
+	row := parquetBase{
+		MeasurementID:   int64(base.MeasurementID),
+		GroupID:         int64(base.GroupID),
+		ProbeID:         int64(base.ProbeID),
+		Type:            base.Type,
+		FirmwareVersion: int64(base.FirmwareVersion),
+		CodeVersion:     base.CodeVersion,
+		MeasurementName: base.MeasurementName,
+		TimeStamp:       parquetTime(time.Time(base.TimeStamp)),
+		StoreTimeStamp:  parquetTime(time.Time(base.StoreTimeStamp)),
+		Bundle:          int64(base.Bundle),
+		LastTimeSync:    int64(base.LastTimeSync),
+		DestinationName: base.DestinationName,
+		SourceAddr:      csvAddr(base.SourceAddr),
+		FromAddr:        csvAddr(base.FromAddr),
+		AddressFamily:   int64(base.AddressFamily),
+	}
+	if base.DestinationAddr != nil {
+		row.DestinationAddr = base.DestinationAddr.String()
+	}
+	if base.ResolveTime != nil {
+		row.ResolveTime = *base.ResolveTime
+	}
+	return row
+}
+
+func (row *parquetBase) baseResult() BaseResult {
+	base := BaseResult{
+		FirmwareVersion: firmwareVersion(row.FirmwareVersion),
+		CodeVersion:     row.CodeVersion,
+		MeasurementID:   uint(row.MeasurementID),
+		GroupID:         uint(row.GroupID),
+		ProbeID:         uint(row.ProbeID),
+		MeasurementName: row.MeasurementName,
+		Type:            row.Type,
+		TimeStamp:       uniTime(fromParquetTime(row.TimeStamp)),
+		StoreTimeStamp:  uniTime(fromParquetTime(row.StoreTimeStamp)),
+		Bundle:          uint(row.Bundle),
+		LastTimeSync:    int(row.LastTimeSync),
+		DestinationName: row.DestinationName,
+		SourceAddr:      parquetAddr(row.SourceAddr),
+		FromAddr:        parquetAddr(row.FromAddr),
+		AddressFamily:   uint(row.AddressFamily),
+	}
+	if row.DestinationAddr != "" {
+		addr := parquetAddr(row.DestinationAddr)
+		base.DestinationAddr = &addr
+	}
+	if row.ResolveTime != 0 {
+		ttr := row.ResolveTime
+		base.ResolveTime = &ttr
+	}
+	return base
+}
+
+type parquetPing struct {
+	parquetBase
+	Protocol   string             `parquet:"proto"`         //
+	PacketSize int64              `parquet:"size"`          //
+	Ttl        int64              `parquet:"ttl"`           //
+	Step       *int64             `parquet:"step,optional"` //
+	Sent       int64              `parquet:"sent"`          //
+	Received   int64              `parquet:"rcvd"`          //
+	Duplicates int64              `parquet:"dup"`           //
+	Minimum    float64            `parquet:"min"`           // -1 if N/A
+	Average    float64            `parquet:"avg"`           // -1 if N/A
+	Median     float64            `parquet:"median"`        // -1 if N/A
+	Maximum    float64            `parquet:"max"`           // -1 if N/A
+	Timeouts   int64              `parquet:"timeouts"`      //
+	Errors     []string           `parquet:"errors,list"`   //
+	Replies    []parquetPingReply `parquet:"replies,list"`  //
+	ItemOrder  string             `parquet:"item_order"`    // order of replies, errors and timeouts in the result, e.g. "rtr"
+}
+
+type parquetPingReply struct {
+	Rtt       float64 `parquet:"rtt"`               //
+	Source    string  `parquet:"src_addr,optional"` //
+	Ttl       int64   `parquet:"ttl"`               //
+	Duplicate bool    `parquet:"dup"`               //
+}
+
+func parquetPingRow(res Result) (parquetPing, error) {
+	ping := res.(*PingResult)
+	row := parquetPing{
+		parquetBase: makeParquetBase(&ping.BaseResult),
+		Protocol:    ping.Protocol,
+		PacketSize:  int64(ping.PacketSize),
+		Ttl:         int64(ping.Ttl),
+		Step:        parquetUint(ping.Step),
+		Sent:        int64(ping.Sent),
+		Received:    int64(ping.Received),
+		Duplicates:  int64(ping.Duplicates),
+		Minimum:     ping.Minimum,
+		Average:     ping.Average,
+		Median:      ping.Median,
+		Maximum:     ping.Maximum,
+		Timeouts:    int64(ping.Timeouts),
+		Errors:      ping.Errors,
+		Replies:     make([]parquetPingReply, 0, len(ping.Replies)),
+		ItemOrder:   string(ping.order),
+	}
+	for _, reply := range ping.Replies {
+		row.Replies = append(row.Replies, parquetPingReply{
+			reply.Rtt, csvAddr(reply.Source), int64(reply.Ttl), reply.Duplicate,
+		})
+	}
+	return row, nil
+}
+
+func (row *parquetPing) result() (Result, error) {
+	ping := &PingResult{
+		BaseResult: row.baseResult(),
+		Sent:       uint(row.Sent),
+		Received:   uint(row.Received),
+		Duplicates: uint(row.Duplicates),
+		Minimum:    row.Minimum,
+		Average:    row.Average,
+		Median:     row.Median,
+		Maximum:    row.Maximum,
+		PacketSize: uint(row.PacketSize),
+		Protocol:   row.Protocol,
+		Step:       fromParquetUint(row.Step),
+		Ttl:        uint(row.Ttl),
+		Replies:    make([]PingReply, 0, len(row.Replies)),
+		Errors:     make([]string, 0, len(row.Errors)),
+		Timeouts:   uint(row.Timeouts),
+		order:      []byte(row.ItemOrder),
+	}
+	for _, reply := range row.Replies {
+		ping.Replies = append(ping.Replies, PingReply{
+			reply.Rtt, parquetAddr(reply.Source), uint(reply.Ttl), reply.Duplicate,
+		})
+	}
+	ping.Errors = append(ping.Errors, row.Errors...)
+	return ping, nil
+}
+
+type parquetTraceroute struct {
+	parquetBase
+	EndTime       int64             `parquet:"endtime"`   //
+	ParisID       int64             `parquet:"paris_id"`  //
+	Protocol      string            `parquet:"proto"`     //
+	PacketSize    int64             `parquet:"size"`      //
+	TypeOfService int64             `parquet:"tos"`       //
+	Hops          []parquetTraceHop `parquet:"hops,list"` //
+}
+
+type parquetTraceHop struct {
+	HopNumber int64                 `parquet:"hop"`            //
+	SendError *string               `parquet:"error,optional"` //
+	Responses []parquetTraceHopData `parquet:"result,list"`    //
+}
+
+type parquetTraceHopData struct {
+	Timeout          bool    `parquet:"x"`                   // timed out
+	Error            *string `parquet:"error,optional"`      //
+	ErrorCode        string  `parquet:"err,optional"`        // N/H/A/P/p/h/(int)
+	From             string  `parquet:"from,optional"`       //
+	ITypeOfService   *int64  `parquet:"itos,optional"`       //
+	ITtl             *int64  `parquet:"ittl,optional"`       //
+	ErrorDestination string  `parquet:"edst,optional"`       //
+	Late             *int64  `parquet:"late,optional"`       //
+	Mtu              *int64  `parquet:"mtu,optional"`        //
+	Rtt              float64 `parquet:"rtt"`                 //
+	Size             int64   `parquet:"size"`                //
+	Ttl              int64   `parquet:"ttl"`                 //
+	Flags            *string `parquet:"flags,optional"`      //
+	DestOptSize      *int64  `parquet:"dstoptsize,optional"` //
+	HopByHopOptSize  *int64  `parquet:"hbhoptsize,optional"` //
+	IcmpExtensions   string  `parquet:"icmpext,optional"`    // JSON encoded
+}
+
+func parquetTracerouteRow(res Result) (parquetTraceroute, error) {
+	trace := res.(*TracerouteResult)
+	row := parquetTraceroute{
+		parquetBase:   makeParquetBase(&trace.BaseResult),
+		EndTime:       parquetTime(time.Time(trace.EndTime)),
+		ParisID:       int64(trace.ParisID),
+		Protocol:      trace.Protocol,
+		PacketSize:    int64(trace.PacketSize),
+		TypeOfService: int64(trace.TypeOfService),
+		Hops:          make([]parquetTraceHop, 0, len(trace.Hops)),
+	}
+	for _, hop := range trace.Hops {
+		phop := parquetTraceHop{
+			HopNumber: int64(hop.HopNumber),
+			SendError: hop.SendError,
+			Responses: make([]parquetTraceHopData, 0, len(hop.Responses)),
+		}
+		for _, resp := range hop.Responses {
+			data := parquetTraceHopData{
+				Timeout:         resp.Timeout,
+				Error:           resp.Error,
+				ErrorCode:       resp.ErrorCode,
+				From:            csvAddr(resp.From),
+				ITypeOfService:  parquetUint(resp.ITypeOfService),
+				ITtl:            parquetUint(resp.ITtl),
+				Late:            parquetUint(resp.Late),
+				Mtu:             parquetUint(resp.Mtu),
+				Rtt:             resp.Rtt,
+				Size:            int64(resp.Size),
+				Ttl:             int64(resp.Ttl),
+				Flags:           resp.Flags,
+				DestOptSize:     parquetUint(resp.DestOptSize),
+				HopByHopOptSize: parquetUint(resp.HopByHopOptSize),
+			}
+			if resp.ErrorDestination != nil {
+				data.ErrorDestination = resp.ErrorDestination.String()
+			}
+			if len(resp.IcmpExtensions) > 0 {
+				ext, err := json.Marshal(resp.IcmpExtensions)
+				if err != nil {
+					return row, parseError(&trace.BaseResult, "traceroute", err)
+				}
+				data.IcmpExtensions = string(ext)
+			}
+			phop.Responses = append(phop.Responses, data)
+		}
+		row.Hops = append(row.Hops, phop)
+	}
+	return row, nil
+}
+
+func (row *parquetTraceroute) result() (Result, error) {
+	trace := &TracerouteResult{
+		BaseResult:    row.baseResult(),
+		EndTime:       uniTime(fromParquetTime(row.EndTime)),
+		ParisID:       uint(row.ParisID),
+		Protocol:      row.Protocol,
+		PacketSize:    uint(row.PacketSize),
+		TypeOfService: uint(row.TypeOfService),
+		Hops:          make([]TracerouteHop, 0, len(row.Hops)),
+	}
+	for _, phop := range row.Hops {
+		hop := TracerouteHop{
+			HopNumber: uint(phop.HopNumber),
+			SendError: phop.SendError,
+			Responses: make([]TraceRouteHopData, 0, len(phop.Responses)),
+		}
+		for _, data := range phop.Responses {
+			resp := TraceRouteHopData{
+				Error:           data.Error,
+				Timeout:         data.Timeout,
+				ErrorCode:       data.ErrorCode,
+				From:            parquetAddr(data.From),
+				ITypeOfService:  fromParquetUint(data.ITypeOfService),
+				ITtl:            fromParquetUint(data.ITtl),
+				Late:            fromParquetUint(data.Late),
+				Mtu:             fromParquetUint(data.Mtu),
+				Rtt:             data.Rtt,
+				Size:            uint(data.Size),
+				Ttl:             int(data.Ttl),
+				Flags:           data.Flags,
+				DestOptSize:     fromParquetUint(data.DestOptSize),
+				HopByHopOptSize: fromParquetUint(data.HopByHopOptSize),
+			}
+			if data.ErrorDestination != "" {
+				edst := parquetAddr(data.ErrorDestination)
+				resp.ErrorDestination = &edst
+			}
+			if data.IcmpExtensions != "" {
+				err := json.Unmarshal([]byte(data.IcmpExtensions), &resp.IcmpExtensions)
+				if err != nil {
+					return nil, parseError(&trace.BaseResult, "traceroute", err)
+				}
+			}
+			hop.Responses = append(hop.Responses, resp)
+		}
+		trace.Hops = append(trace.Hops, hop)
+	}
+	return trace, nil
+}
+
+type parquetDns struct {
+	parquetBase
+	Error     []parquetDnsError    `parquet:"error,list"`     //
+	Responses []parquetDnsResponse `parquet:"responses,list"` //
+}
+
+type parquetDnsError struct {
+	Timeout  int64  `parquet:"timeout,optional"`     //
+	AddrInfo string `parquet:"getaddrinfo,optional"` //
+}
+
+type parquetDnsResponse struct {
+	TimeStamp       int64              `parquet:"time"`              //
+	SourceAddr      string             `parquet:"src_addr,optional"` //
+	DestinationAddr string             `parquet:"dst_addr,optional"` //
+	DestinationPort int64              `parquet:"dst_port"`          //
+	AddressFamily   int64              `parquet:"af"`                //
+	Protocol        string             `parquet:"proto"`             //
+	RetryCount      int64              `parquet:"retry"`             //
+	LastTimeSync    int64              `parquet:"lts"`               //
+	SubID           int64              `parquet:"subid,optional"`    //
+	SubMax          int64              `parquet:"submax,optional"`   //
+	Error           []parquetDnsError  `parquet:"error,list"`        //
+	QueryBuf        []byte             `parquet:"qbuf"`              //
+	AnswerBuf       []byte             `parquet:"abuf"`              //
+	ResponseTime    float64            `parquet:"rt"`                //
+	ResponseSize    int64              `parquet:"size"`              //
+	QueryID         int64              `parquet:"id"`                //
+	QueriesCount    int64              `parquet:"qdcount"`           //
+	AnswerCount     int64              `parquet:"ancount"`           //
+	NameServerCount int64              `parquet:"nscount"`           //
+	AdditionalCount int64              `parquet:"arcount"`           //
+	Ttl6            int64              `parquet:"ttl,optional"`      //
+	Rcode           string             `parquet:"rcode,optional"`    // from abuf, for convenience
+	Nsid            string             `parquet:"nsid,optional"`     // from abuf, for convenience
+	Answers         []parquetDnsAnswer `parquet:"answers,list"`      // from abuf, for convenience
+}
+
+// one resource record in a response; the section is "answer",
+// "authority" or "additional"
+type parquetDnsAnswer struct {
+	Section string `parquet:"section"` //
+	Name    string `parquet:"name"`    //
+	Class   string `parquet:"class"`   //
+	Type    string `parquet:"type"`    //
+	Ttl     int64  `parquet:"ttl"`     //
+	Data    string `parquet:"data"`    //
+}
+
+func makeParquetDnsErrors(errs []DnsError) []parquetDnsError {
+	list := make([]parquetDnsError, 0, len(errs))
+	for _, e := range errs {
+		list = append(list, parquetDnsError{int64(e.Timeout), e.AddrInfo})
+	}
+	return list
+}
+
+func parquetDnsRow(res Result) (parquetDns, error) {
+	dns := res.(*DnsResult)
+	row := parquetDns{
+		parquetBase: makeParquetBase(&dns.BaseResult),
+		Error:       makeParquetDnsErrors(dns.Error),
+		Responses:   make([]parquetDnsResponse, 0, len(dns.Responses)),
+	}
+	for i := range dns.Responses {
+		resp, err := dns.Responses[i].packed()
+		if err != nil {
+			return row, parseError(&dns.BaseResult, "dns", err)
+		}
+		presp := parquetDnsResponse{
+			TimeStamp:       parquetTime(resp.TimeStamp),
+			SourceAddr:      csvAddr(resp.SourceAddr),
+			DestinationAddr: csvAddr(resp.Destination.Addr()),
+			DestinationPort: int64(resp.Destination.Port()),
+			AddressFamily:   int64(resp.AddressFamily),
+			Protocol:        resp.Protocol,
+			RetryCount:      int64(resp.RetryCount),
+			LastTimeSync:    int64(resp.LastTimeSync),
+			SubID:           int64(resp.SubID),
+			SubMax:          int64(resp.SubMax),
+			Error:           makeParquetDnsErrors(resp.Error),
+			QueryBuf:        resp.QueryBuf,
+			AnswerBuf:       resp.AnswerBuf,
+			ResponseTime:    resp.ResponseTime,
+			ResponseSize:    int64(resp.ResponseSize),
+			QueryID:         int64(resp.QueryID),
+			QueriesCount:    int64(resp.QueriesCount),
+			AnswerCount:     int64(resp.AnswerCount),
+			NameServerCount: int64(resp.NameServerCount),
+			AdditionalCount: int64(resp.AdditionalCount),
+			Ttl6:            int64(resp.Ttl6),
+			Answers:         make([]parquetDnsAnswer, 0),
+		}
+		if resp.AnswerMsg != nil {
+			presp.Rcode = resp.RcodeName()
+			presp.Nsid = resp.Nsid()
+		}
+		for _, section := range []struct {
+			name    string
+			answers []DnsAnswer
+		}{
+			{"answer", resp.Answer},
+			{"authority", resp.Ns},
+			{"additional", resp.Extra},
+		} {
+			for _, answer := range section.answers {
+				presp.Answers = append(presp.Answers, parquetDnsAnswer{
+					section.name,
+					answer.Name,
+					dnsClassName(answer.Class),
+					dnsTypeName(answer.Type),
+					int64(answer.Ttl),
+					answer.Data,
+				})
+			}
+		}
+		row.Responses = append(row.Responses, presp)
+	}
+	return row, nil
+}
+
+func (row *parquetDns) result() (Result, error) {
+	dns := &DnsResult{
+		BaseResult: row.baseResult(),
+		Error:      make([]DnsError, 0, len(row.Error)),
+		Responses:  make([]DnsResponse, 0, len(row.Responses)),
+	}
+	for _, e := range row.Error {
+		dns.Error = append(dns.Error, DnsError{uint(e.Timeout), e.AddrInfo})
+	}
+	for _, presp := range row.Responses {
+		var dnserror *dnsError
+		if len(presp.Error) > 0 {
+			dnserror = &dnsError{uint(presp.Error[0].Timeout), presp.Error[0].AddrInfo}
+		}
+		answer := dnsAnswer{
+			ResponseTime:    presp.ResponseTime,
+			ResponseSize:    uint(presp.ResponseSize),
+			Abuf:            base64.StdEncoding.EncodeToString(presp.AnswerBuf),
+			QueryID:         uint(presp.QueryID),
+			AnswerCount:     uint(presp.AnswerCount),
+			QueriesCount:    uint(presp.QueriesCount),
+			NameServerCount: uint(presp.NameServerCount),
+			AdditionalCount: uint(presp.AdditionalCount),
+		}
+		if presp.Ttl6 != 0 {
+			ttl := uint(presp.Ttl6)
+			answer.Ttl6 = &ttl
+		}
+		resp, err := makeDnsResponse(
+			fromParquetTime(presp.TimeStamp),
+			parquetAddr(presp.SourceAddr),
+			netip.AddrPortFrom(parquetAddr(presp.DestinationAddr), uint16(presp.DestinationPort)),
+			uint(presp.AddressFamily),
+			presp.Protocol,
+			dnserror,
+			uint(presp.RetryCount),
+			presp.QueryBuf,
+			answer,
+		)
+		if err != nil {
+			return nil, parseError(&dns.BaseResult, "dns", err)
+		}
+		resp.LastTimeSync = int(presp.LastTimeSync)
+		resp.SubID = uint(presp.SubID)
+		resp.SubMax = uint(presp.SubMax)
+		dns.Responses = append(dns.Responses, resp)
+	}
+	return dns, nil
+}
+
+type parquetHttp struct {
+	parquetBase
+	Uri     string             `parquet:"uri"`         //
+	Replies []parquetHttpReply `parquet:"result,list"` //
+}
+
+type parquetHttpReply struct {
+	AddressFamily   int64               `parquet:"af"`                //
+	SourceAddr      string              `parquet:"src_addr,optional"` //
+	DestinationAddr string              `parquet:"dst_addr,optional"` //
+	Method          string              `parquet:"method"`            //
+	Version         string              `parquet:"ver"`               //
+	ResultCode      int64               `parquet:"res"`               //
+	HeaderSize      int64               `parquet:"hsize"`             //
+	Headers         []string            `parquet:"header,list"`       //
+	BodySize        int64               `parquet:"bsize"`             //
+	ReplyTime       float64             `parquet:"rt"`                //
+	TimeToResolve   *float64            `parquet:"ttr,optional"`      //
+	TimeToConnect   float64             `parquet:"ttc"`               //
+	TimeToFirstByte float64             `parquet:"ttfb"`              //
+	DnsError        string              `parquet:"dnserr,optional"`   //
+	Error           string              `parquet:"err,optional"`      //
+	SubID           *int64              `parquet:"subid,optional"`    //
+	SubMax          *int64              `parquet:"submax,optional"`   //
+	Time            *int64              `parquet:"time,optional"`     //
+	ReadTiming      []parquetReadTiming `parquet:"readtiming,list"`   //
+}
+
+type parquetReadTiming struct {
+	Offset    int64   `parquet:"o"` //
+	TimeSince float64 `parquet:"t"` //
+}
+
+func parquetHttpRow(res Result) (parquetHttp, error) {
+	http := res.(*HttpResult)
+	row := parquetHttp{
+		parquetBase: makeParquetBase(&http.BaseResult),
+		Uri:         http.Uri,
+		Replies:     make([]parquetHttpReply, 0, len(http.Replies)),
+	}
+	for _, reply := range http.Replies {
+		preply := parquetHttpReply{
+			AddressFamily:   int64(reply.AddressFamily),
+			SourceAddr:      csvAddr(reply.SourceAddr),
+			DestinationAddr: csvAddr(reply.DestinationAddr),
+			Method:          reply.Method,
+			Version:         reply.Version,
+			ResultCode:      int64(reply.ResultCode),
+			HeaderSize:      int64(reply.HeaderSize),
+			Headers:         reply.Headers,
+			BodySize:        int64(reply.BodySize),
+			ReplyTime:       reply.ReplyTime,
+			TimeToResolve:   reply.TimeToResolve,
+			TimeToConnect:   reply.TimeToConnect,
+			TimeToFirstByte: reply.TimeToFirstByte,
+			DnsError:        reply.DnsError,
+			Error:           reply.Error,
+			SubID:           parquetUint(reply.SubID),
+			SubMax:          parquetUint(reply.SubMax),
+			ReadTiming:      make([]parquetReadTiming, 0, len(reply.ReadTiming)),
+		}
+		if reply.Time != nil {
+			t := parquetTime(*reply.Time)
+			preply.Time = &t
+		}
+		for _, rt := range reply.ReadTiming {
+			preply.ReadTiming = append(preply.ReadTiming, parquetReadTiming{int64(rt.Offset), rt.TimeSince})
+		}
+		row.Replies = append(row.Replies, preply)
+	}
+	return row, nil
+}
+
+func (row *parquetHttp) result() (Result, error) {
+	http := &HttpResult{
+		BaseResult: row.baseResult(),
+		Uri:        row.Uri,
+		Replies:    make([]HttpReply, 0, len(row.Replies)),
+	}
+	for _, preply := range row.Replies {
+		reply := HttpReply{
+			AddressFamily:   uint(preply.AddressFamily),
+			SourceAddr:      parquetAddr(preply.SourceAddr),
+			DestinationAddr: parquetAddr(preply.DestinationAddr),
+			Method:          preply.Method,
+			Version:         preply.Version,
+			ResultCode:      uint(preply.ResultCode),
+			HeaderSize:      uint(preply.HeaderSize),
+			Headers:         append(make([]string, 0, len(preply.Headers)), preply.Headers...),
+			BodySize:        uint(preply.BodySize),
+			ReplyTime:       preply.ReplyTime,
+			TimeToResolve:   preply.TimeToResolve,
+			TimeToConnect:   preply.TimeToConnect,
+			TimeToFirstByte: preply.TimeToFirstByte,
+			DnsError:        preply.DnsError,
+			Error:           preply.Error,
+			SubID:           fromParquetUint(preply.SubID),
+			SubMax:          fromParquetUint(preply.SubMax),
+			ReadTiming:      make([]HttpReadTiming, 0, len(preply.ReadTiming)),
+		}
+		reply.Status, reply.Header = parseHttpHeaders(reply.Headers)
+		if preply.Time != nil {
+			t := fromParquetTime(*preply.Time)
+			reply.Time = &t
+		}
+		for _, rt := range preply.ReadTiming {
+			reply.ReadTiming = append(reply.ReadTiming, HttpReadTiming{uint(rt.Offset), rt.TimeSince})
+		}
+		http.Replies = append(http.Replies, reply)
+	}
+	http.useFirstReply()
+	return http, nil
+}
+
+type parquetCert struct {
+	parquetBase
+	Method           string   `parquet:"method,optional"`            //
+	ProtocolVersion  string   `parquet:"ver,optional"`               //
+	ServerCipher     string   `parquet:"server_cipher,optional"`     //
+	ConnectTime      float64  `parquet:"ttc"`                        //
+	ReplyTime        float64  `parquet:"rt"`                         //
+	Error            *string  `parquet:"err,optional"`               //
+	DnsError         string   `parquet:"dnserr,optional"`            //
+	AlertLevel       *int64   `parquet:"alert_level,optional"`       //
+	AlertDescription *int64   `parquet:"alert_description,optional"` //
+	Certificates     [][]byte `parquet:"cert,list"`                  // DER encoded, leaf first
+}
+
+func parquetCertRow(res Result) (parquetCert, error) {
+	cert := res.(*CertResult)
+	row := parquetCert{
+		parquetBase:     makeParquetBase(&cert.BaseResult),
+		Method:          cert.Method,
+		ProtocolVersion: cert.ProtocolVersion,
+		ServerCipher:    cert.ServerCipher,
+		ConnectTime:     cert.ConnectTime,
+		ReplyTime:       cert.ReplyTime,
+		Error:           cert.Error,
+		DnsError:        cert.DnsError,
+		Certificates:    make([][]byte, 0, len(cert.Certificates)),
+	}
+	if cert.Alert != nil {
+		level, description := int64(cert.Alert.Level), int64(cert.Alert.Description)
+		row.AlertLevel = &level
+		row.AlertDescription = &description
+	}
+	for _, c := range cert.Certificates {
+		row.Certificates = append(row.Certificates, c.Raw)
+	}
+	return row, nil
+}
+
+func (row *parquetCert) result() (Result, error) {
+	cert := &CertResult{
+		BaseResult:      row.baseResult(),
+		Error:           row.Error,
+		Method:          row.Method,
+		ConnectTime:     row.ConnectTime,
+		ReplyTime:       row.ReplyTime,
+		ServerCipher:    row.ServerCipher,
+		ProtocolVersion: row.ProtocolVersion,
+		Certificates:    make([]x509.Certificate, 0, len(row.Certificates)),
+		DnsError:        row.DnsError,
+	}
+	if row.AlertLevel != nil && row.AlertDescription != nil {
+		cert.Alert = &CertAlert{uint(*row.AlertLevel), uint(*row.AlertDescription)}
+	}
+	for _, der := range row.Certificates {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, parseError(&cert.BaseResult, "sslcert", err)
+		}
+		cert.Certificates = append(cert.Certificates, *c)
+	}
+	return cert, nil
+}
+
+type parquetNtp struct {
+	parquetBase
+	Protocol           string            `parquet:"proto"`           //
+	Version            int64             `parquet:"version"`         //
+	LeapIndicator      string            `parquet:"li,optional"`     //
+	Mode               string            `parquet:"mode,optional"`   //
+	Stratum            int64             `parquet:"stratum"`         //
+	PollInterval       int64             `parquet:"poll"`            //
+	Precision          float64           `parquet:"precision"`       //
+	RootDelay          float64           `parquet:"root-delay"`      //
+	RootDispersion     float64           `parquet:"root-dispersion"` //
+	ReferenceID        string            `parquet:"ref-id,optional"` //
+	ReferenceTimestamp float64           `parquet:"ref-ts"`          //
+	Replies            []parquetNtpReply `parquet:"replies,list"`    //
+	Errors             []string          `parquet:"errors,list"`     //
+}
+
+type parquetNtpReply struct {
+	OriginTimestamp   float64 `parquet:"origin-ts"`   //
+	TransmitTimestamp float64 `parquet:"transmit-ts"` //
+	ReceiveTimestamp  float64 `parquet:"receive-ts"`  //
+	FinalTimestamp    float64 `parquet:"final-ts"`    //
+	Offset            float64 `parquet:"offset"`      //
+	Rtt               float64 `parquet:"rtt"`         //
+}
+
+func parquetNtpRow(res Result) (parquetNtp, error) {
+	ntp := res.(*NtpResult)
+	row := parquetNtp{
+		parquetBase:        makeParquetBase(&ntp.BaseResult),
+		Protocol:           ntp.Protocol,
+		Version:            int64(ntp.Version),
+		LeapIndicator:      ntp.LeapIndicator,
+		Mode:               ntp.Mode,
+		Stratum:            int64(ntp.Stratum),
+		PollInterval:       int64(ntp.PollInterval),
+		Precision:          ntp.Precision,
+		RootDelay:          ntp.RootDelay,
+		RootDispersion:     ntp.RootDispersion,
+		ReferenceID:        ntp.ReferenceID,
+		ReferenceTimestamp: ntp.ReferenceTimestamp,
+		Replies:            make([]parquetNtpReply, 0, len(ntp.Replies)),
+		Errors:             ntp.Errors,
+	}
+	for _, reply := range ntp.Replies {
+		row.Replies = append(row.Replies, parquetNtpReply(reply))
+	}
+	return row, nil
+}
+
+func (row *parquetNtp) result() (Result, error) {
+	ntp := &NtpResult{
+		BaseResult:         row.baseResult(),
+		Protocol:           row.Protocol,
+		Version:            uint(row.Version),
+		LeapIndicator:      row.LeapIndicator,
+		Mode:               row.Mode,
+		Stratum:            uint(row.Stratum),
+		PollInterval:       uint(row.PollInterval),
+		Precision:          row.Precision,
+		RootDelay:          row.RootDelay,
+		RootDispersion:     row.RootDispersion,
+		ReferenceID:        row.ReferenceID,
+		ReferenceTimestamp: row.ReferenceTimestamp,
+		Replies:            make([]NtpReply, 0, len(row.Replies)),
+		Errors:             append(make([]string, 0, len(row.Errors)), row.Errors...),
+	}
+	for _, reply := range row.Replies {
+		ntp.Replies = append(ntp.Replies, NtpReply(reply))
+	}
+	return ntp, nil
+}
+
+// parquetTime turns a time into UNIX epoch seconds, 0 if it's not set
+func parquetTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func fromParquetTime(epoch int64) time.Time {
+	if epoch == 0 {
+		return time.Time{}
+	}
+	return time.Unix(epoch, 0).UTC()
+}
+
+func parquetAddr(addr string) netip.Addr {
+	parsed, _ := netip.ParseAddr(addr) // invalid if empty
+	return parsed
+}
+
+func parquetUint(val *uint) *int64 {
+	if val == nil {
+		return nil
+	}
+	v := int64(*val)
+	return &v
+}
+
+func fromParquetUint(val *int64) *uint {
+	if val == nil {
+		return nil
+	}
+	v := uint(*val)
+	return &v
+}
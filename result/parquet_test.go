@@ -0,0 +1,176 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// fixtureResults reads all the results from the fixtures, by type
+func fixtureResults(t *testing.T) map[string][]Result {
+	names, err := filepath.Glob("../testdata/*.txt")
+	if err != nil {
+		t.Fatalf("error listing fixtures: %v", err)
+	}
+	results := make(map[string][]Result)
+	for _, name := range names {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatalf("error opening %s: %v", name, err)
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			res, err := Parse(scanner.Text())
+			if err != nil {
+				t.Fatalf("error parsing %s: %v", name, err)
+			}
+			results[res.TypeName()] = append(results[res.TypeName()], res)
+		}
+		file.Close()
+	}
+	return results
+}
+
+// Test that results survive a trip through Parquet files
+func TestParquetRoundTrip(t *testing.T) {
+	fixtures := fixtureResults(t)
+	dir := t.TempDir()
+
+	for _, typename := range ParquetTypes {
+		results := fixtures[typename]
+		if len(results) == 0 {
+			t.Fatalf("no %s fixtures", typename)
+		}
+
+		name := filepath.Join(dir, typename+".parquet")
+		out, err := os.Create(name)
+		if err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+		writer, err := NewParquetWriter(out, typename)
+		if err != nil {
+			t.Fatalf("error creating Parquet writer: %v", err)
+		}
+		writer.RowGroupSize(3)
+		writer.Compression("snappy")
+		ch := make(chan AsyncResult)
+		go func() {
+			for _, res := range results {
+				ch <- AsyncResult{Result: &res}
+			}
+			ch <- AsyncResult{Result: &fixtures["uptime"][0]}
+			close(ch)
+		}()
+		if err := writer.Consume(ch); err != nil {
+			t.Fatalf("error writing %s results: %v", typename, err)
+		}
+		out.Close()
+		assertEqual(t, writer.Written, uint(len(results)), typename+" results written")
+		assertEqual(t, writer.Skipped, uint(1), typename+" results skipped")
+
+		// rows are in row groups of the configured size
+		file, _ := os.Open(name)
+		info, _ := file.Stat()
+		pfile, err := parquet.OpenFile(file, info.Size())
+		if err != nil {
+			t.Fatalf("error opening %s: %v", name, err)
+		}
+		assertEqual(t, len(pfile.RowGroups()), (len(results)+2)/3, typename+" row groups")
+		assertEqual(t, pfile.NumRows(), int64(len(results)), typename+" rows")
+		file.Close()
+
+		reader, err := OpenParquetFile(name)
+		if err != nil {
+			t.Fatalf("error opening %s: %v", name, err)
+		}
+		assertEqual(t, reader.TypeName(), typename, "type in the metadata")
+		for i, want := range results {
+			got, err := reader.Read()
+			if err != nil {
+				t.Fatalf("error reading %s result %d: %v", typename, i, err)
+			}
+			wantJSON, _ := json.Marshal(want)
+			gotJSON, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("error encoding %s result %d: %v", typename, i, err)
+			}
+			if !bytes.Equal(wantJSON, gotJSON) {
+				t.Errorf("%s result %d differs after reading it back:\n%s\n%s", typename, i, wantJSON, gotJSON)
+			}
+		}
+		if _, err := reader.Read(); err != io.EOF {
+			t.Errorf("expected EOF after all %s results, got %v", typename, err)
+		}
+		reader.Close()
+	}
+}
+
+// Test the typed results coming back, and reading to a channel
+func TestParquetReader(t *testing.T) {
+	fixtures := fixtureResults(t)
+
+	var buf bytes.Buffer
+	writer, _ := NewParquetWriter(&buf, "traceroute")
+	for _, res := range fixtures["traceroute"] {
+		if err := writer.Write(res); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+
+	reader, err := NewParquetReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("error opening: %v", err)
+	}
+	results := make(chan AsyncResult)
+	go reader.Send(results)
+	n := 0
+	for res := range Typed[*TracerouteResult](results) {
+		if res.Error != nil {
+			t.Fatalf("error reading: %v", res.Error)
+		}
+		want := fixtures["traceroute"][n].(*TracerouteResult)
+		assertEqual(t, len(res.Result.Hops), len(want.Hops), "number of hops")
+		assertEqual(t, len(res.Result.MplsTunnels()), len(want.MplsTunnels()), "MPLS tunnels")
+		n++
+	}
+	assertEqual(t, n, len(fixtures["traceroute"]), "number of results read")
+
+	// empty files have a schema, and no results
+	buf.Reset()
+	writer, _ = NewParquetWriter(&buf, "dns")
+	writer.Close()
+	reader, err = NewParquetReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("error opening empty file: %v", err)
+	}
+	if _, err := reader.Read(); err != io.EOF {
+		t.Errorf("expected EOF for an empty file, got %v", err)
+	}
+
+	if _, err := NewParquetWriter(&buf, "uptime"); err == nil {
+		t.Errorf("unsupported type should be rejected")
+	}
+	writer, _ = NewParquetWriter(&buf, "ping")
+	if err := writer.Compression("lzma"); err == nil {
+		t.Errorf("unknown codec should be rejected")
+	}
+	if err := writer.RowGroupSize(0); err == nil {
+		t.Errorf("bad row group size should be rejected")
+	}
+}
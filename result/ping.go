@@ -7,8 +7,8 @@
 package result
 
 import (
+	"bytes"
 	"encoding/json"
-	"fmt"
 	"math"
 	"net/netip"
 	"sort"
@@ -25,6 +25,7 @@ type PingResult struct {
 	Replies                           []PingReply //
 	Errors                            []string    //
 	Timeouts                          uint        //
+	order                             []byte      // kinds of the items in the result, as they came: 'r'eply, 'e'rror, 't'imeout
 }
 
 // one successful ping reply
@@ -39,15 +40,16 @@ func (ping *PingResult) Parse(from string) (err error) {
 	var iping pingResult
 	err = json.Unmarshal([]byte(from), &iping)
 	if err != nil {
-		return err
+		return parseError(&iping.BaseResult, "ping", err)
 	}
 	if iping.Type != "ping" {
-		return fmt.Errorf("this is not a ping result (type=%s)", iping.Type)
+		return parseErrorf(&iping.BaseResult, "ping", "this is not a ping result (type=%s)", iping.Type)
 	}
 	ping.BaseResult = iping.BaseResult
 	ping.Replies = iping.Replies()
 	ping.Errors = iping.Errors()
 	ping.Timeouts = uint(iping.Timeouts())
+	ping.order = iping.itemOrder()
 	ping.Minimum = iping.Minimum
 	ping.Average = iping.Average
 	ping.Maximum = iping.Maximum
@@ -72,6 +74,13 @@ func (result *PingResult) TypeName() string {
 	return "ping"
 }
 
+// MarshalJSON encodes the result in the format of the API. Replies, errors
+// and timeouts are in their original order; if that's not known (e.g. the
+// result was made by hand), replies come first, then errors and timeouts
+func (ping *PingResult) MarshalJSON() ([]byte, error) {
+	return marshalAtlas(ping.api(), &ping.BaseResult)
+}
+
 func (result *PingResult) ReplyRtts() []float64 {
 	r := make([]float64, 0)
 	for _, item := range result.Replies {
@@ -86,17 +95,27 @@ func (result *PingResult) ReplyRtts() []float64 {
 // this is the JSON structure as reported by the API
 type pingResult struct {
 	BaseResult
-	Minimum    float64 `json:"min"`    //
-	Average    float64 `json:"avg"`    //
-	Maximum    float64 `json:"max"`    //
-	Sent       uint    `json:"sent"`   //
-	Received   uint    `json:"rcvd"`   //
-	Duplicates uint    `json:"dup"`    //
-	PacketSize uint    `json:"size"`   //
-	Protocol   string  `json:"proto"`  //
-	Step       *uint   `json:"step"`   //
-	Ttl        *uint   `json:"ttl"`    //
-	RawResult  []any   `json:"result"` //
+	Minimum    float64        `json:"min"`    //
+	Average    float64        `json:"avg"`    //
+	Maximum    float64        `json:"max"`    //
+	Sent       uint           `json:"sent"`   //
+	Received   uint           `json:"rcvd"`   //
+	Duplicates uint           `json:"dup"`    //
+	PacketSize uint           `json:"size"`   //
+	Protocol   string         `json:"proto"`  //
+	Step       *uint          `json:"step"`   //
+	Ttl        *uint          `json:"ttl"`    //
+	RawResult  []rawPingReply `json:"result"` //
+}
+
+// one item in the result: a reply, an error or a timeout
+type rawPingReply struct {
+	Rtt        *float64        `json:"rtt"`      //
+	SourceAddr *string         `json:"src_addr"` //
+	Ttl        *uint           `json:"ttl"`      //
+	Duplicate  json.RawMessage `json:"dup"`      // only its presence matters
+	Error      *string         `json:"error"`    //
+	Timeout    json.RawMessage `json:"x"`        // only its presence matters
 }
 
 // parse replies in the result
@@ -107,27 +126,26 @@ func (result *pingResult) Replies() []PingReply {
 	max := 0.0
 	sum := 0.0
 	for _, item := range result.RawResult {
-		mapitem := item.(map[string]any)
-		if rtt, ok := mapitem["rtt"]; ok {
+		if item.Rtt != nil {
 			// fill in other fields of a reply struct
-			pr := PingReply{Rtt: rtt.(float64)}
+			pr := PingReply{Rtt: *item.Rtt}
 			min = math.Min(min, pr.Rtt)
 			max = math.Max(max, pr.Rtt)
 			sum += pr.Rtt
-			if src, ok := mapitem["src_addr"]; ok {
-				src, err := netip.ParseAddr(src.(string))
+			if item.SourceAddr != nil {
+				src, err := netip.ParseAddr(*item.SourceAddr)
 				if err == nil {
 					pr.Source = src
 				}
-			} else {
+			} else if result.DestinationAddr != nil {
 				pr.Source = *result.DestinationAddr // TODO: is this correct?
 			}
-			if ttl, ok := mapitem["ttl"]; ok {
-				pr.Ttl = uint(ttl.(float64))
+			if item.Ttl != nil {
+				pr.Ttl = *item.Ttl
 			} else if result.Ttl != nil {
 				pr.Ttl = *result.Ttl
 			}
-			_, pr.Duplicate = mapitem["dup"]
+			pr.Duplicate = item.Duplicate != nil
 
 			r = append(r, pr)
 		}
@@ -146,9 +164,8 @@ func (result *pingResult) Replies() []PingReply {
 func (result *pingResult) Errors() []string {
 	r := make([]string, 0)
 	for _, item := range result.RawResult {
-		mapitem := item.(map[string]any)
-		if err, ok := mapitem["error"]; ok {
-			r = append(r, err.(string))
+		if item.Error != nil {
+			r = append(r, *item.Error)
 		}
 	}
 	return r
@@ -157,14 +174,94 @@ func (result *pingResult) Errors() []string {
 func (result *pingResult) Timeouts() uint {
 	var n uint = 0
 	for _, item := range result.RawResult {
-		mapitem := item.(map[string]any)
-		if _, ok := mapitem["x"]; ok {
+		if item.Timeout != nil {
 			n++
 		}
 	}
 	return n
 }
 
+// itemOrder returns the kinds of the items in the result, in their order
+func (result *pingResult) itemOrder() []byte {
+	order := make([]byte, 0, len(result.RawResult))
+	for _, item := range result.RawResult {
+		if item.Rtt != nil {
+			order = append(order, 'r')
+		}
+		if item.Error != nil {
+			order = append(order, 'e')
+		}
+		if item.Timeout != nil {
+			order = append(order, 't')
+		}
+	}
+	return order
+}
+
+// api converts a ping result to the API version
+func (ping *PingResult) api() any {
+	iping := pingResult{
+		BaseResult: ping.BaseResult,
+		Minimum:    ping.Minimum,
+		Average:    ping.Average,
+		Maximum:    ping.Maximum,
+		Sent:       ping.Sent,
+		Received:   ping.Received,
+		Duplicates: ping.Duplicates,
+		PacketSize: ping.PacketSize,
+		Protocol:   ping.Protocol,
+		Step:       ping.Step,
+		RawResult:  make([]rawPingReply, 0, len(ping.Replies)+len(ping.Errors)+int(ping.Timeouts)),
+	}
+	if ping.Ttl != 0 {
+		iping.Ttl = &ping.Ttl
+	}
+	replies := make([]rawPingReply, 0, len(ping.Replies))
+	for _, reply := range ping.Replies {
+		item := rawPingReply{Rtt: &reply.Rtt}
+		if reply.Source.IsValid() && (ping.DestinationAddr == nil || reply.Source != *ping.DestinationAddr) {
+			src := reply.Source.String()
+			item.SourceAddr = &src
+		}
+		if reply.Ttl != ping.Ttl {
+			item.Ttl = &reply.Ttl
+		}
+		if reply.Duplicate {
+			item.Duplicate = json.RawMessage("1")
+		}
+		replies = append(replies, item)
+	}
+	timeout := rawPingReply{Timeout: json.RawMessage(`"*"`)}
+
+	// the original order only applies if the items are still the same
+	r, e, t := bytes.Count(ping.order, []byte{'r'}), bytes.Count(ping.order, []byte{'e'}), bytes.Count(ping.order, []byte{'t'})
+	if r == len(ping.Replies) && e == len(ping.Errors) && t == int(ping.Timeouts) {
+		r, e = 0, 0
+		for _, kind := range ping.order {
+			switch kind {
+			case 'r':
+				iping.RawResult = append(iping.RawResult, replies[r])
+				r++
+			case 'e':
+				iping.RawResult = append(iping.RawResult, rawPingReply{Error: &ping.Errors[e]})
+				e++
+			case 't':
+				iping.RawResult = append(iping.RawResult, timeout)
+			}
+		}
+		return &iping
+	}
+
+	iping.RawResult = append(iping.RawResult, replies...)
+	for _, e := range ping.Errors {
+		iping.RawResult = append(iping.RawResult, rawPingReply{Error: &e})
+	}
+	for i := uint(0); i < ping.Timeouts; i++ {
+		iping.RawResult = append(iping.RawResult, timeout)
+	}
+	return &iping
+}
+
 func median(vals []float64) float64 {
 	n := len(vals)
 	slice := vals[:]
@@ -8,8 +8,11 @@ package result
 
 import (
 	"fmt"
+	"math"
+	"net/netip"
 	"reflect"
 	"testing"
+	"time"
 )
 
 // Test if the ping parser does a decent job
@@ -145,3 +148,82 @@ func assertEqual(t *testing.T, val1 interface{}, val2 interface{}, msg string) {
 	}
 	t.Errorf("%s: received %v (type %v), expected %v (type %v)", msg, val1, reflect.TypeOf(val1), val2, reflect.TypeOf(val2))
 }
+
+// Test per-result jitter, loss and duplicate rate
+func TestPingStatistics(t *testing.T) {
+	var ping PingResult
+	err := ping.Parse(`{"fw":5080,"msm_id":1001,"prb_id":11,"timestamp":1700000000,"type":"ping","dst_addr":"192.0.2.1","sent":4,"rcvd":3,"dup":1,"min":10,"avg":12,"max":14,
+		"result":[{"rtt":10},{"rtt":14},{"rtt":14,"dup":1},{"x":"*"},{"rtt":12}]}`)
+	if err != nil {
+		t.Fatalf("Error parsing ping result: %s", err)
+	}
+	// deltas: +4, -2
+	assertEqual(t, ping.Jitter(), 3.0, "jitter")
+	assertEqual(t, ping.LossRatio(), 0.25, "loss ratio")
+	assertEqual(t, ping.DuplicateRate(), 0.25, "duplicate rate")
+
+	var empty PingResult
+	empty.Parse(`{"fw":5080,"type":"ping","sent":0,"rcvd":0,"result":[]}`)
+	assertEqual(t, empty.Jitter(), -1.0, "jitter without replies")
+	assertEqual(t, empty.LossRatio(), -1.0, "loss ratio without packets")
+}
+
+// Test the streaming aggregation of ping results
+func TestPingAggregator(t *testing.T) {
+	results := make(chan AsyncResult)
+	go func() {
+		defer close(results)
+		for i := 0; i < 200; i++ {
+			// probe 11 sees RTTs 1..100, probe 12 sees 101..200 and loses a packet
+			probe, dst := 11, "192.0.2.1"
+			replies := fmt.Sprintf(`"rcvd":1,"result":[{"rtt":%d}]`, i+1)
+			if i >= 100 {
+				probe, dst = 12, "192.0.2.2"
+				if i%10 == 0 {
+					replies = `"rcvd":0,"result":[{"x":"*"}]`
+				}
+			}
+			res, err := Parse(fmt.Sprintf(`{"fw":5080,"msm_id":1001,"prb_id":%d,"timestamp":%d,"type":"ping","dst_addr":"%s","sent":1,%s}`,
+				probe, 1700000000+i*60, dst, replies))
+			results <- AsyncResult{Result: &res, Error: err}
+		}
+		results <- AsyncResult{Error: fmt.Errorf("some error")}
+	}()
+
+	agg := NewPingAggregator(time.Hour)
+	agg.Consume(results)
+
+	assertEqual(t, agg.Errors, uint(1), "errors")
+	assertEqual(t, agg.Total().Results, uint(200), "number of results")
+	assertEqual(t, agg.Total().Minimum, 1.0, "minimum")
+	assertEqual(t, agg.Total().LossRatio(), 0.05, "total loss")
+	assertEqual(t, len(agg.Probes()), 2, "number of probes")
+	assertEqual(t, agg.Probe(11).LossRatio(), 0.0, "probe 11 loss")
+	assertEqual(t, agg.Probe(12).LossRatio(), 0.1, "probe 12 loss")
+	assertEqual(t, agg.Probe(13) == nil, true, "unknown probe")
+	assertEqual(t, agg.Destinations()[1], netip.MustParseAddr("192.0.2.2"), "second destination")
+	assertEqual(t, agg.Destination(netip.MustParseAddr("192.0.2.1")).Results, uint(100), "results for a destination")
+
+	p50 := agg.Probe(11).P50()
+	if math.Abs(p50-50.5)/50.5 > 0.02 {
+		t.Errorf("p50 is off: %f", p50)
+	}
+	p99 := agg.Total().P99()
+	if math.Abs(p99-198)/198 > 0.02 {
+		t.Errorf("p99 is off: %f", p99)
+	}
+
+	buckets := agg.Buckets()
+	// 200 minutes starting at 22:13:20 span 4 hours
+	assertEqual(t, len(buckets), 4, "number of time buckets")
+	assertEqual(t, buckets[0], time.Date(2023, 11, 14, 22, 0, 0, 0, time.UTC), "first bucket")
+	first := agg.Bucket(time.Date(2023, 11, 14, 22, 30, 0, 0, time.UTC))
+	assertEqual(t, first.Results, uint(47), "results in the first bucket")
+
+	merged := NewPingStats()
+	merged.Merge(agg.Probe(11))
+	merged.Merge(agg.Probe(12))
+	assertEqual(t, merged.Sent, agg.Total().Sent, "merged packets")
+	assertEqual(t, merged.P90(), agg.Total().P90(), "merged percentiles")
+	assertEqual(t, merged.Maximum, 200.0, "merged maximum")
+}
@@ -0,0 +1,370 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"math"
+	"net/netip"
+	"slices"
+	"time"
+)
+
+// Jitter returns the standard deviation of the differences between the
+// RTTs of consecutive replies (duplicates are ignored), similar to how
+// RFC 3550 looks at delay variation; -1 if there are less than 2 replies
+func (ping *PingResult) Jitter() float64 {
+	deltas := make([]float64, 0, len(ping.Replies))
+	prev := math.NaN()
+	for _, reply := range ping.Replies {
+		if reply.Duplicate {
+			continue
+		}
+		if !math.IsNaN(prev) {
+			deltas = append(deltas, reply.Rtt-prev)
+		}
+		prev = reply.Rtt
+	}
+	if len(deltas) == 0 {
+		return -1
+	}
+	return stddev(deltas)
+}
+
+// LossRatio returns the ratio of packets that were not answered (0..1);
+// -1 if no packets were sent
+func (ping *PingResult) LossRatio() float64 {
+	if ping.Sent == 0 {
+		return -1
+	}
+	return float64(ping.Sent-min(ping.Received, ping.Sent)) / float64(ping.Sent)
+}
+
+// DuplicateRate returns the number of duplicate replies per packet sent;
+// -1 if no packets were sent
+func (ping *PingResult) DuplicateRate() float64 {
+	if ping.Sent == 0 {
+		return -1
+	}
+	return float64(ping.Duplicates) / float64(ping.Sent)
+}
+
+func stddev(vals []float64) float64 {
+	var sum float64
+	for _, v := range vals {
+		sum += v
+	}
+	mean := sum / float64(len(vals))
+	var sq float64
+	for _, v := range vals {
+		sq += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sq / float64(len(vals)))
+}
+
+// PingStats accumulates statistics over many ping results. It doesn't
+// keep the individual RTTs: percentiles are estimated with a relative
+// error of at most 1%
+type PingStats struct {
+	Results    uint    // number of results added
+	Sent       uint    // total number of packets sent
+	Received   uint    // total number of replies received
+	Duplicates uint    // total number of duplicate replies
+	Minimum    float64 // -1 if N/A
+	Maximum    float64 // -1 if N/A
+	rttSum     float64
+	rttCount   uint
+	jitterSum  float64
+	jitterN    uint
+	sketch     rttSketch
+}
+
+// NewPingStats makes an empty statistics accumulator
+func NewPingStats() *PingStats {
+	return &PingStats{Minimum: -1, Maximum: -1, sketch: newRttSketch()}
+}
+
+// Add adds a ping result to the statistics
+func (stats *PingStats) Add(ping *PingResult) {
+	stats.Results++
+	stats.Sent += ping.Sent
+	stats.Received += ping.Received
+	stats.Duplicates += ping.Duplicates
+	for _, reply := range ping.Replies {
+		if reply.Duplicate {
+			continue
+		}
+		stats.addRtt(reply.Rtt)
+	}
+	if jitter := ping.Jitter(); jitter >= 0 {
+		stats.jitterSum += jitter
+		stats.jitterN++
+	}
+}
+
+func (stats *PingStats) addRtt(rtt float64) {
+	if stats.rttCount == 0 || rtt < stats.Minimum {
+		stats.Minimum = rtt
+	}
+	if stats.rttCount == 0 || rtt > stats.Maximum {
+		stats.Maximum = rtt
+	}
+	stats.rttSum += rtt
+	stats.rttCount++
+	stats.sketch.add(rtt)
+}
+
+// Merge adds all of other's statistics to these
+func (stats *PingStats) Merge(other *PingStats) {
+	stats.Results += other.Results
+	stats.Sent += other.Sent
+	stats.Received += other.Received
+	stats.Duplicates += other.Duplicates
+	if other.rttCount > 0 {
+		if stats.rttCount == 0 || other.Minimum < stats.Minimum {
+			stats.Minimum = other.Minimum
+		}
+		if stats.rttCount == 0 || other.Maximum > stats.Maximum {
+			stats.Maximum = other.Maximum
+		}
+	}
+	stats.rttSum += other.rttSum
+	stats.rttCount += other.rttCount
+	stats.jitterSum += other.jitterSum
+	stats.jitterN += other.jitterN
+	stats.sketch.merge(&other.sketch)
+}
+
+// Average returns the average RTT; -1 if N/A
+func (stats *PingStats) Average() float64 {
+	if stats.rttCount == 0 {
+		return -1
+	}
+	return stats.rttSum / float64(stats.rttCount)
+}
+
+// Percentile returns an estimate of the p-th percentile (0..100) of the
+// RTTs; -1 if N/A
+func (stats *PingStats) Percentile(p float64) float64 {
+	return stats.sketch.quantile(p / 100)
+}
+
+// P50 returns the (estimated) median RTT
+func (stats *PingStats) P50() float64 {
+	return stats.Percentile(50)
+}
+
+// P90 returns the (estimated) 90th percentile of the RTTs
+func (stats *PingStats) P90() float64 {
+	return stats.Percentile(90)
+}
+
+// P99 returns the (estimated) 99th percentile of the RTTs
+func (stats *PingStats) P99() float64 {
+	return stats.Percentile(99)
+}
+
+// LossRatio returns the ratio of packets that were not answered (0..1);
+// -1 if no packets were sent
+func (stats *PingStats) LossRatio() float64 {
+	if stats.Sent == 0 {
+		return -1
+	}
+	return float64(stats.Sent-min(stats.Received, stats.Sent)) / float64(stats.Sent)
+}
+
+// DuplicateRate returns the number of duplicate replies per packet sent;
+// -1 if no packets were sent
+func (stats *PingStats) DuplicateRate() float64 {
+	if stats.Sent == 0 {
+		return -1
+	}
+	return float64(stats.Duplicates) / float64(stats.Sent)
+}
+
+// Jitter returns the average jitter of the results; -1 if N/A
+func (stats *PingStats) Jitter() float64 {
+	if stats.jitterN == 0 {
+		return -1
+	}
+	return stats.jitterSum / float64(stats.jitterN)
+}
+
+// PingAggregator summarises ping results per probe, per destination and
+// per time bucket, in a streaming fashion. It is not safe for concurrent use
+type PingAggregator struct {
+	bucketSize   time.Duration             //
+	total        *PingStats                //
+	probes       map[uint]*PingStats       //
+	destinations map[netip.Addr]*PingStats //
+	buckets      map[time.Time]*PingStats  //
+	Skipped      uint                      // number of non-ping results seen
+	Errors       uint                      // number of errors seen
+}
+
+// NewPingAggregator prepares an aggregator; time buckets are bucketSize
+// long, aligned to the UNIX epoch. Zero bucketSize means one bucket for
+// all results
+func NewPingAggregator(bucketSize time.Duration) *PingAggregator {
+	return &PingAggregator{
+		bucketSize:   bucketSize,
+		total:        NewPingStats(),
+		probes:       make(map[uint]*PingStats),
+		destinations: make(map[netip.Addr]*PingStats),
+		buckets:      make(map[time.Time]*PingStats),
+	}
+}
+
+// Add adds a ping result to the summaries
+func (agg *PingAggregator) Add(ping *PingResult) {
+	agg.total.Add(ping)
+	statsFor(agg.probes, ping.ProbeID).Add(ping)
+	if ping.DestinationAddr != nil {
+		statsFor(agg.destinations, *ping.DestinationAddr).Add(ping)
+	}
+	statsFor(agg.buckets, agg.bucketStart(ping.GetTimeStamp())).Add(ping)
+}
+
+// Consume reads results from a channel until it is closed, and adds the
+// ping results to the summaries. Other results and errors are counted
+func (agg *PingAggregator) Consume(results chan AsyncResult) {
+	for res := range results {
+		if res.Error != nil {
+			agg.Errors++
+			continue
+		}
+		ping, ok := (*res.Result).(*PingResult)
+		if !ok {
+			agg.Skipped++
+			continue
+		}
+		agg.Add(ping)
+	}
+}
+
+func statsFor[K comparable](stats map[K]*PingStats, key K) *PingStats {
+	s, ok := stats[key]
+	if !ok {
+		s = NewPingStats()
+		stats[key] = s
+	}
+	return s
+}
+
+// Total returns the statistics of all results
+func (agg *PingAggregator) Total() *PingStats {
+	return agg.total
+}
+
+// Probes returns the IDs of the probes seen, in increasing order
+func (agg *PingAggregator) Probes() []uint {
+	return sortedKeys(agg.probes, func(a, b uint) int { return int(a) - int(b) })
+}
+
+// Probe returns the statistics of one probe, or nil if it was not seen
+func (agg *PingAggregator) Probe(id uint) *PingStats {
+	return agg.probes[id]
+}
+
+// Destinations returns the destinations seen, in increasing order
+func (agg *PingAggregator) Destinations() []netip.Addr {
+	return sortedKeys(agg.destinations, netip.Addr.Compare)
+}
+
+// Destination returns the statistics of one destination, or nil if it was not seen
+func (agg *PingAggregator) Destination(addr netip.Addr) *PingStats {
+	return agg.destinations[addr]
+}
+
+// Buckets returns the start times of the time buckets, in increasing order
+func (agg *PingAggregator) Buckets() []time.Time {
+	return sortedKeys(agg.buckets, time.Time.Compare)
+}
+
+// Bucket returns the statistics of the time bucket a point in time belongs to,
+// or nil if there were no results in it
+func (agg *PingAggregator) Bucket(at time.Time) *PingStats {
+	return agg.buckets[agg.bucketStart(at)]
+}
+
+// bucketStart returns the start of the time bucket a point in time belongs to
+func (agg *PingAggregator) bucketStart(at time.Time) time.Time {
+	if agg.bucketSize <= 0 {
+		return time.Unix(0, 0).UTC()
+	}
+	ns := at.UnixNano()
+	ns -= ns % int64(agg.bucketSize)
+	return time.Unix(0, ns).UTC()
+}
+
+func sortedKeys[K comparable, V any](m map[K]V, cmp func(a, b K) int) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, cmp)
+	return keys
+}
+
+// rttSketch is a streaming quantile estimator with bounded relative
+// error: values are counted in logarithmically sized buckets
+type rttSketch struct {
+	counts map[int]uint // bucket index -> count
+	zeros  uint         // values that are zero or negative
+	total  uint         //
+}
+
+const sketchAccuracy = 0.01
+
+var (
+	sketchGamma    = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+func newRttSketch() rttSketch {
+	return rttSketch{counts: make(map[int]uint)}
+}
+
+func (sketch *rttSketch) add(val float64) {
+	sketch.total++
+	if val <= 0 {
+		sketch.zeros++
+		return
+	}
+	sketch.counts[int(math.Ceil(math.Log(val)/sketchLogGamma))]++
+}
+
+func (sketch *rttSketch) merge(other *rttSketch) {
+	sketch.total += other.total
+	sketch.zeros += other.zeros
+	for idx, n := range other.counts {
+		sketch.counts[idx] += n
+	}
+}
+
+func (sketch *rttSketch) quantile(q float64) float64 {
+	if sketch.total == 0 {
+		return -1
+	}
+	q = math.Max(0, math.Min(1, q))
+	rank := uint(q * float64(sketch.total-1))
+	if rank < sketch.zeros {
+		return 0
+	}
+	seen := sketch.zeros
+	indexes := make([]int, 0, len(sketch.counts))
+	for idx := range sketch.counts {
+		indexes = append(indexes, idx)
+	}
+	slices.Sort(indexes)
+	for _, idx := range indexes {
+		seen += sketch.counts[idx]
+		if seen > rank {
+			return 2 * math.Pow(sketchGamma, float64(idx)) / (sketchGamma + 1)
+		}
+	}
+	return 2 * math.Pow(sketchGamma, float64(indexes[len(indexes)-1])) / (sketchGamma + 1)
+}
@@ -7,9 +7,12 @@
 package result
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -27,53 +30,147 @@ type AsyncResult struct {
 }
 
 func Parse(from string) (Result, error) {
-	var res Result = &BaseResult{}
-	err := res.Parse(from)
+	base := &BaseResult{}
+	err := base.Parse(from)
 	if err != nil {
-		return nil, err
+		return nil, parseError(base, base.Type, err)
+	}
+	if base.GetFirmwareVersion() <= 1 {
+		return nil, parseErrorf(base, base.Type, "firmware version 1 and below results are not supported")
 	}
-	if res.GetFirmwareVersion() <= 1 {
-		return nil, fmt.Errorf("firmware version 1 and below results are not supported")
+	if base.Type == "" {
+		return nil, parseErrorf(base, base.Type, "result type is missing")
 	}
-	return ParseWithTypeHint(from, res.TypeName())
+	return parseAs(from, base.Type)
 }
 
+// ParseWithTypeHint parses a result, using the parser for the given type
+// if it's known already. Results with a different type than the hint are
+// parsed according to their own type. Types without a parser are returned
+// as GenericResult
 func ParseWithTypeHint(from string, typehint string) (Result, error) {
-	var res Result
-	switch typehint {
-	case "":
+	if _, ok := parserFor(typehint); !ok {
 		return Parse(from)
-	case "ping":
-		pingres := PingResult{}
-		res = &pingres
-	case "traceroute":
-		traceres := TracerouteResult{}
-		res = &traceres
-	case "dns":
-		dnsres := DnsResult{}
-		res = &dnsres
-	case "ntp":
-		ntpres := NtpResult{}
-		res = &ntpres
-	case "sslcert":
-		certres := CertResult{}
-		res = &certres
-	case "http":
-		httpres := HttpResult{}
-		res = &httpres
-	case "uptime":
-		uptimeres := UptimeResult{}
-		res = &uptimeres
-	case "connection":
-		connres := ConnectionResult{}
-		res = &connres
-	default:
-		return nil, fmt.Errorf("unknown/unsupported result type %s", typehint)
 	}
+	return parseAs(from, typehint)
+}
+
+// parseAs parses a result with the parser for the given type
+func parseAs(from string, typehint string) (Result, error) {
+	factory, ok := parserFor(typehint)
+	if !ok {
+		factory = func() Result { return &GenericResult{} }
+	}
+	res := factory()
 	err := res.Parse(from)
+	if err != nil {
+		var base BaseResult
+		json.Unmarshal([]byte(from), &base)
+		if base.Type != "" && base.Type != typehint {
+			// the hint was wrong, e.g. in files with mixed result types
+			return Parse(from)
+		}
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			// add some context if the parser didn't
+			err = parseError(&base, typehint, err)
+		}
+	}
 	return res, err
 }
 
+// ResultFactory makes a new, empty result that can Parse() a result
+type ResultFactory func() Result
+
+var (
+	parsersMutex sync.RWMutex
+	parsers      = map[string]ResultFactory{
+		"ping":       func() Result { return &PingResult{} },
+		"traceroute": func() Result { return &TracerouteResult{} },
+		"dns":        func() Result { return &DnsResult{} },
+		"ntp":        func() Result { return &NtpResult{} },
+		"sslcert":    func() Result { return &CertResult{} },
+		"http":       func() Result { return &HttpResult{} },
+		"uptime":     func() Result { return &UptimeResult{} },
+		"connection": func() Result { return &ConnectionResult{} },
+		"wifi":       func() Result { return &WifiResult{} },
+	}
+)
+
+// RegisterParser makes the parser functions use factory to make results
+// of the given type. This allows parsing result types that this package
+// doesn't know about, or replacing the built-in parsers. The result's
+// Parse() should return a ParseError (see NewParseError) on failure
+func RegisterParser(typename string, factory ResultFactory) error {
+	if typename == "" {
+		return fmt.Errorf("result type name is missing")
+	}
+	if factory == nil {
+		return fmt.Errorf("result factory for type %s is missing", typename)
+	}
+	parsersMutex.Lock()
+	defer parsersMutex.Unlock()
+	parsers[typename] = factory
+	return nil
+}
+
+// RegisteredTypes returns the result types that have a parser, sorted
+func RegisteredTypes() []string {
+	parsersMutex.RLock()
+	defer parsersMutex.RUnlock()
+	return sortedKeys(parsers, strings.Compare)
+}
+
+// parserFor returns the factory for a result type, if there's one
+func parserFor(typename string) (ResultFactory, bool) {
+	parsersMutex.RLock()
+	defer parsersMutex.RUnlock()
+	factory, ok := parsers[typename]
+	return factory, ok
+}
+
+// ParseError is returned if a result could not be parsed; it carries
+// the measurement and probe IDs, as far as they could be determined
+type ParseError struct {
+	Type          string // result type
+	MeasurementID uint   //
+	ProbeID       uint   //
+	Err           error  // what went wrong
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("error parsing %s result (msm %d, probe %d): %v",
+		e.Type, e.MeasurementID, e.ProbeID, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// NewParseError makes a ParseError for a (partially) parsed result; this
+// is useful for parsers added with RegisterParser
+func NewParseError(base *BaseResult, typ string, err error) error {
+	return parseError(base, typ, err)
+}
+
+// parseError makes a ParseError for a (partially) parsed result
+func parseError(base *BaseResult, typ string, err error) error {
+	return &ParseError{typ, base.MeasurementID, base.ProbeID, err}
+}
+
+// parseErrorf makes a ParseError with a formatted message
+func parseErrorf(base *BaseResult, typ string, format string, args ...any) error {
+	return parseError(base, typ, fmt.Errorf(format, args...))
+}
+
+// valueOr returns the value behind a pointer, or a default if it's nil
+func valueOr[T any](value *T, def T) T {
+	if value == nil {
+		return def
+	}
+	return *value
+}
+
 // StoreDelay calculates the difference (in seconds) between taking the
 // measurement and storing it, i.e. how long it took for the result to be
 // available. Be aware that The probe's clock may be inaccurate, so this
@@ -107,6 +204,15 @@ func (ut *uniTime) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// MarshalJSON encodes the time as UNIX epoch, or null if it's not set
+func (ut uniTime) MarshalJSON() ([]byte, error) {
+	t := time.Time(ut)
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.FormatInt(t.Unix(), 10)), nil
+}
+
 // default output format for uniTime type is ISO8601
 func (ut uniTime) String() string {
 	return time.Time(ut).UTC().Format("2006-01-02T15:04:05Z")
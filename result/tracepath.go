@@ -0,0 +1,277 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"math"
+	"net/netip"
+	"slices"
+	"sort"
+	"time"
+)
+
+// TracePathHop is an IP level summary of one traceroute hop
+type TracePathHop struct {
+	HopNumber    uint         //
+	Address      netip.Addr   // the responder that answered most often; invalid if none answered
+	Addresses    []netip.Addr // all responders, most frequent first
+	LoadBalanced bool         // more than one responder answered
+	Replies      uint         // number of replies with RTT
+	Timeouts     uint         // number of timed out packets
+	MinRtt       float64      // -1 if there were no replies
+	MedianRtt    float64      // -1 if there were no replies
+	ErrorCode    string       // ICMP error reported by the responder (N/H/A/P/p/h/(int)), if any
+}
+
+// Responded tells if anyone answered for this hop
+func (hop *TracePathHop) Responded() bool {
+	return hop.Address.IsValid()
+}
+
+// Summary makes an IP level summary of the hop: responders (ordered by
+// number of answers, ties broken by address) and RTT statistics
+func (hop *TracerouteHop) Summary() TracePathHop {
+	summary := TracePathHop{
+		HopNumber: hop.HopNumber,
+		Addresses: make([]netip.Addr, 0),
+		MinRtt:    -1,
+		MedianRtt: -1,
+	}
+
+	counts := make(map[netip.Addr]uint)
+	rtts := make([]float64, 0)
+	errors := make(map[string]uint)
+	for _, resp := range hop.Responses {
+		if resp.Timeout {
+			summary.Timeouts++
+			continue
+		}
+		if !resp.From.IsValid() || resp.Late != nil {
+			continue
+		}
+		if counts[resp.From] == 0 {
+			summary.Addresses = append(summary.Addresses, resp.From)
+		}
+		counts[resp.From]++
+		if resp.ErrorCode != "" {
+			errors[resp.ErrorCode]++
+		}
+		rtts = append(rtts, resp.Rtt)
+	}
+
+	sort.SliceStable(summary.Addresses, func(i, j int) bool {
+		a, b := summary.Addresses[i], summary.Addresses[j]
+		if counts[a] != counts[b] {
+			return counts[a] > counts[b]
+		}
+		return a.Less(b)
+	})
+	if len(summary.Addresses) > 0 {
+		summary.Address = summary.Addresses[0]
+	}
+	summary.LoadBalanced = len(summary.Addresses) > 1
+
+	summary.Replies = uint(len(rtts))
+	if len(rtts) > 0 {
+		summary.MinRtt = math.Inf(1)
+		for _, rtt := range rtts {
+			summary.MinRtt = math.Min(summary.MinRtt, rtt)
+		}
+		summary.MedianRtt = median(rtts)
+	}
+
+	var most uint
+	for code, n := range errors {
+		if n > most || (n == most && code < summary.ErrorCode) {
+			summary.ErrorCode, most = code, n
+		}
+	}
+
+	return summary
+}
+
+// Path summarises all hops of the traceroute
+func (trace *TracerouteResult) Path() []TracePathHop {
+	path := make([]TracePathHop, 0, len(trace.Hops))
+	for i := range trace.Hops {
+		path = append(path, trace.Hops[i].Summary())
+	}
+	return path
+}
+
+// IPPath returns the most frequent responder for each hop; unresponsive
+// hops are represented by invalid (zero) addresses
+func (trace *TracerouteResult) IPPath() []netip.Addr {
+	ips := make([]netip.Addr, 0, len(trace.Hops))
+	for _, hop := range trace.Path() {
+		ips = append(ips, hop.Address)
+	}
+	return ips
+}
+
+// LastRespondingHop returns the last hop where someone answered; the
+// second return value is false if there was no such hop
+func (trace *TracerouteResult) LastRespondingHop() (TracePathHop, bool) {
+	path := trace.Path()
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i].Responded() {
+			return path[i], true
+		}
+	}
+	return TracePathHop{}, false
+}
+
+// UnreachableError returns the ICMP error ("N" network, "H" host, "P"
+// protocol unreachable, "A" administratively prohibited, ...) that ended
+// the traceroute; "" if it was not ended by such an error. Port unreachable
+// ("p") is the normal way for UDP traceroutes to end, so it doesn't count
+func (trace *TracerouteResult) UnreachableError() string {
+	last, ok := trace.LastRespondingHop()
+	if !ok || last.ErrorCode == "p" {
+		return ""
+	}
+	return last.ErrorCode
+}
+
+// TraceLoop is an address that shows up at multiple, non-adjacent hops
+type TraceLoop struct {
+	Address  netip.Addr //
+	FirstHop uint       // the first hop number it appeared at
+	LastHop  uint       // the last hop number it appeared at
+}
+
+// Loops lists routing loops in the path, based on the most frequent
+// responder for each hop. The same address on adjacent hops is not
+// considered to be a loop, as some routers forward packets with a TTL of 0
+func (trace *TracerouteResult) Loops() []TraceLoop {
+	loops := make([]TraceLoop, 0)
+	seen := make(map[netip.Addr]int) // address -> index in loops, or -1
+	first := make(map[netip.Addr]uint)
+	var prev netip.Addr
+	for _, hop := range trace.Path() {
+		addr := hop.Address
+		if !addr.IsValid() {
+			prev = addr
+			continue
+		}
+		idx, ok := seen[addr]
+		switch {
+		case !ok:
+			seen[addr] = -1
+			first[addr] = hop.HopNumber
+		case addr == prev:
+			// adjacent repetition, not a loop
+		case idx < 0:
+			seen[addr] = len(loops)
+			loops = append(loops, TraceLoop{addr, first[addr], hop.HopNumber})
+		default:
+			loops[idx].LastHop = hop.HopNumber
+		}
+		prev = addr
+	}
+	return loops
+}
+
+// HasLoop tells if there's a routing loop in the path
+func (trace *TracerouteResult) HasLoop() bool {
+	return len(trace.Loops()) > 0
+}
+
+// TracePathChange is emitted if a probe sees a different path than before
+type TracePathChange struct {
+	MeasurementID   uint         //
+	ProbeID         uint         //
+	Before          time.Time    // time of the previous result
+	After           time.Time    // time of the result with the new path
+	FirstDifference uint         // the first hop number that differs
+	OldPath         []netip.Addr // the previous path, see IPPath()
+	NewPath         []netip.Addr // the new path, see IPPath()
+}
+
+// TracePathComparer detects path changes in consecutive results of the
+// same measurement from the same probe. Results should be added in
+// chronological order per probe
+type TracePathComparer struct {
+	last    map[tracePathKey]*TracerouteResult // previous result per measurement and probe
+	changes []TracePathChange                  // all changes seen so far
+}
+
+type tracePathKey struct {
+	msm   uint
+	probe uint
+}
+
+// NewTracePathComparer prepares a new comparer
+func NewTracePathComparer() *TracePathComparer {
+	return &TracePathComparer{
+		last:    make(map[tracePathKey]*TracerouteResult),
+		changes: make([]TracePathChange, 0),
+	}
+}
+
+// Add adds a result to the comparison; it returns the path change relative
+// to the previous result of the same probe, or nil if there was none
+func (tc *TracePathComparer) Add(trace *TracerouteResult) *TracePathChange {
+	key := tracePathKey{trace.MeasurementID, trace.ProbeID}
+	prev, ok := tc.last[key]
+	tc.last[key] = trace
+	if !ok {
+		return nil
+	}
+
+	hop, changed := firstPathDifference(prev.Path(), trace.Path())
+	if !changed {
+		return nil
+	}
+	change := TracePathChange{
+		MeasurementID:   trace.MeasurementID,
+		ProbeID:         trace.ProbeID,
+		Before:          prev.GetTimeStamp(),
+		After:           trace.GetTimeStamp(),
+		FirstDifference: hop,
+		OldPath:         prev.IPPath(),
+		NewPath:         trace.IPPath(),
+	}
+	tc.changes = append(tc.changes, change)
+	return &change
+}
+
+// Changes returns all path changes seen so far
+func (tc *TracePathComparer) Changes() []TracePathChange {
+	return tc.changes
+}
+
+// firstPathDifference compares two paths. Hops match if any of their
+// responders match (to tolerate load balancing), or if either of them is
+// unresponsive. Trailing unresponsive hops are ignored
+func firstPathDifference(old, new []TracePathHop) (uint, bool) {
+	old, new = trimUnresponsive(old), trimUnresponsive(new)
+	for i := 0; i < max(len(old), len(new)); i++ {
+		if i >= len(old) {
+			return new[i].HopNumber, true
+		}
+		if i >= len(new) {
+			return old[i].HopNumber, true
+		}
+		if !old[i].Responded() || !new[i].Responded() {
+			continue
+		}
+		if !slices.ContainsFunc(old[i].Addresses, func(a netip.Addr) bool {
+			return slices.Contains(new[i].Addresses, a)
+		}) {
+			return new[i].HopNumber, true
+		}
+	}
+	return 0, false
+}
+
+func trimUnresponsive(path []TracePathHop) []TracePathHop {
+	for len(path) > 0 && !path[len(path)-1].Responded() {
+		path = path[:len(path)-1]
+	}
+	return path
+}
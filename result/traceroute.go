@@ -10,6 +10,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/netip"
+	"strconv"
 )
 
 type TracerouteResult struct {
@@ -54,10 +55,15 @@ type IcmpExtension struct {
 	Objects []IcmpExtensionObject //
 }
 
+// IcmpExtensionObject is one object in an ICMP extension structure (RFC 4884)
+// MplsObject is filled in for MPLS label stack objects (RFC 4950); for all
+// other objects (e.g. interface information, RFC 5837) Data holds whatever
+// the probe reported about it
 type IcmpExtensionObject struct {
-	Class      uint         //
-	Type       uint         //
-	MplsObject []MplsObject //
+	Class      uint            //
+	Type       uint            // "C-Type" in the RFCs
+	MplsObject []MplsObject    // label stack entries, top of the stack first
+	Data       json.RawMessage // the object as reported by the API
 }
 
 type MplsObject struct {
@@ -75,10 +81,10 @@ func (trace *TracerouteResult) Parse(from string) (err error) {
 	var itrace tracerouteResult
 	err = json.Unmarshal([]byte(from), &itrace)
 	if err != nil {
-		return err
+		return parseError(&itrace.BaseResult, "traceroute", err)
 	}
 	if itrace.Type != "traceroute" {
-		return fmt.Errorf("this is not a traceroute result (type=%s)", itrace.Type)
+		return parseErrorf(&itrace.BaseResult, "traceroute", "this is not a traceroute result (type=%s)", itrace.Type)
 	}
 	trace.BaseResult = itrace.BaseResult
 	trace.Protocol = itrace.Protocol
@@ -97,6 +103,11 @@ func (trace *TracerouteResult) Parse(from string) (err error) {
 			continue
 		}
 		hop.Responses = make([]TraceRouteHopData, 0)
+		if ihop.HopData == nil {
+			// neither an error nor data; nothing more to do
+			trace.Hops = append(trace.Hops, hop)
+			continue
+		}
 		for _, ihopdata := range *ihop.HopData {
 			hopdata := TraceRouteHopData{}
 			if ihopdata.Timeout != nil {
@@ -113,13 +124,19 @@ func (trace *TracerouteResult) Parse(from string) (err error) {
 				hopdata.ErrorCode = fmt.Sprint(*ihopdata.ErrorCode)
 			}
 			hopdata.From = ihopdata.From
-			hopdata.Size = *ihopdata.Size
-			hopdata.Ttl = *ihopdata.Ttl
+			if ihopdata.Size != nil {
+				hopdata.Size = *ihopdata.Size
+			}
+			if ihopdata.Ttl != nil {
+				hopdata.Ttl = *ihopdata.Ttl
+			}
 			if ihopdata.Late != nil {
 				hopdata.Late = ihopdata.Late
 				continue // no other data is it was a LATE packet
 			}
-			hopdata.Rtt = *ihopdata.Rtt
+			if ihopdata.Rtt != nil {
+				hopdata.Rtt = *ihopdata.Rtt
+			}
 			if ihopdata.ITtl != nil {
 				hopdata.ITtl = ihopdata.ITtl
 			}
@@ -142,35 +159,8 @@ func (trace *TracerouteResult) Parse(from string) (err error) {
 				hopdata.HopByHopOptSize = ihopdata.HopByHopOptSize
 			}
 			hopdata.IcmpExtensions = make([]IcmpExtension, 0)
-			// process one extension - change if there can be more
-			if ihopdata.IcmpExtension != nil {
-				iext := ihopdata.IcmpExtension
-				ext := IcmpExtension{
-					iext.Version,
-					iext.Rfc4884,
-					make([]IcmpExtensionObject, 0),
-				}
-				hopdata.IcmpExtensions = append(hopdata.IcmpExtensions, ext)
-				for _, iextobj := range iext.Objects {
-					extobj := IcmpExtensionObject{
-						iextobj.Class,
-						iextobj.Type,
-						make([]MplsObject, 0),
-					}
-					if iextobj.MplsObject != nil {
-						for _, implsobj := range *iextobj.MplsObject {
-							extobj.MplsObject = append(extobj.MplsObject,
-								MplsObject{
-									implsobj.Experimental,
-									implsobj.Label,
-									implsobj.BottomOfStack,
-									implsobj.Ttl,
-								},
-							)
-						}
-					}
-					ext.Objects = append(ext.Objects, extobj)
-				}
+			for _, iext := range ihopdata.IcmpExtensions {
+				hopdata.IcmpExtensions = append(hopdata.IcmpExtensions, iext.extension())
 			}
 			hop.Responses = append(hop.Responses, hopdata)
 		}
@@ -180,8 +170,13 @@ func (trace *TracerouteResult) Parse(from string) (err error) {
 	return nil
 }
 
+// MarshalJSON encodes the result in the format of the API
+func (trace *TracerouteResult) MarshalJSON() ([]byte, error) {
+	return marshalAtlas(trace.api(), &trace.BaseResult)
+}
+
 func (trace *TracerouteResult) DestinationReached() bool {
-	if len(trace.Hops) == 0 {
+	if len(trace.Hops) == 0 || trace.DestinationAddr == nil {
 		return false
 	}
 	for _, ans := range trace.Hops[len(trace.Hops)-1].Responses {
@@ -216,6 +211,14 @@ type rawTraceHop struct {
 
 type errorCode string
 
+// MarshalJSON encodes numeric error codes as numbers, others as strings
+func (e errorCode) MarshalJSON() ([]byte, error) {
+	if n, err := strconv.Atoi(string(e)); err == nil {
+		return json.Marshal(n)
+	}
+	return json.Marshal(string(e))
+}
+
 func (e *errorCode) UnmarshalJSON(b []byte) error {
 	var val any
 	if err := json.Unmarshal(b, &val); err != nil {
@@ -251,7 +254,38 @@ type rawTraceHopData struct {
 	Flags            *string           `json:"flags"`      //
 	DestOptSize      *uint             `json:"dstoptsize"` //
 	HopByHopOptSize  *uint             `json:"hbhoptsize"` //
-	IcmpExtension    *rawIcmpExtension `json:"icmpext"`    //
+	IcmpExtensions   rawIcmpExtensions `json:"icmpext"`    //
+}
+
+// the API reports one extension structure per reply, but be prepared
+// for a list of them as well
+type rawIcmpExtensions []rawIcmpExtension
+
+func (exts *rawIcmpExtensions) UnmarshalJSON(b []byte) error {
+	var list []rawIcmpExtension
+	if err := json.Unmarshal(b, &list); err == nil {
+		*exts = list
+		return nil
+	}
+	var one *rawIcmpExtension
+	if err := json.Unmarshal(b, &one); err != nil {
+		return err
+	}
+	if one != nil {
+		*exts = rawIcmpExtensions{*one}
+	}
+	return nil
+}
+
+// MarshalJSON encodes one extension structure as an object, like the API
+func (exts rawIcmpExtensions) MarshalJSON() ([]byte, error) {
+	switch len(exts) {
+	case 0:
+		return []byte("null"), nil
+	case 1:
+		return json.Marshal(exts[0])
+	}
+	return json.Marshal([]rawIcmpExtension(exts))
 }
 
 type rawIcmpExtension struct {
@@ -264,6 +298,159 @@ type rawIcmpExtensionObject struct {
 	Class      uint             `json:"class"` //
 	Type       uint             `json:"type"`  //
 	MplsObject *[]rawMplsObject `json:"mpls"`  //
+	Data       json.RawMessage  `json:"-"`     // the whole object
+}
+
+// MarshalJSON encodes an object with whatever else the probe reported
+// about it (see Data)
+func (obj rawIcmpExtensionObject) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]json.RawMessage)
+	if len(obj.Data) > 0 {
+		if err := json.Unmarshal(obj.Data, &fields); err != nil {
+			return nil, err
+		}
+	}
+	fields["class"], _ = json.Marshal(obj.Class)
+	fields["type"], _ = json.Marshal(obj.Type)
+	delete(fields, "mpls")
+	if obj.MplsObject != nil && len(*obj.MplsObject) > 0 {
+		mpls, err := json.Marshal(obj.MplsObject)
+		if err != nil {
+			return nil, err
+		}
+		fields["mpls"] = mpls
+	}
+	return json.Marshal(fields)
+}
+
+func (obj *rawIcmpExtensionObject) UnmarshalJSON(b []byte) error {
+	type plain rawIcmpExtensionObject
+	if err := json.Unmarshal(b, (*plain)(obj)); err != nil {
+		return err
+	}
+	obj.Data = append(json.RawMessage(nil), b...)
+	return nil
+}
+
+func (iext *rawIcmpExtension) extension() IcmpExtension {
+	ext := IcmpExtension{
+		iext.Version,
+		iext.Rfc4884,
+		make([]IcmpExtensionObject, 0, len(iext.Objects)),
+	}
+	for _, iextobj := range iext.Objects {
+		extobj := IcmpExtensionObject{
+			iextobj.Class,
+			iextobj.Type,
+			make([]MplsObject, 0),
+			iextobj.Data,
+		}
+		if iextobj.MplsObject != nil {
+			for _, implsobj := range *iextobj.MplsObject {
+				extobj.MplsObject = append(extobj.MplsObject,
+					MplsObject{
+						implsobj.Experimental,
+						implsobj.Label,
+						implsobj.BottomOfStack,
+						implsobj.Ttl,
+					},
+				)
+			}
+		}
+		ext.Objects = append(ext.Objects, extobj)
+	}
+	return ext
+}
+
+// api converts an ICMP extension to the API version
+func (ext *IcmpExtension) api() rawIcmpExtension {
+	iext := rawIcmpExtension{
+		ext.Version,
+		ext.Rfc4884,
+		make([]rawIcmpExtensionObject, 0, len(ext.Objects)),
+	}
+	for _, extobj := range ext.Objects {
+		iextobj := rawIcmpExtensionObject{
+			Class: extobj.Class,
+			Type:  extobj.Type,
+			Data:  extobj.Data,
+		}
+		if len(extobj.MplsObject) > 0 {
+			mpls := make([]rawMplsObject, 0, len(extobj.MplsObject))
+			for _, mplsobj := range extobj.MplsObject {
+				mpls = append(mpls, rawMplsObject{
+					mplsobj.Label,
+					mplsobj.BottomOfStack,
+					mplsobj.Ttl,
+					mplsobj.Experimental,
+				})
+			}
+			iextobj.MplsObject = &mpls
+		}
+		iext.Objects = append(iext.Objects, iextobj)
+	}
+	return iext
+}
+
+// api converts a traceroute result to the API version
+func (trace *TracerouteResult) api() any {
+	itrace := tracerouteResult{
+		BaseResult:    trace.BaseResult,
+		EndTime:       trace.EndTime,
+		ParisID:       trace.ParisID,
+		Protocol:      trace.Protocol,
+		PacketSize:    trace.PacketSize,
+		TypeOfService: trace.TypeOfService,
+		RawResult:     make([]rawTraceHop, 0, len(trace.Hops)),
+	}
+	for _, hop := range trace.Hops {
+		ihop := rawTraceHop{HopNumber: hop.HopNumber, SendError: hop.SendError}
+		if hop.SendError == nil {
+			hopdata := make([]rawTraceHopData, 0, len(hop.Responses))
+			for _, resp := range hop.Responses {
+				hopdata = append(hopdata, resp.api())
+			}
+			ihop.HopData = &hopdata
+		}
+		itrace.RawResult = append(itrace.RawResult, ihop)
+	}
+	return &itrace
+}
+
+// api converts one hop response to the API version
+func (hopdata *TraceRouteHopData) api() rawTraceHopData {
+	switch {
+	case hopdata.Timeout:
+		timeout := "*"
+		return rawTraceHopData{Timeout: &timeout}
+	case hopdata.Error != nil:
+		return rawTraceHopData{Error: hopdata.Error}
+	}
+	ihopdata := rawTraceHopData{
+		From: hopdata.From,
+		Size: &hopdata.Size,
+		Ttl:  &hopdata.Ttl,
+	}
+	if hopdata.ErrorCode != "" {
+		code := errorCode(hopdata.ErrorCode)
+		ihopdata.ErrorCode = &code
+	}
+	if hopdata.Late != nil {
+		ihopdata.Late = hopdata.Late
+		return ihopdata
+	}
+	ihopdata.Rtt = &hopdata.Rtt
+	ihopdata.ITtl = hopdata.ITtl
+	ihopdata.ITypeOfService = hopdata.ITypeOfService
+	ihopdata.ErrorDestination = hopdata.ErrorDestination
+	ihopdata.Mtu = hopdata.Mtu
+	ihopdata.Flags = hopdata.Flags
+	ihopdata.DestOptSize = hopdata.DestOptSize
+	ihopdata.HopByHopOptSize = hopdata.HopByHopOptSize
+	for _, ext := range hopdata.IcmpExtensions {
+		ihopdata.IcmpExtensions = append(ihopdata.IcmpExtensions, ext.api())
+	}
+	return ihopdata
 }
 
 type rawMplsObject struct {
@@ -0,0 +1,155 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// Test if ICMP extensions come through completely
+func TestTracerouteIcmpExtensions(t *testing.T) {
+	var trace TracerouteResult
+	err := trace.Parse(`
+{
+"fw":5080,
+"msm_id":5001,
+"prb_id":11,
+"timestamp":1700000000,
+"type":"traceroute",
+"af":4,
+"dst_addr":"192.0.2.1",
+"proto":"ICMP",
+"result":[
+	{"hop":1,"result":[{"from":"10.0.0.1","ttl":64,"size":76,"rtt":1.2}]},
+	{"hop":2,"result":[
+		{"from":"203.0.113.9","ttl":253,"size":140,"rtt":9.8,"icmpext":{"version":2,"rfc4884":1,"obj":[
+			{"class":1,"type":1,"mpls":[{"exp":0,"label":24001,"s":0,"ttl":1},{"exp":0,"label":16005,"s":1,"ttl":1}]},
+			{"class":2,"type":158}
+		]}},
+		{"x":"*"}
+	]},
+	{"hop":3,"result":[{"from":"203.0.113.13","ttl":252,"size":76,"rtt":10.1,"ittl":2}]},
+	{"hop":4,"result":[{"from":"203.0.113.17","ttl":251,"size":76,"rtt":10.3}]},
+	{"hop":5,"result":[{"from":"203.0.113.21","ttl":250,"size":140,"rtt":14.0,"icmpext":{"version":2,"rfc4884":0,"obj":[
+		{"class":1,"type":1,"mpls":[{"exp":0,"label":299792,"s":1,"ttl":252}]}
+	]}}]},
+	{"hop":6,"result":[{"from":"192.0.2.1","ttl":60,"size":48,"rtt":15.2}]}
+]
+}
+`)
+	if err != nil {
+		t.Fatalf("Error parsing traceroute result: %s", err)
+	}
+
+	ext := trace.Hops[1].Responses[0].IcmpExtensions
+	assertEqual(t, len(ext), 1, "number of extensions")
+	assertEqual(t, ext[0].Version, uint(2), "extension version")
+	assertEqual(t, len(ext[0].Objects), 2, "number of extension objects")
+	assertEqual(t, ext[0].Objects[0].IsMpls(), true, "MPLS object")
+	assertEqual(t, ext[0].Objects[1].IsInterfaceInfo(), true, "interface info object")
+	assertEqual(t, ext[0].Objects[1].InterfaceRole(), "outgoing", "interface role")
+	assertEqual(t, string(ext[0].Objects[1].Data), `{"class":2,"type":158}`, "interface info data")
+
+	stack := trace.Hops[1].MplsLabelStack()
+	assertEqual(t, len(stack), 2, "label stack size")
+	assertEqual(t, stack[0].Label, uint(24001), "top label")
+	assertEqual(t, stack[1].BottomOfStack, uint(1), "bottom of stack")
+	assertEqual(t, trace.Hops[0].IsMpls(), false, "hop 1 is not MPLS")
+	assertEqual(t, trace.Hops[1].IsMpls(), true, "hop 2 is MPLS")
+	assertEqual(t, trace.Hops[2].QuotedTtl(), uint(2), "hop 3 quoted TTL")
+	hidden, ok := trace.Hops[4].HiddenHops()
+	assertEqual(t, hidden, uint(3), "hop 5 hidden hops")
+	assertEqual(t, ok, true, "hop 5 has an estimate")
+	_, ok = trace.Hops[2].HiddenHops()
+	assertEqual(t, ok, false, "no estimate without labels")
+	mpls := []MplsObject{{Label: 16, BottomOfStack: 1, Ttl: 62}}
+	hopdata := TraceRouteHopData{IcmpExtensions: []IcmpExtension{{Objects: []IcmpExtensionObject{{Class: 1, Type: 1, MplsObject: mpls}}}}}
+	hidden, ok = hopdata.HiddenHops()
+	assertEqual(t, hidden, uint(2), "hidden hops with an initial TTL of 64")
+	assertEqual(t, ok, true, "estimate with an initial TTL of 64")
+
+	tunnels := trace.MplsTunnels()
+	assertEqual(t, len(tunnels), 2, "number of tunnels")
+	assertEqual(t, tunnels[0].FirstHop, uint(2), "first tunnel start")
+	assertEqual(t, tunnels[0].LastHop, uint(3), "first tunnel end")
+	assertEqual(t, tunnels[0].Explicit, true, "first tunnel is explicit")
+	assertEqual(t, len(tunnels[0].LabelStacks[1]), 0, "no labels on implicit hop")
+	assertEqual(t, tunnels[1].FirstHop, uint(5), "second tunnel start")
+	assertEqual(t, tunnels[1].HiddenHops, uint(3), "second tunnel hidden hops")
+}
+
+// Test path summaries, loop detection and path change detection
+func TestTraceroutePath(t *testing.T) {
+	parse := func(ts int, hops string) *TracerouteResult {
+		var trace TracerouteResult
+		err := trace.Parse(fmt.Sprintf(`{"fw":5080,"msm_id":5001,"prb_id":11,"timestamp":%d,"type":"traceroute","af":4,"dst_addr":"192.0.2.1","result":[%s]}`, ts, hops))
+		if err != nil {
+			t.Fatalf("Error parsing traceroute result: %s", err)
+		}
+		return &trace
+	}
+
+	trace := parse(1700000000, `
+		{"hop":1,"result":[{"from":"10.0.0.1","rtt":1.2},{"from":"10.0.0.1","rtt":1.1},{"from":"10.0.0.1","rtt":1.6}]},
+		{"hop":2,"result":[{"from":"203.0.113.5","rtt":5.4},{"from":"203.0.113.1","rtt":5.1},{"from":"203.0.113.1","rtt":5.0}]},
+		{"hop":3,"result":[{"x":"*"},{"x":"*"},{"x":"*"}]},
+		{"hop":4,"result":[{"from":"198.18.0.1","rtt":12.0,"err":"H"},{"from":"198.18.0.1","rtt":12.1,"err":"H"},{"x":"*"}]}`)
+
+	path := trace.Path()
+	assertEqual(t, len(path), 4, "path length")
+	assertEqual(t, path[0].MinRtt, 1.1, "hop 1 min RTT")
+	assertEqual(t, path[0].MedianRtt, 1.2, "hop 1 median RTT")
+	assertEqual(t, path[1].Address, netip.MustParseAddr("203.0.113.1"), "hop 2 majority responder")
+	assertEqual(t, path[1].LoadBalanced, true, "hop 2 load balanced")
+	assertEqual(t, len(path[1].Addresses), 2, "hop 2 responders")
+	assertEqual(t, path[2].Responded(), false, "hop 3 unresponsive")
+	assertEqual(t, path[2].MedianRtt, -1.0, "hop 3 median RTT")
+	assertEqual(t, path[3].Timeouts, uint(1), "hop 4 timeouts")
+	last, ok := trace.LastRespondingHop()
+	assertEqual(t, ok, true, "there is a last responding hop")
+	assertEqual(t, last.HopNumber, uint(4), "last responding hop")
+	assertEqual(t, trace.UnreachableError(), "H", "host unreachable")
+	assertEqual(t, trace.HasLoop(), false, "no loop")
+
+	looping := parse(1700000000, `
+		{"hop":1,"result":[{"from":"10.0.0.1","rtt":1.2}]},
+		{"hop":2,"result":[{"from":"203.0.113.1","rtt":5.1}]},
+		{"hop":3,"result":[{"from":"203.0.113.1","rtt":5.1}]},
+		{"hop":4,"result":[{"from":"203.0.113.9","rtt":6.1}]},
+		{"hop":5,"result":[{"from":"203.0.113.1","rtt":7.1}]},
+		{"hop":6,"result":[{"from":"203.0.113.9","rtt":8.1}]}`)
+	loops := looping.Loops()
+	assertEqual(t, len(loops), 2, "number of loops")
+	assertEqual(t, loops[0], TraceLoop{netip.MustParseAddr("203.0.113.1"), 2, 5}, "first loop")
+	assertEqual(t, loops[1], TraceLoop{netip.MustParseAddr("203.0.113.9"), 4, 6}, "second loop")
+
+	comparer := NewTracePathComparer()
+	assertEqual(t, comparer.Add(trace) == nil, true, "no change for the first result")
+	// other load balanced responder, unresponsive hop answers now: no change
+	same := parse(1700000900, `
+		{"hop":1,"result":[{"from":"10.0.0.1","rtt":1.2}]},
+		{"hop":2,"result":[{"from":"203.0.113.5","rtt":5.4}]},
+		{"hop":3,"result":[{"from":"203.0.113.9","rtt":9.0}]},
+		{"hop":4,"result":[{"from":"198.18.0.1","rtt":12.0,"err":"H"}]},
+		{"hop":5,"result":[{"x":"*"}]}`)
+	assertEqual(t, comparer.Add(same) == nil, true, "no change for an equivalent path")
+	other := parse(1700001800, `
+		{"hop":1,"result":[{"from":"10.0.0.1","rtt":1.2}]},
+		{"hop":2,"result":[{"from":"203.0.113.5","rtt":5.4}]},
+		{"hop":3,"result":[{"from":"203.0.113.13","rtt":9.0}]}`)
+	change := comparer.Add(other)
+	if change == nil {
+		t.Fatalf("path change was not detected")
+	}
+	assertEqual(t, change.FirstDifference, uint(3), "first different hop")
+	assertEqual(t, change.Before, time.Unix(1700000900, 0), "time before the change")
+	assertEqual(t, len(change.NewPath), 3, "new path length")
+	assertEqual(t, len(comparer.Changes()), 1, "number of changes")
+}
@@ -0,0 +1,64 @@
+/*
+  (C) 2022 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"fmt"
+)
+
+// AsyncTypedResult is like AsyncResult, but holds a specific result type
+type AsyncTypedResult[T Result] struct {
+	Result T
+	Error  error
+}
+
+// As narrows an AsyncResult down to a specific result type, e.g.
+// *PingResult or *DnsResult. It returns the error in the AsyncResult if
+// there was one, or an error if the result is of a different type
+func As[T Result](ar AsyncResult) (T, error) {
+	var typed T
+	if ar.Error != nil {
+		return typed, ar.Error
+	}
+	if ar.Result == nil || *ar.Result == nil {
+		return typed, fmt.Errorf("no result")
+	}
+	typed, ok := (*ar.Result).(T)
+	if !ok {
+		res := *ar.Result
+		return typed, fmt.Errorf("result type mismatch: expected %T, got %T (type %s) for msm %d probe %d",
+			typed, res, res.TypeName(), baseOf(res).MeasurementID, res.GetProbeID())
+	}
+	return typed, nil
+}
+
+// Typed turns a channel of results into a channel of a specific result
+// type. Results of other types show up as errors on the output channel.
+// The output channel is closed when the input channel is closed
+func Typed[T Result](results chan AsyncResult) chan AsyncTypedResult[T] {
+	typed := make(chan AsyncTypedResult[T])
+	go func() {
+		defer close(typed)
+		for ar := range results {
+			res, err := As[T](ar)
+			typed <- AsyncTypedResult[T]{res, err}
+		}
+	}()
+	return typed
+}
+
+// baseOf digs out the BaseResult from any result type
+func baseOf(res Result) *BaseResult {
+	if b, ok := res.(interface{ base() *BaseResult }); ok {
+		return b.base()
+	}
+	return &BaseResult{Type: res.TypeName(), ProbeID: res.GetProbeID()}
+}
+
+func (result *BaseResult) base() *BaseResult {
+	return result
+}
@@ -8,7 +8,6 @@ package result
 
 import (
 	"encoding/json"
-	"fmt"
 )
 
 type UptimeResult struct {
@@ -20,14 +19,19 @@ func (result *UptimeResult) TypeName() string {
 	return "uptime"
 }
 
+// MarshalJSON encodes the result in the format of the API
+func (uptime *UptimeResult) MarshalJSON() ([]byte, error) {
+	return marshalAtlas(uptime.api(), &uptime.BaseResult)
+}
+
 func (uptime *UptimeResult) Parse(from string) (err error) {
 	var iuptime uptimeResult
 	err = json.Unmarshal([]byte(from), &iuptime)
 	if err != nil {
-		return err
+		return parseError(&iuptime.BaseResult, "uptime", err)
 	}
 	if iuptime.Type != "uptime" {
-		return fmt.Errorf("this is not an uptime result (type=%s)", iuptime.Type)
+		return parseErrorf(&iuptime.BaseResult, "uptime", "this is not an uptime result (type=%s)", iuptime.Type)
 	}
 	uptime.BaseResult = iuptime.BaseResult
 	uptime.Uptime = iuptime.Uptime
@@ -43,3 +47,8 @@ type uptimeResult struct {
 	BaseResult
 	Uptime uint `json:"uptime"` //
 }
+
+// api converts an uptime result to the API version
+func (uptime *UptimeResult) api() any {
+	return &uptimeResult{uptime.BaseResult, uptime.Uptime}
+}
@@ -0,0 +1,172 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+type WifiResult struct {
+	BaseResult
+	Error           string            // if the probe could not connect, e.g. "wpa timeout"
+	Ssid            string            //
+	Bssid           string            // MAC address of the access point
+	Address         string            // MAC address of the probe
+	IPAddress       netip.Addr        // address the probe got on the wireless network
+	KeyManagement   string            // e.g. "WPA2-PSK"
+	PairwiseCipher  string            // e.g. "CCMP"
+	GroupCipher     string            // e.g. "CCMP"
+	Mode            string            // e.g. "station"
+	State           string            // WPA state, e.g. "COMPLETED"
+	NetworkID       uint              // wpa_supplicant's network ID
+	ConnectTime     uint              // seconds it took to connect
+	SupplicantExtra map[string]string // any other wpa_supplicant fields
+}
+
+func (result *WifiResult) TypeName() string {
+	return "wifi"
+}
+
+// Connected tells if the probe managed to associate with the network
+func (result *WifiResult) Connected() bool {
+	return result.Error == "" && result.State == "COMPLETED"
+}
+
+// MarshalJSON encodes the result in the format of the API
+func (wifi *WifiResult) MarshalJSON() ([]byte, error) {
+	return marshalAtlas(wifi.api(), &wifi.BaseResult)
+}
+
+func (wifi *WifiResult) Parse(from string) (err error) {
+	var iwifi wifiResult
+	err = json.Unmarshal([]byte(from), &iwifi)
+	if err != nil {
+		return parseError(&iwifi.BaseResult, "wifi", err)
+	}
+	if iwifi.Type != "wifi" {
+		return parseErrorf(&iwifi.BaseResult, "wifi", "this is not a wifi result (type=%s)", iwifi.Type)
+	}
+	wifi.BaseResult = iwifi.BaseResult
+	wifi.Error = iwifi.Error
+	wifi.SupplicantExtra = make(map[string]string)
+
+	for key, value := range iwifi.WpaSupplicant {
+		switch key {
+		case "ssid":
+			wifi.Ssid = value
+		case "bssid":
+			wifi.Bssid = value
+		case "address":
+			wifi.Address = value
+		case "ip_address":
+			if value != "" {
+				wifi.IPAddress, err = netip.ParseAddr(value)
+				if err != nil {
+					return parseError(&iwifi.BaseResult, "wifi", err)
+				}
+			}
+		case "key_mgmt":
+			wifi.KeyManagement = value
+		case "pairwise_cipher":
+			wifi.PairwiseCipher = value
+		case "group_cipher":
+			wifi.GroupCipher = value
+		case "mode":
+			wifi.Mode = value
+		case "wpa_state":
+			wifi.State = value
+		case "id":
+			wifi.NetworkID, err = parseWifiUint(value)
+			if err != nil {
+				return parseErrorf(&iwifi.BaseResult, "wifi", "bad network id: %v", err)
+			}
+		case "connect-time":
+			wifi.ConnectTime, err = parseWifiUint(value)
+			if err != nil {
+				return parseErrorf(&iwifi.BaseResult, "wifi", "bad connect time: %v", err)
+			}
+		default:
+			wifi.SupplicantExtra[key] = value
+		}
+	}
+
+	return nil
+}
+
+func parseWifiUint(value string) (uint, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 0)
+	return uint(n), err
+}
+
+// api converts a wifi result to the API version
+func (wifi *WifiResult) api() any {
+	wpa := make(wpaSupplicantData)
+	for key, value := range wifi.SupplicantExtra {
+		wpa[key] = value
+	}
+	for key, value := range map[string]string{
+		"ssid":            wifi.Ssid,
+		"bssid":           wifi.Bssid,
+		"address":         wifi.Address,
+		"key_mgmt":        wifi.KeyManagement,
+		"pairwise_cipher": wifi.PairwiseCipher,
+		"group_cipher":    wifi.GroupCipher,
+		"mode":            wifi.Mode,
+		"wpa_state":       wifi.State,
+		"id":              strconv.FormatUint(uint64(wifi.NetworkID), 10),
+		"connect-time":    strconv.FormatUint(uint64(wifi.ConnectTime), 10),
+	} {
+		wpa[key] = value
+	}
+	if wifi.ConnectTime == 0 {
+		delete(wpa, "connect-time")
+	}
+	if wifi.IPAddress.IsValid() {
+		wpa["ip_address"] = wifi.IPAddress.String()
+	}
+	return &wifiResult{wifi.BaseResult, wifi.Error, wpa}
+}
+
+//////////////////////////////////////////////////////
+// API version of a wifi result
+
+// this is the JSON structure as reported by the API
+type wifiResult struct {
+	BaseResult
+	Error         string            `json:"error"`          //
+	WpaSupplicant wpaSupplicantData `json:"wpa_supplicant"` //
+}
+
+// the probe reports wpa_supplicant's status fields, mostly as strings
+type wpaSupplicantData map[string]string
+
+func (wpa *wpaSupplicantData) UnmarshalJSON(data []byte) error {
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*wpa = make(wpaSupplicantData, len(fields))
+	for key, value := range fields {
+		switch v := value.(type) {
+		case nil:
+		case string:
+			(*wpa)[key] = strings.TrimSpace(v)
+		case float64:
+			(*wpa)[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			(*wpa)[key] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package result
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+// Test parsing wifi results
+func TestWifiResult(t *testing.T) {
+	res, err := Parse(`{"fw":4790,"msm_id":9001,"prb_id":6001,"timestamp":1700000000,"type":"wifi","wpa_supplicant":{"address":"00:13:10:aa:bb:cc","bssid":"00:0b:0e:11:22:33","connect-time":"3","group_cipher":"CCMP","id":"0","ip_address":"192.0.2.77","key_mgmt":"WPA2-PSK","mode":"station","pairwise_cipher":"CCMP","ssid":"atlas-test","wpa_state":"COMPLETED","freq":2412}}`)
+	if err != nil {
+		t.Fatalf("Error parsing wifi result: %v", err)
+	}
+	wifi, ok := res.(*WifiResult)
+	if !ok {
+		t.Fatalf("wifi result is parsed as %T", res)
+	}
+	assertEqual(t, wifi.Ssid, "atlas-test", "SSID")
+	assertEqual(t, wifi.Bssid, "00:0b:0e:11:22:33", "BSSID")
+	assertEqual(t, wifi.IPAddress, netip.MustParseAddr("192.0.2.77"), "IP address")
+	assertEqual(t, wifi.KeyManagement, "WPA2-PSK", "key management")
+	assertEqual(t, wifi.ConnectTime, uint(3), "connect time")
+	assertEqual(t, wifi.Connected(), true, "connected")
+	assertEqual(t, wifi.SupplicantExtra["freq"], "2412", "other fields")
+
+	var failed WifiResult
+	err = failed.Parse(`{"fw":4790,"msm_id":9001,"prb_id":6002,"timestamp":1700000100,"type":"wifi","error":"wpa timeout","wpa_supplicant":{"ssid":"atlas-test","wpa_state":"SCANNING","id":0}}`)
+	if err != nil {
+		t.Fatalf("Error parsing wifi result: %v", err)
+	}
+	assertEqual(t, failed.Error, "wpa timeout", "error")
+	assertEqual(t, failed.Connected(), false, "not connected")
+}
+
+// Test the fallback for unknown types and registering parsers
+func TestGenericResult(t *testing.T) {
+	input := `{"fw":5080,"msm_id":1001,"prb_id":11,"timestamp":1700000000,"type":"bogus","answer":42}`
+	res, err := Parse(input)
+	if err != nil {
+		t.Fatalf("Error parsing unknown result type: %v", err)
+	}
+	generic, ok := res.(*GenericResult)
+	if !ok {
+		t.Fatalf("unknown result type is parsed as %T", res)
+	}
+	assertEqual(t, generic.TypeName(), "bogus", "type name")
+	assertEqual(t, generic.ProbeID, uint(11), "probe ID")
+	var extra struct{ Answer int }
+	assertEqual(t, generic.Decode(&extra), nil, "decoding")
+	assertEqual(t, extra.Answer, 42, "decoded field")
+
+	// a wrong hint should not be an error, nor an unknown one
+	ping := `{"fw":5080,"msm_id":1002,"prb_id":11,"timestamp":1700000000,"type":"ping","result":[{"rtt":1.5}]}`
+	res, err = ParseWithTypeHint(ping, "traceroute")
+	assertEqual(t, err, nil, "wrong hint")
+	assertEqual(t, res.TypeName(), "ping", "type with the wrong hint")
+	res, err = ParseWithTypeHint(ping, "bogus")
+	assertEqual(t, err, nil, "unknown hint")
+	assertEqual(t, res.TypeName(), "ping", "type with an unknown hint")
+
+	err = RegisterParser("bogus", func() Result { return &bogusResult{} })
+	assertEqual(t, err, nil, "registering a parser")
+	defer func() {
+		parsersMutex.Lock()
+		delete(parsers, "bogus")
+		parsersMutex.Unlock()
+	}()
+	assertEqual(t, slices.Contains(RegisteredTypes(), "bogus"), true, "registered types")
+	res, err = Parse(input)
+	if err != nil {
+		t.Fatalf("Error parsing registered result type: %v", err)
+	}
+	bogus, ok := res.(*bogusResult)
+	assertEqual(t, ok, true, "registered parser is used")
+	assertEqual(t, bogus.Answer, 42, "field parsed by the registered parser")
+
+	assertEqual(t, RegisterParser("", nil) != nil, true, "registering without a name")
+}
+
+type bogusResult struct {
+	GenericResult
+	Answer int
+}
+
+func (bogus *bogusResult) Parse(from string) error {
+	if err := bogus.GenericResult.Parse(from); err != nil {
+		return err
+	}
+	var extra struct{ Answer int }
+	if err := bogus.Decode(&extra); err != nil {
+		return NewParseError(&bogus.BaseResult, "bogus", err)
+	}
+	bogus.Answer = extra.Answer
+	return nil
+}
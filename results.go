@@ -7,11 +7,15 @@
 package goatapi
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
+	"path/filepath"
 	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -22,9 +26,9 @@ import (
 // ResultsFilter struct holds specified filters and other options
 type ResultsFilter struct {
 	params   url.Values
-	id       uint   // which measurement
-	file     string // which file to read from
-	stream   bool   // use result streaming?
+	id       uint     // which measurement
+	files    []string // which files (or directories, or globs) to read from
+	stream   bool     // use result streaming?
 	limit    uint
 	fetched  uint
 	start    *time.Time
@@ -32,8 +36,20 @@ type ResultsFilter struct {
 	probes   []uint
 	latest   bool
 	typehint string
-	saveFile *os.File // save results to this file (if not nil)
+	saveTo   *os.File       // save results to this file (if not nil)
+	saveComp Compression    // compression to use when saving
+	saveFile io.WriteCloser // the (possibly compressing) writer while getting results
 	saveAll  bool
+	maxSize  int // maximum size of one result (line), 0 means default
+
+	parallelism   uint // number of parser workers, 0 or 1 means no parallel parsing
+	preserveOrder bool // deliver results in input order even if parsing in parallel
+	keepSource    bool // results keep their source and unknown fields
+}
+
+// ResultFormats lists the formats in which the data API can return results
+var ResultFormats = []string{
+	"txt", "json",
 }
 
 // NewResultsFilter prepares a new result filter object
@@ -42,6 +58,7 @@ func NewResultsFilter() ResultsFilter {
 	filter.params = url.Values{}
 	filter.params.Add("format", "txt")
 	filter.probes = make([]uint, 0)
+	filter.preserveOrder = true
 	return filter
 }
 
@@ -51,8 +68,18 @@ func (filter *ResultsFilter) FilterID(id uint) {
 }
 
 // FilterFile "filters" results from a particular file
+// The file name can also be a directory (all files in it are read) or
+// a glob pattern; "-" means stdin. Compressed files (gzip, bzip2, xz,
+// zstd) are detected automatically
 func (filter *ResultsFilter) FilterFile(filename string) {
-	filter.file = filename
+	filter.files = []string{filename}
+}
+
+// FilterFiles "filters" results from a list of files
+// Each item can be a file, a directory or a glob pattern, see FilterFile().
+// Files are read in the order of the timestamps of their first results
+func (filter *ResultsFilter) FilterFiles(filenames []string) {
+	filter.files = filenames
 }
 
 // FilterStart filters for results after this timestamp
@@ -90,7 +117,22 @@ func (filter *ResultsFilter) FilterLatest() {
 
 // Save the results to this particular file
 func (filter *ResultsFilter) Save(file *os.File) {
-	filter.saveFile = file
+	filter.saveTo = file
+	filter.saveComp = CompressionNone
+}
+
+// SaveCompressed saves the results to this particular file, compressed
+// with the specified method. Reading bzip2 is supported but writing it is not
+// Each GetResults call on the filter appends a new compressed stream
+func (filter *ResultsFilter) SaveCompressed(file *os.File, compression Compression) error {
+	writer, err := compressingWriter(io.Discard, compression)
+	if err != nil {
+		return err
+	}
+	writer.Close()
+	filter.saveTo = file
+	filter.saveComp = compression
+	return nil
 }
 
 // SaveAll determines if all results are saved, or only the matched ones
@@ -108,12 +150,50 @@ func (filter *ResultsFilter) Limit(max uint) {
 	filter.limit = max
 }
 
+// Format sets the format in which the data API returns results: "txt" (the
+// default) is one result per line, "json" is a JSON array of results
+// Both are read in a streaming fashion
+func (filter *ResultsFilter) Format(format string) {
+	filter.params.Set("format", format)
+}
+
+// Parallelism sets the number of workers used to parse results
+// Parsing some result types (DNS, TLS) can be CPU intensive, using more
+// workers speeds this up. The default (0 or 1) is to parse sequentially
+func (filter *ResultsFilter) Parallelism(workers uint) {
+	filter.parallelism = workers
+}
+
+// PreserveOrder determines if results are delivered in the order they were
+// read even when parsing in parallel. This is on by default; turning it off
+// can help throughput if some results take much longer to parse than others
+func (filter *ResultsFilter) PreserveOrder(preserve bool) {
+	filter.preserveOrder = preserve
+}
+
+// KeepSource makes results keep their source (BaseResult.Raw) and the
+// top-level fields their parser doesn't know about (BaseResult.Unknown)
+func (filter *ResultsFilter) KeepSource(keep bool) {
+	filter.keepSource = keep
+}
+
+// MaxResultSize sets the maximum size of one result (line) in bytes
+// Longer results are skipped and reported as errors
+// The default is DefaultMaxResultSize
+func (filter *ResultsFilter) MaxResultSize(size uint) {
+	filter.maxSize = int(size)
+}
+
 // Verify sanity of applied filters
 func (filter *ResultsFilter) verifyFilters() error {
-	if filter.id == 0 && filter.file == "" {
+	if filter.id == 0 && len(filter.files) == 0 {
 		return fmt.Errorf("ID or filename must be specified")
 	}
 
+	if !slices.Contains(ResultFormats, filter.params.Get("format")) {
+		return fmt.Errorf("invalid result format: %s", filter.params.Get("format"))
+	}
+
 	return nil
 }
 
@@ -123,18 +203,36 @@ func (filter *ResultsFilter) GetResults(
 	verbose bool,
 	results chan result.AsyncResult,
 ) {
+	filter.getResults(context.Background(), verbose, results)
+}
+
+// getResults is GetResults that stops when the context is cancelled
+func (filter *ResultsFilter) getResults(
+	ctx context.Context,
+	verbose bool,
+	results chan result.AsyncResult,
+) {
+	err := filter.startSave()
+	if err != nil {
+		send(ctx, results, result.AsyncResult{Result: nil, Error: err})
+		close(results)
+		return
+	}
+
 	switch {
 	case filter.id != 0 && !filter.stream:
-		filter.downloadResults(verbose, results)
+		filter.downloadResults(ctx, verbose, results)
 	case filter.id != 0 && filter.stream:
-		filter.streamResults(verbose, results)
+		filter.streamResults(ctx, verbose, results)
 	case filter.id == 0 && filter.stream:
-		results <- result.AsyncResult{Result: nil, Error: fmt.Errorf("no ID was speficied for stream")}
+		send(ctx, results, result.AsyncResult{Result: nil, Error: fmt.Errorf("no ID was speficied for stream")})
+		filter.finishSave(ctx, results)
 		close(results)
-	case filter.file != "":
-		filter.getFileResults(verbose, results)
+	case len(filter.files) != 0:
+		filter.getFileResults(ctx, verbose, results)
 	default:
-		results <- result.AsyncResult{Result: nil, Error: fmt.Errorf("neither ID nor input file were specified")}
+		send(ctx, results, result.AsyncResult{Result: nil, Error: fmt.Errorf("neither ID nor input file were specified")})
+		filter.finishSave(ctx, results)
 		close(results)
 	}
 }
@@ -142,37 +240,44 @@ func (filter *ResultsFilter) GetResults(
 // DownloadResults returns results from the data API
 // via a channel by applying the specified filters
 func (filter *ResultsFilter) downloadResults(
+	ctx context.Context,
 	verbose bool,
 	results chan result.AsyncResult,
 ) {
 	defer close(results)
+	defer filter.finishSave(ctx, results)
 
 	// prepare to read results
-	read, err := filter.openNetworkResults(verbose)
+	read, err := filter.openNetworkResults(ctx, verbose)
 	if err != nil {
-		results <- result.AsyncResult{Result: nil, Error: err}
+		send(ctx, results, result.AsyncResult{Result: nil, Error: err})
 		return
 	}
 
-	filter.readResults(verbose, read, results)
+	pipeline := filter.newResultPipeline(ctx, results)
+	defer pipeline.finish()
+
+	filter.readResults(read, pipeline)
 }
 
 // StreamResults returns results from the streaming API
 // via a channel by applying the specified filters
 func (filter *ResultsFilter) streamResults(
+	ctx context.Context,
 	verbose bool,
 	results chan result.AsyncResult,
 ) {
 	// connect to the streaming API
-	conn, _, err := websocket.DefaultDialer.Dial(streamBaseURL, nil)
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, streamBaseURL, nil)
 	if err != nil {
-		results <- result.AsyncResult{Result: nil, Error: err}
+		send(ctx, results, result.AsyncResult{Result: nil, Error: err})
+		filter.finishSave(ctx, results)
 		close(results)
 		return
 	}
 
 	// handle the resuts coming form the websocket
-	go filter.streamReceiveHandler(verbose, conn, results)
+	go filter.streamReceiveHandler(ctx, verbose, conn, results)
 
 	// using types and marshaling may be overkill - but it's flexible
 	subscription := make([]any, 2)
@@ -186,63 +291,231 @@ func (filter *ResultsFilter) streamResults(
 	conn.WriteJSON(subscription)
 }
 
-// getFileResults returns results from a file via a channel
+// getFileResults returns results from files via a channel
 // If the file is "-" then it reads from stdin
 func (filter *ResultsFilter) getFileResults(
+	ctx context.Context,
 	verbose bool,
 	results chan result.AsyncResult,
 ) {
 	defer close(results)
+	defer filter.finishSave(ctx, results)
+
+	files, err := expandFileList(filter.files)
+	if err != nil {
+		send(ctx, results, result.AsyncResult{Result: nil, Error: err})
+		return
+	}
+	sortFilesByTimeStamp(files)
+
+	pipeline := filter.newResultPipeline(ctx, results)
+	defer pipeline.finish()
+
+	for _, filename := range files {
+		if pipeline.done() {
+			return
+		}
+		err := filter.getOneFileResults(verbose, filename, pipeline)
+		if err != nil {
+			pipeline.submitError(err)
+		}
+	}
+}
 
+// getOneFileResults reads results from one (possibly compressed) file
+func (filter *ResultsFilter) getOneFileResults(
+	verbose bool,
+	filename string,
+	pipeline *resultPipeline,
+) error {
 	var file *os.File
-	if filter.file == "-" {
+	if filename == "-" {
 		file = os.Stdin
 		if verbose {
 			fmt.Printf("# Reading results from stdin\n")
 		}
 	} else {
 		var err error
-		file, err = os.Open(filter.file)
+		file, err = os.Open(filename)
 		if err != nil {
-			results <- result.AsyncResult{Result: nil, Error: err}
-			return
+			return err
 		}
 		defer file.Close()
 
 		if verbose {
-			fmt.Printf("# Reading results from file: %s\n", filter.file)
+			fmt.Printf("# Reading results from file: %s\n", filename)
+		}
+	}
+
+	reader, closer, err := decompressingReader(file)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", filename, err)
+	}
+	defer closer()
+
+	source := filename
+	if filename == "-" {
+		source = "stdin"
+	}
+	read := newResultReader(reader, source, filter.maxSize)
+
+	filter.readResults(read, pipeline)
+	return nil
+}
+
+// expandFileList turns a list of files, directories and glob patterns into
+// a list of files. Directories are not traversed recursively and hidden
+// files in them are skipped
+func expandFileList(list []string) ([]string, error) {
+	files := make([]string, 0)
+	var expand func(name string, glob bool) error
+	expand = func(name string, glob bool) error {
+		if name == "-" {
+			files = append(files, name)
+			return nil
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			if !glob {
+				return err
+			}
+			// maybe it's a glob pattern
+			matches, globerr := filepath.Glob(name)
+			if globerr != nil || len(matches) == 0 {
+				return err
+			}
+			for _, match := range matches {
+				if err := expand(match, false); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			files = append(files, name)
+			return nil
+		}
+		entries, err := os.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			files = append(files, filepath.Join(name, entry.Name()))
+		}
+		return nil
+	}
+
+	for _, item := range list {
+		if err := expand(item, true); err != nil {
+			return nil, err
 		}
 	}
+	return files, nil
+}
+
+// sortFilesByTimeStamp orders files by the timestamp of the first result
+// in each of them. Files that can't be read (and stdin) are put first so
+// that their errors surface early
+func sortFilesByTimeStamp(files []string) {
+	if len(files) < 2 {
+		return
+	}
+	stamps := make(map[string]time.Time)
+	for _, file := range files {
+		stamps[file] = firstTimeStamp(file)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return stamps[files[i]].Before(stamps[files[j]])
+	})
+}
+
+// firstTimeStamp returns the timestamp of the first result in a file,
+// or zero time if that cannot be determined
+func firstTimeStamp(filename string) time.Time {
+	if filename == "-" {
+		return time.Time{}
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return time.Time{}
+	}
+	defer file.Close()
 
-	read := bufio.NewScanner(bufio.NewReader(file))
+	reader, closer, err := decompressingReader(file)
+	if err != nil {
+		return time.Time{}
+	}
+	defer closer()
 
-	filter.readResults(verbose, read, results)
+	read := newResultReader(reader, filename, 0)
+	for {
+		line, err := read.next()
+		if err == io.EOF {
+			break
+		}
+		line = strings.TrimSpace(line)
+		if err != nil || line == "" {
+			continue
+		}
+		var base result.BaseResult
+		if base.Parse(line) != nil {
+			return time.Time{}
+		}
+		return base.GetTimeStamp()
+	}
+	return time.Time{}
 }
 
+// readResults feeds results from a reader into the pipeline
 func (filter *ResultsFilter) readResults(
-	verbose bool,
-	read *bufio.Scanner,
-	results chan result.AsyncResult,
+	read resultReader,
+	pipeline *resultPipeline,
 ) {
-	for read.Scan() && (filter.limit == 0 || filter.fetched < filter.limit) {
-		line := read.Text()
-		filter.processResult(line, verbose, results)
+	for !pipeline.done() {
+		line, err := read.next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			pipeline.submitError(err)
+			var toolong *ResultTooLongError
+			if errors.As(err, &toolong) {
+				continue // skip this one, carry on with the next
+			}
+			return
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		pipeline.submit(line)
 	}
 }
 
 func (filter *ResultsFilter) streamReceiveHandler(
+	ctx context.Context,
 	verbose bool,
 	connection *websocket.Conn,
 	results chan result.AsyncResult,
 ) {
 	defer connection.Close()
 	defer close(results)
+	defer filter.finishSave(ctx, results)
+
+	pipeline := filter.newResultPipeline(ctx, results)
+	defer pipeline.finish()
+
+	// cancelling interrupts waiting for the next message
+	stop := context.AfterFunc(ctx, func() { connection.Close() })
+	defer stop()
 
 	for {
 		_, msg, err := connection.ReadMessage()
 		if err != nil {
 			err := fmt.Errorf("error reading from stream: %v", err)
-			results <- result.AsyncResult{Result: nil, Error: err}
+			send(ctx, results, result.AsyncResult{Result: nil, Error: err})
 			return
 		}
 
@@ -258,72 +531,67 @@ func (filter *ResultsFilter) streamReceiveHandler(
 			continue
 		default:
 			err := fmt.Errorf("unknown stream message received: %v", string(msg))
-			results <- result.AsyncResult{Result: nil, Error: err}
+			send(ctx, results, result.AsyncResult{Result: nil, Error: err})
 			return
 		}
 
 		pduresult := strings.TrimPrefix(string(msg), expectedResultPrefix)
 		pduresult = strings.TrimSuffix(pduresult, "]")
 
-		filter.processResult(pduresult, verbose, results)
+		pipeline.submit(pduresult)
 
-		if filter.limit > 0 && filter.fetched >= filter.limit {
+		if pipeline.done() {
 			return
 		}
 
 	}
 }
 
-func (filter *ResultsFilter) processResult(
+// saveResult writes a result string to the save file, if there's one
+func (filter *ResultsFilter) saveResult(
+	ctx context.Context,
 	resultString string,
-	verbose bool,
 	results chan result.AsyncResult,
 ) {
-	saveResult := func() {
-		if filter.saveFile != nil {
-			_, err := filter.saveFile.WriteString(resultString + "\n")
-			if err != nil {
-				results <- result.AsyncResult{Result: nil, Error: err}
-			}
-			// continue regardless of whether writing was successful
+	if filter.saveFile != nil {
+		_, err := io.WriteString(filter.saveFile, resultString+"\n")
+		if err != nil {
+			send(ctx, results, result.AsyncResult{Result: nil, Error: err})
 		}
+		// continue regardless of whether writing was successful
 	}
+}
 
-	if filter.saveAll {
-		saveResult()
+// startSave prepares the (possibly compressing) save writer, if saving
+// was asked for; it is done for every GetResults call so that a filter
+// can be used more than once
+func (filter *ResultsFilter) startSave() (err error) {
+	if filter.saveTo == nil {
+		return nil
 	}
+	filter.saveFile, err = compressingWriter(filter.saveTo, filter.saveComp)
+	return err
+}
 
-	res, err := result.ParseWithTypeHint(resultString, filter.typehint)
-	if err != nil {
-		results <- result.AsyncResult{Result: nil, Error: err}
+// finishSave flushes and closes the (possibly compressing) save writer,
+// but not the file itself
+func (filter *ResultsFilter) finishSave(ctx context.Context, results chan result.AsyncResult) {
+	if filter.saveFile == nil {
 		return
 	}
-
-	// check if time interval and probe constraints match (applicable if we're
-	// reading from a file), and if so, put the result on the channel
-	ts := time.Time(res.GetTimeStamp())
-	if (filter.start == nil || filter.start.Before(ts.Add(time.Duration(1)))) &&
-		(filter.stop == nil || filter.stop.After(ts.Add(time.Duration(-1)))) &&
-		(len(filter.probes) == 0 || slices.Contains(filter.probes, res.GetProbeID())) {
-		results <- result.AsyncResult{Result: &res, Error: nil}
-		filter.fetched++
-
-		if !filter.saveAll {
-			saveResult()
-		}
-	}
-
-	// a type hint makes parsing much faster
-	if filter.typehint == "" {
-		filter.typehint = res.TypeName()
+	err := filter.saveFile.Close()
+	if err != nil {
+		send(ctx, results, result.AsyncResult{Result: nil, Error: err})
 	}
+	filter.saveFile = nil
 }
 
 // prepare fetching results, i.e. verify parameters, connect to the API, etc.
 func (filter *ResultsFilter) openNetworkResults(
+	ctx context.Context,
 	verbose bool,
 ) (
-	read *bufio.Scanner,
+	read resultReader,
 	err error,
 ) {
 	// sanity checks - late in the process, but not too late
@@ -340,7 +608,7 @@ func (filter *ResultsFilter) openNetworkResults(
 	}
 	query += fmt.Sprintf("?%s", filter.params.Encode())
 
-	resp, err := apiGetRequest(verbose, query, nil)
+	resp, err := apiGetRequestContext(ctx, verbose, query, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -349,6 +617,6 @@ func (filter *ResultsFilter) openNetworkResults(
 		return nil, parseAPIError(resp)
 	}
 
-	// we're reading one result per line, a scanner is simple enough
-	return bufio.NewScanner(bufio.NewReader(resp.Body)), nil
+	// depending on the format, this is one result per line or a JSON array
+	return newResultReader(resp.Body, "API", filter.maxSize), nil
 }
@@ -0,0 +1,377 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/robert-kisteleki/goatapi/result"
+)
+
+// collect all results and errors from a filter
+func collectResults(t testing.TB, filter ResultsFilter) ([]result.Result, []error) {
+	t.Helper()
+	res := make([]result.Result, 0)
+	errs := make([]error, 0)
+	results := make(chan result.AsyncResult)
+	go filter.GetResults(false, results)
+	for r := range results {
+		if r.Error != nil {
+			errs = append(errs, r.Error)
+		} else {
+			res = append(res, *r.Result)
+		}
+	}
+	return res, errs
+}
+
+// write a compressed copy of a file
+func writeCompressed(t *testing.T, from, to string, compression Compression) {
+	t.Helper()
+	data, err := os.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer, err := compressingWriter(file, compression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test if compressed files are detected and read properly
+func TestCompressedFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]Compression{
+		"testdata/ping.txt":              CompressionNone,
+		filepath.Join(dir, "ping.gz"):    CompressionGzip,
+		filepath.Join(dir, "ping.xz"):    CompressionXz,
+		filepath.Join(dir, "ping.zst"):   CompressionZstd,
+		"testdata/ping.txt.bz2":          CompressionBzip2,
+		filepath.Join(dir, "ping.plain"): CompressionNone,
+	}
+	for name, compression := range files {
+		if filepath.Dir(name) == dir {
+			writeCompressed(t, "testdata/ping.txt", name, compression)
+		}
+	}
+
+	for name, compression := range files {
+		filter := NewResultsFilter()
+		filter.FilterFile(name)
+		res, errs := collectResults(t, filter)
+		if len(errs) != 0 {
+			t.Errorf("errors reading %s file %s: %v", CompressionDict[compression], name, errs)
+		}
+		if len(res) != 3 {
+			t.Errorf("expected 3 results from %s file %s, got %d", CompressionDict[compression], name, len(res))
+		}
+	}
+
+	if _, err := compressingWriter(nil, CompressionBzip2); err == nil {
+		t.Errorf("writing bzip2 should not be supported")
+	}
+}
+
+// Test if directories and globs are expanded and read in timestamp order
+func TestMultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+	// the "earlier" file has a later name and a different compression
+	later := []byte(`{"fw":5040,"type":"uptime","uptime":100,"prb_id":1,"msm_id":7001,"timestamp":1700000100}` + "\n")
+	earlier := []byte(`{"fw":5040,"type":"uptime","uptime":50,"prb_id":1,"msm_id":7001,"timestamp":1700000050}` + "\n")
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), later, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), earlier, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeCompressed(t, filepath.Join(dir, "b.txt"), filepath.Join(dir, "b.txt.gz"), CompressionGzip)
+	if err := os.WriteFile(filepath.Join(dir, ".hidden"), []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := NewResultsFilter()
+	filter.FilterFile(dir)
+	res, errs := collectResults(t, filter)
+	if len(errs) != 0 || len(res) != 3 {
+		t.Fatalf("reading a directory failed: %d results, errors: %v", len(res), errs)
+	}
+	if res[0].GetTimeStamp().After(res[2].GetTimeStamp()) {
+		t.Errorf("files were not read in timestamp order")
+	}
+
+	filter = NewResultsFilter()
+	filter.FilterFiles([]string{filepath.Join(dir, "*.txt"), filepath.Join(dir, "b.txt.gz")})
+	filter.Limit(2)
+	res, errs = collectResults(t, filter)
+	if len(errs) != 0 || len(res) != 2 {
+		t.Fatalf("reading a glob failed: %d results, errors: %v", len(res), errs)
+	}
+
+	filter = NewResultsFilter()
+	filter.FilterFile(filepath.Join(dir, "nonexistent*"))
+	_, errs = collectResults(t, filter)
+	if len(errs) == 0 {
+		t.Errorf("a non-matching glob should produce an error")
+	}
+}
+
+// Test if saving with compression produces a readable file
+func TestSaveCompressed(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "saved.zst")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := NewResultsFilter()
+	filter.FilterFile("testdata/ping.txt")
+	if err = filter.SaveCompressed(file, CompressionZstd); err != nil {
+		t.Fatal(err)
+	}
+	// using the same filter twice appends to the same file
+	for i := 0; i < 2; i++ {
+		results := make(chan result.AsyncResult)
+		go filter.GetResults(false, results)
+		for r := range results {
+			if r.Error != nil {
+				t.Fatalf("error while saving: %v", r.Error)
+			}
+		}
+	}
+	file.Close()
+
+	filter = NewResultsFilter()
+	filter.FilterFile(name)
+	res, errs := collectResults(t, filter)
+	if len(errs) != 0 || len(res) != 6 {
+		t.Errorf("reading back saved results failed: %d results, errors: %v", len(res), errs)
+	}
+}
+
+// Test if long results are read, and too long ones are skipped with an error
+func TestLongResults(t *testing.T) {
+	pings, err := os.ReadFile("testdata/ping.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(pings)), "\n")
+
+	// pad the second result to well over the bufio.Scanner default of 64k
+	padding := `"padding":"` + strings.Repeat("x", 100*1024) + `",`
+	long := strings.Replace(lines[1], "{", "{"+padding, 1)
+	name := filepath.Join(t.TempDir(), "long.txt")
+	content := lines[0] + "\n" + long + "\r\n" + lines[2] + "\n"
+	if err = os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := NewResultsFilter()
+	filter.FilterFile(name)
+	res, errs := collectResults(t, filter)
+	if len(errs) != 0 || len(res) != 3 {
+		t.Errorf("reading long results failed: %d results, errors: %v", len(res), errs)
+	}
+
+	filter = NewResultsFilter()
+	filter.FilterFile(name)
+	filter.MaxResultSize(64 * 1024)
+	res, errs = collectResults(t, filter)
+	if len(res) != 2 || len(errs) != 1 {
+		t.Fatalf("expected 2 results and 1 error, got %d and %v", len(res), errs)
+	}
+	var toolong *ResultTooLongError
+	if !errors.As(errs[0], &toolong) {
+		t.Fatalf("unexpected error type %T: %v", errs[0], errs[0])
+	}
+	assertEqual(t, toolong.Line, uint(2), "wrong line reported for long result")
+	assertEqual(t, toolong.Offset, int64(len(lines[0])+1), "wrong offset reported for long result")
+	assertEqual(t, toolong.Size, len(long), "wrong size reported for long result")
+	assertEqual(t, toolong.Source, name, "wrong source reported for long result")
+}
+
+// Test if a \r\n line terminator is stripped even if it's split between two reads
+func TestLineReaderSplitTerminator(t *testing.T) {
+	first := strings.Repeat("x", 64*1024-1) // the \r fills up the read buffer
+	lr := newLineReader(strings.NewReader(first+"\r\nsecond\r\n"), "test", 0)
+	line, err := lr.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, line, first, "first line with a split terminator")
+	line, err = lr.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, line, "second", "second line")
+	_, err = lr.next()
+	assertEqual(t, err, io.EOF, "end of input")
+}
+
+// almost one-liner to reduce boiler plate
+func assertEqual(t *testing.T, val1 interface{}, val2 interface{}, msg string) {
+	if val1 == val2 {
+		return
+	}
+	t.Errorf("%s: received %v (type %v), expected %v (type %v)", msg, val1, reflect.TypeOf(val1), val2, reflect.TypeOf(val2))
+}
+
+// Test if JSON arrays and pretty-printed results are detected and read
+func TestJSONLayouts(t *testing.T) {
+	pings, err := os.ReadFile("testdata/ping.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(pings)), "\n")
+
+	dir := t.TempDir()
+	pretty := make([]string, 0)
+	for _, line := range lines {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, []byte(line), "", "  "); err != nil {
+			t.Fatal(err)
+		}
+		pretty = append(pretty, buf.String())
+	}
+	inputs := map[string]string{
+		"array.json":        "[" + strings.Join(lines, ",") + "]",
+		"prettyarray.json":  "\n  [\n" + strings.Join(pretty, ",\n") + "\n]\n",
+		"prettystream.json": strings.Join(pretty, "\n") + "\n",
+		"lines.txt":         "\n" + strings.Join(lines, "\n"),
+	}
+	for name, content := range inputs {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		filter := NewResultsFilter()
+		filter.FilterFile(path)
+		res, errs := collectResults(t, filter)
+		if len(errs) != 0 || len(res) != 3 {
+			t.Errorf("reading %s failed: %d results, errors: %v", name, len(res), errs)
+		}
+	}
+
+	// results that are too long are skipped while reading, without
+	// keeping them in memory
+	long := strings.Replace(lines[1], "{", `{"padding":"`+strings.Repeat("x", 2000)+`",`, 1)
+	path := filepath.Join(dir, "long.json")
+	if err := os.WriteFile(path, []byte("["+lines[0]+","+long+","+lines[2]+"]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	filter := NewResultsFilter()
+	filter.FilterFile(path)
+	filter.MaxResultSize(1024)
+	res, errs := collectResults(t, filter)
+	if len(res) != 2 || len(errs) != 1 {
+		t.Fatalf("expected 2 results and 1 error, got %d and %v", len(res), errs)
+	}
+	var toolong *ResultTooLongError
+	if !errors.As(errs[0], &toolong) {
+		t.Fatalf("unexpected error type %T: %v", errs[0], errs[0])
+	}
+	assertEqual(t, toolong.Line, uint(2), "wrong result reported as too long")
+	assertEqual(t, toolong.Offset, int64(len(lines[0])+2), "wrong offset reported for long result")
+	assertEqual(t, toolong.Size, len(long), "wrong size reported for long result")
+
+	// a broken array is reported
+	path = filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(path, []byte("["+lines[0]+",{\"fw\":"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	filter = NewResultsFilter()
+	filter.FilterFile(path)
+	res, errs = collectResults(t, filter)
+	if len(errs) != 1 || len(res) != 1 {
+		t.Errorf("reading a broken array: %d results, errors: %v", len(res), errs)
+	}
+}
+
+// Test if results in JSON format are read from the API
+func TestAPIJSONFormat(t *testing.T) {
+	pings, err := os.ReadFile("testdata/ping.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(pings)), "\n")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") != "json" {
+			t.Errorf("unexpected format requested: %s", r.URL.Query().Get("format"))
+		}
+		fmt.Fprint(w, "["+strings.Join(lines, ",\n")+"]")
+	}))
+	defer server.Close()
+	oldbase := apiBaseURL
+	SetAPIBase(server.URL + "/")
+	defer SetAPIBase(oldbase)
+
+	filter := NewResultsFilter()
+	filter.FilterID(1001)
+	filter.Format("json")
+	res, errs := collectResults(t, filter)
+	if len(errs) != 0 || len(res) != 3 {
+		t.Errorf("reading from the API failed: %d results, errors: %v", len(res), errs)
+	}
+
+	filter = NewResultsFilter()
+	filter.FilterID(1001)
+	filter.Format("xml")
+	if filter.verifyFilters() == nil {
+		t.Errorf("bad format is not filtered properly")
+	}
+}
+
+// Test if results keep their source when asked to
+func TestKeepSource(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "mixed.txt")
+	lines := `{"fw":5040,"type":"uptime","uptime":100,"prb_id":1,"msm_id":7001,"timestamp":1700000100,"newfield":1}
+{"fw":5040,"type":"bogus","prb_id":1,"msm_id":7002,"timestamp":1700000200,"data":[1,2,3]}
+`
+	if err := os.WriteFile(name, []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := NewResultsFilter()
+	filter.FilterFile(name)
+	filter.KeepSource(true)
+	res, errs := collectResults(t, filter)
+	if len(errs) != 0 || len(res) != 2 {
+		t.Fatalf("reading mixed results failed: %d results, errors: %v", len(res), errs)
+	}
+	uptime, ok := res[0].(*result.UptimeResult)
+	if !ok {
+		t.Fatalf("unexpected result type %T", res[0])
+	}
+	if string(uptime.Raw) != strings.Split(lines, "\n")[0] || string(uptime.Unknown["newfield"]) != "1" {
+		t.Errorf("source is not kept: %s %v", uptime.Raw, uptime.Unknown)
+	}
+	if generic, ok := res[1].(*result.GenericResult); !ok || string(generic.Unknown["data"]) != "[1,2,3]" {
+		t.Errorf("unknown result type is not kept: %T %v", res[1], res[1])
+	}
+}
@@ -0,0 +1,145 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"sync"
+
+	"github.com/robert-kisteleki/goatapi/result"
+)
+
+var ripeStatBaseURL = "https://stat.ripe.net/data/"
+
+// SetRipeStatBase allows the caller to modify the RIPEstat API to talk to
+// This is really only useful to developers who have access to compatible APIs
+func SetRipeStatBase(newRipeStatBaseURL string) {
+	ripeStatBaseURL = newRipeStatBaseURL
+}
+
+// RipeStatAnnotator is an AddressAnnotator that looks up addresses using
+// the RIPEstat prefix-overview API. Answers are cached per address: the
+// covering prefix of one address says nothing about announced
+// more-specifics, so other addresses in it are looked up on their own
+type RipeStatAnnotator struct {
+	verbose bool
+	ixps    *result.PrefixTable // IXP peering LANs
+	mutex   sync.Mutex
+	cache   map[netip.Addr]result.AddressAnnotation // addresses seen so far
+}
+
+// NewRipeStatAnnotator prepares a new annotator
+func NewRipeStatAnnotator() *RipeStatAnnotator {
+	return &RipeStatAnnotator{
+		ixps:  result.NewPrefixTable(),
+		cache: make(map[netip.Addr]result.AddressAnnotation),
+	}
+}
+
+// Verbose sets verbosity
+func (annotator *RipeStatAnnotator) Verbose(verbose bool) {
+	annotator.verbose = verbose
+}
+
+// IXPPrefixes tells the annotator which prefixes are IXP peering LANs,
+// as RIPEstat's prefix overview doesn't say so
+func (annotator *RipeStatAnnotator) IXPPrefixes(prefixes []netip.Prefix) {
+	for _, prefix := range prefixes {
+		annotator.ixps.AddIXP(prefix)
+	}
+}
+
+// Annotate looks up the origin of an address
+func (annotator *RipeStatAnnotator) Annotate(addr netip.Addr) (result.AddressAnnotation, error) {
+	addr = addr.Unmap()
+	ann, err := annotator.lookup(addr)
+	if err != nil {
+		return ann, err
+	}
+	if ixp, ok := annotator.ixps.Lookup(addr); ok {
+		ann.IXP = ixp.IXP
+	}
+	return ann, nil
+}
+
+func (annotator *RipeStatAnnotator) lookup(addr netip.Addr) (result.AddressAnnotation, error) {
+	annotator.mutex.Lock()
+	ann, ok := annotator.cache[addr]
+	annotator.mutex.Unlock()
+	if ok {
+		return ann, nil
+	}
+
+	overview, err := annotator.fetchPrefixOverview(addr)
+	if err != nil {
+		return result.AddressAnnotation{}, err
+	}
+
+	ann = result.AddressAnnotation{ASNs: make([]uint, 0)}
+	if overview.Announced {
+		for _, asn := range overview.ASNs {
+			ann.ASNs = append(ann.ASNs, asn.ASN)
+			if ann.Holder == "" {
+				ann.Holder = asn.Holder
+			}
+		}
+	}
+	prefix, err := netip.ParsePrefix(overview.Resource)
+	if err == nil && prefix.Contains(addr) && prefix.Bits() < addr.BitLen() {
+		ann.Prefix = prefix
+	}
+	annotator.mutex.Lock()
+	annotator.cache[addr] = ann
+	annotator.mutex.Unlock()
+	return ann, nil
+}
+
+func (annotator *RipeStatAnnotator) fetchPrefixOverview(addr netip.Addr) (*ripeStatPrefixOverview, error) {
+	query := url.Values{}
+	query.Set("resource", addr.String())
+	query.Set("sourceapp", "goatapi")
+	resp, err := apiGetRequest(annotator.verbose, ripeStatBaseURL+"prefix-overview/data.json?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, parseAPIError(resp)
+	}
+
+	var response ripeStatResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding RIPEstat answer for %s: %v", addr, err)
+	}
+	if response.Status != "ok" {
+		return nil, fmt.Errorf("RIPEstat lookup for %s failed: %s %v", addr, response.Status, response.Messages)
+	}
+	return &response.Data, nil
+}
+
+//////////////////////////////////////////////////////
+// API version of a RIPEstat prefix overview
+
+type ripeStatResponse struct {
+	Status   string                 `json:"status"`   //
+	Messages [][]string             `json:"messages"` //
+	Data     ripeStatPrefixOverview `json:"data"`     //
+}
+
+type ripeStatPrefixOverview struct {
+	Resource  string `json:"resource"`  // the prefix covering the queried address
+	Announced bool   `json:"announced"` //
+	ASNs      []struct {
+		ASN    uint   `json:"asn"`    //
+		Holder string `json:"holder"` //
+	} `json:"asns"` //
+}
@@ -0,0 +1,78 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// Test the RIPEstat annotator, including its cache
+func TestRipeStatAnnotator(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Query().Get("resource") {
+		case "203.0.113.1", "203.0.113.200":
+			fmt.Fprint(w, `{"status":"ok","data":{"resource":"203.0.113.0/24","announced":true,"asns":[{"asn":64500,"holder":"EXAMPLE-AS"}]}}`)
+		case "203.0.0.1":
+			fmt.Fprint(w, `{"status":"ok","data":{"resource":"203.0.0.0/16","announced":true,"asns":[{"asn":64501,"holder":"UPSTREAM-AS"}]}}`)
+		case "100.0.0.1":
+			fmt.Fprint(w, `{"status":"ok","data":{"resource":"100.0.0.1","announced":false,"asns":[]}}`)
+		case "192.0.2.66":
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"status":429,"title":"Too Many Requests"}`)
+		default:
+			fmt.Fprint(w, `{"status":"error","messages":[["error","bad resource"]]}`)
+		}
+	}))
+	defer server.Close()
+	oldbase := ripeStatBaseURL
+	SetRipeStatBase(server.URL + "/")
+	defer SetRipeStatBase(oldbase)
+
+	annotator := NewRipeStatAnnotator()
+	annotator.IXPPrefixes([]netip.Prefix{netip.MustParsePrefix("203.0.113.0/28")})
+
+	ann, err := annotator.Annotate(netip.MustParseAddr("203.0.113.1"))
+	if err != nil {
+		t.Fatalf("Error annotating: %s", err)
+	}
+	assertEqual(t, ann.Origin(), uint(64500), "origin AS")
+	assertEqual(t, ann.Holder, "EXAMPLE-AS", "holder")
+	assertEqual(t, ann.IXP, true, "IXP")
+
+	// a more-specific of a prefix seen before is looked up on its own
+	ann, _ = annotator.Annotate(netip.MustParseAddr("203.0.0.1"))
+	assertEqual(t, ann.Origin(), uint(64501), "origin AS of the less-specific")
+	ann, _ = annotator.Annotate(netip.MustParseAddr("203.0.113.200"))
+	assertEqual(t, ann.Origin(), uint(64500), "origin AS of the more-specific")
+	assertEqual(t, ann.IXP, false, "not IXP")
+	assertEqual(t, ann.Prefix, netip.MustParsePrefix("203.0.113.0/24"), "covering prefix")
+	assertEqual(t, calls, 3, "addresses are looked up on their own")
+	annotator.Annotate(netip.MustParseAddr("203.0.113.200"))
+	assertEqual(t, calls, 3, "answers are cached per address")
+
+	ann, _ = annotator.Annotate(netip.MustParseAddr("100.0.0.1"))
+	assertEqual(t, ann.Announced(), false, "unannounced address")
+	annotator.Annotate(netip.MustParseAddr("100.0.0.1"))
+	assertEqual(t, calls, 4, "misses are cached too")
+
+	_, err = annotator.Annotate(netip.MustParseAddr("192.0.2.1"))
+	if err == nil {
+		t.Fatalf("failed lookups should be reported")
+	}
+
+	_, err = annotator.Annotate(netip.MustParseAddr("192.0.2.66"))
+	if err == nil || !strings.Contains(err.Error(), "429") {
+		t.Fatalf("HTTP errors should be reported, got: %v", err)
+	}
+}
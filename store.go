@@ -0,0 +1,570 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robert-kisteleki/goatapi/result"
+	_ "modernc.org/sqlite"
+)
+
+// ResultStore keeps results in a local SQLite database, so that they
+// don't have to be downloaded again
+//
+// Every result is a row in the "results" table, with the common fields
+// (msm_id, prb_id, timestamp, type, dst_addr, dst_name, af, fw,
+// stored_timestamp) in indexed columns and the whole result (in the format
+// of the API) in "result".
+// The details are in per-type tables that follow the CSV schemas (see
+// result.CsvSchemas()): "ping", "ping_reply", "traceroute",
+// "traceroute_hop", "dns_response", "dns_answer", "http_reply", "ntp",
+// "sslcert", "uptime", "connection" and "wifi". Their "result_id" column
+// refers to results.id. Numbers are INTEGER or REAL, flags are BOOLEAN
+// (0 or 1), times and everything else is TEXT; missing values are NULL.
+// Columns added to the schemas later are added to existing databases when
+// they are opened. The same result is only stored once
+type ResultStore struct {
+	db       *sql.DB
+	lateness time.Duration // how late results can be uploaded for Sync
+}
+
+// StoreFilter selects results from a ResultStore
+type StoreFilter struct {
+	start  *time.Time
+	stop   *time.Time
+	probes []uint
+	msms   []uint
+	types  []string
+	limit  uint
+}
+
+// a column of a detail table
+type storeColumn struct {
+	name    string
+	sqlType string
+}
+
+// a detail table, following a CSV schema
+type storeDetailTable struct {
+	schema  string
+	name    string
+	columns []storeColumn // without result_id
+	insert  string
+}
+
+// the SQL types of the detail columns that are not TEXT
+var storeColumnTypes = map[string]string{
+	// ping, ping-reply
+	"size": "INTEGER", "ttl": "INTEGER", "sent": "INTEGER", "rcvd": "INTEGER",
+	"dup": "INTEGER", "min": "REAL", "avg": "REAL", "median": "REAL",
+	"max": "REAL", "timeouts": "INTEGER", "errors": "INTEGER", "loss": "REAL",
+	"jitter": "REAL", "reply": "INTEGER", "rtt": "REAL", "reply_ttl": "INTEGER",
+	"duplicate": "BOOLEAN",
+	// traceroute, traceroute-hop
+	"paris_id": "INTEGER", "hops": "INTEGER", "last_hop": "INTEGER",
+	"last_hop_rtt": "REAL", "loop": "BOOLEAN", "hop": "INTEGER",
+	"reply_size": "INTEGER", "timeout": "BOOLEAN", "late": "INTEGER",
+	"ittl": "INTEGER", "mtu": "INTEGER",
+	// dns-response, dns-answer
+	"response": "INTEGER", "rt": "REAL", "id": "INTEGER", "ancount": "INTEGER",
+	"nscount": "INTEGER", "arcount": "INTEGER",
+	// http-reply
+	"reply_af": "INTEGER", "res": "INTEGER", "hsize": "INTEGER",
+	"bsize": "INTEGER", "ttr": "REAL", "ttc": "REAL", "ttfb": "REAL",
+	// ntp
+	"version": "INTEGER", "stratum": "INTEGER", "poll": "INTEGER",
+	"precision": "REAL", "root_delay": "REAL", "root_dispersion": "REAL",
+	"replies": "INTEGER", "offset": "REAL", "delay": "REAL",
+	"synchronized": "BOOLEAN",
+	// sslcert
+	"certs": "INTEGER", "leaf_days_remaining": "INTEGER",
+	"hostname_match": "BOOLEAN", "alert_level": "INTEGER",
+	"alert_description": "INTEGER",
+	// uptime, connection, wifi
+	"uptime": "INTEGER", "asn": "INTEGER", "connect_time": "INTEGER",
+}
+
+// the detail tables, one for each CSV schema except "base"
+var storeDetailTables = func() []storeDetailTable {
+	tables := make([]storeDetailTable, 0)
+	for _, schema := range result.CsvSchemas() {
+		if schema == "base" {
+			continue
+		}
+		table := storeDetailTable{schema: schema, name: strings.ReplaceAll(schema, "-", "_")}
+		all, _ := result.CsvColumns(schema)
+		names := []string{"result_id"}
+		for _, column := range all[len(result.CsvBaseColumns):] {
+			sqlType, ok := storeColumnTypes[column]
+			if !ok {
+				sqlType = "TEXT"
+			}
+			table.columns = append(table.columns, storeColumn{column, sqlType})
+			names = append(names, `"`+column+`"`)
+		}
+		table.insert = fmt.Sprintf("INSERT INTO %s (%s) VALUES (?%s)",
+			table.name, strings.Join(names, ", "), strings.Repeat(", ?", len(table.columns)))
+		tables = append(tables, table)
+	}
+	return tables
+}()
+
+// how many results are stored in one transaction
+const storeBatchSize = 1000
+
+// DefaultSyncLateness is how late results can be uploaded by probes (i.e.
+// stored by the API after they were measured) and still be picked up by Sync
+const DefaultSyncLateness = 24 * time.Hour
+
+// OpenResultStore opens (or creates) a result store in an SQLite database file
+func OpenResultStore(filename string) (*ResultStore, error) {
+	db, err := sql.Open("sqlite", "file:"+filename+
+		"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, err
+	}
+	store := &ResultStore{db: db, lateness: DefaultSyncLateness}
+	if err := store.createTables(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error setting up result store %s: %v", filename, err)
+	}
+	return store, nil
+}
+
+// Close closes the database
+func (store *ResultStore) Close() error {
+	return store.db.Close()
+}
+
+// DB gives access to the database, e.g. to query the detail tables
+func (store *ResultStore) DB() *sql.DB {
+	return store.db
+}
+
+func (store *ResultStore) createTables() error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS results (
+			id INTEGER PRIMARY KEY,
+			msm_id INTEGER NOT NULL,
+			prb_id INTEGER NOT NULL,
+			timestamp INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			dst_addr TEXT,
+			dst_name TEXT,
+			af INTEGER,
+			fw INTEGER,
+			stored_timestamp INTEGER,
+			digest TEXT NOT NULL,
+			result TEXT NOT NULL,
+			UNIQUE (msm_id, prb_id, timestamp, digest)
+		)`,
+		`CREATE INDEX IF NOT EXISTS results_msm ON results (msm_id, timestamp)`,
+		`CREATE INDEX IF NOT EXISTS results_prb ON results (prb_id, timestamp)`,
+		`CREATE INDEX IF NOT EXISTS results_timestamp ON results (timestamp)`,
+		`CREATE INDEX IF NOT EXISTS results_type ON results (type, timestamp)`,
+		`CREATE INDEX IF NOT EXISTS results_dst_addr ON results (dst_addr)`,
+		`CREATE INDEX IF NOT EXISTS results_dst_name ON results (dst_name)`,
+	}
+	for _, table := range storeDetailTables {
+		defs := make([]string, 0)
+		for _, column := range table.columns {
+			defs = append(defs, `"`+column.name+`" `+column.sqlType)
+		}
+		statements = append(statements,
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+				result_id INTEGER NOT NULL REFERENCES results (id) ON DELETE CASCADE,
+				%s
+			)`, table.name, strings.Join(defs, ", ")),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_result ON %s (result_id)`, table.name, table.name),
+		)
+	}
+	for _, statement := range statements {
+		if _, err := store.db.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	// tables made by an earlier version may lack some columns
+	err := store.addMissingColumns("results", []storeColumn{{"stored_timestamp", "INTEGER"}})
+	if err != nil {
+		return err
+	}
+	for _, table := range storeDetailTables {
+		if err := store.addMissingColumns(table.name, table.columns); err != nil {
+			return err
+		}
+	}
+	_, err = store.db.Exec(`CREATE INDEX IF NOT EXISTS results_stored ON results (msm_id, stored_timestamp)`)
+	return err
+}
+
+// addMissingColumns adds the columns to a table that it doesn't have yet
+func (store *ResultStore) addMissingColumns(table string, columns []storeColumn) error {
+	rows, err := store.db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		if existing[column.name] {
+			continue
+		}
+		_, err := store.db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN "%s" %s`, table, column.name, column.sqlType))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add stores one result. It returns false if the result was already stored
+func (store *ResultStore) Add(res result.Result) (bool, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	added, err := storeResult(tx, res)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return added, tx.Commit()
+}
+
+// Ingest reads results from a channel until it is closed and stores them.
+// It returns the number of results newly stored, and the first error seen
+// (on the channel or while storing), but keeps draining the channel
+func (store *ResultStore) Ingest(results chan result.AsyncResult) (uint, error) {
+	var stored, inBatch uint
+	var failed error
+	var tx *sql.Tx
+	commit := func() {
+		if tx == nil {
+			return
+		}
+		if err := tx.Commit(); err != nil && failed == nil {
+			failed = err
+		}
+		tx = nil
+		inBatch = 0
+	}
+
+	for res := range results {
+		if res.Error != nil {
+			if failed == nil {
+				failed = res.Error
+			}
+			continue
+		}
+		if tx == nil {
+			var err error
+			tx, err = store.db.Begin()
+			if err != nil {
+				if failed == nil {
+					failed = err
+				}
+				continue
+			}
+		}
+		added, err := storeResult(tx, *res.Result)
+		if err != nil && failed == nil {
+			failed = err
+		}
+		if added {
+			stored++
+		}
+		inBatch++
+		if inBatch == storeBatchSize {
+			commit()
+		}
+	}
+	commit()
+	return stored, failed
+}
+
+// storeResult stores a result and its details, unless it's already
+// stored. If something goes wrong, nothing is stored
+func storeResult(tx *sql.Tx, res result.Result) (bool, error) {
+	if _, err := tx.Exec("SAVEPOINT result"); err != nil {
+		return false, err
+	}
+	added, err := storeResultDetails(tx, res)
+	if err != nil {
+		tx.Exec("ROLLBACK TO result")
+	}
+	tx.Exec("RELEASE result")
+	return added, err
+}
+
+func storeResultDetails(tx *sql.Tx, res result.Result) (bool, error) {
+	encoded, err := json.Marshal(res)
+	if err != nil {
+		return false, err
+	}
+	digest := sha256.Sum256(encoded)
+	base, _ := result.CsvRows("base", res)
+	row := base[0] // in the order of result.CsvBaseColumns
+	values := []any{row[0], row[1], res.GetTimeStamp().Unix(), row[3]}
+	for _, field := range []struct{ value, sqlType string }{
+		{row[9], "TEXT"}, {row[8], "TEXT"}, {row[5], "INTEGER"}, {row[4], "INTEGER"},
+	} {
+		value, err := storeValue(field.value, field.sqlType)
+		if err != nil {
+			return false, err
+		}
+		values = append(values, value)
+	}
+	var stored any // NULL if unknown
+	if base, ok := res.(interface{ GetStoreTimeStamp() time.Time }); ok && !base.GetStoreTimeStamp().IsZero() {
+		stored = base.GetStoreTimeStamp().Unix()
+	}
+	values = append(values, stored)
+
+	insert, err := tx.Exec(`INSERT OR IGNORE INTO results
+		(msm_id, prb_id, timestamp, type, dst_addr, dst_name, af, fw, stored_timestamp, digest, result)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		append(values, hex.EncodeToString(digest[:16]), string(encoded))...,
+	)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := insert.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	id, err := insert.LastInsertId()
+	if err != nil {
+		return false, err
+	}
+
+	for _, table := range storeDetailTables {
+		rows, err := result.CsvRows(table.schema, res)
+		if err != nil {
+			return false, err
+		}
+		for _, row := range rows {
+			values := []any{id}
+			for i, field := range row[len(result.CsvBaseColumns):] {
+				value, err := storeValue(field, table.columns[i].sqlType)
+				if err != nil {
+					return false, fmt.Errorf("%s.%s: %v", table.name, table.columns[i].name, err)
+				}
+				values = append(values, value)
+			}
+			if _, err := tx.Exec(table.insert, values...); err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// storeValue turns a CSV field into a value for a column of an SQL type;
+// empty fields are NULL
+func storeValue(field string, sqlType string) (any, error) {
+	if field == "" {
+		return nil, nil
+	}
+	switch sqlType {
+	case "INTEGER":
+		return strconv.ParseInt(field, 10, 64)
+	case "REAL":
+		return strconv.ParseFloat(field, 64)
+	case "BOOLEAN":
+		flag, err := strconv.ParseBool(field)
+		if err != nil || !flag {
+			return 0, err
+		}
+		return 1, nil
+	default:
+		return field, nil
+	}
+}
+
+// LatestTimeStamp returns the time of the newest result stored for a
+// measurement; false if there are none
+func (store *ResultStore) LatestTimeStamp(msmID uint) (time.Time, bool, error) {
+	var latest sql.NullInt64
+	err := store.db.QueryRow(`SELECT MAX(timestamp) FROM results WHERE msm_id = ?`, msmID).Scan(&latest)
+	if err != nil || !latest.Valid {
+		return time.Time{}, false, err
+	}
+	return time.Unix(latest.Int64, 0).UTC(), true, nil
+}
+
+// SyncLateness sets how late results can be uploaded by probes and still
+// be picked up by Sync; see DefaultSyncLateness
+func (store *ResultStore) SyncLateness(lateness time.Duration) {
+	store.lateness = lateness
+}
+
+// syncPoint returns the time up to which the API stored results of a
+// measurement, as far as the stored results tell; false if there are none.
+// Results without a stored_timestamp count with their timestamp
+func (store *ResultStore) syncPoint(msmID uint) (time.Time, bool, error) {
+	var point sql.NullInt64
+	err := store.db.QueryRow(`SELECT MAX(COALESCE(stored_timestamp, timestamp)) FROM results WHERE msm_id = ?`,
+		msmID).Scan(&point)
+	if err != nil || !point.Valid {
+		return time.Time{}, false, err
+	}
+	return time.Unix(point.Int64, 0).UTC(), true, nil
+}
+
+// Sync downloads the results of the measurement in the filter that were
+// stored by the API since the last sync, and stores them. The API can only
+// select results by their timestamp, so results measured up to the sync
+// lateness (see SyncLateness) before the last sync are asked for again,
+// in order to pick up late uploads; the ones that are already stored are
+// ignored. Other criteria of the filter (e.g. probes, stop time) apply as
+// well. It returns the number of results newly stored
+func (store *ResultStore) Sync(filter ResultsFilter, verbose bool) (uint, error) {
+	if filter.id == 0 {
+		return 0, fmt.Errorf("a measurement ID must be specified for syncing")
+	}
+	point, ok, err := store.syncPoint(filter.id)
+	if err != nil {
+		return 0, err
+	}
+	start := point.Add(-store.lateness)
+	if ok && (filter.start == nil || filter.start.Before(start)) {
+		filter.params = maps.Clone(filter.params)
+		filter.params.Del("start")
+		filter.FilterStart(start)
+	}
+
+	results := make(chan result.AsyncResult)
+	go filter.GetResults(verbose, results)
+	return store.Ingest(results)
+}
+
+// NewStoreFilter prepares a new filter for stored results
+func NewStoreFilter() StoreFilter {
+	return StoreFilter{}
+}
+
+// FilterStart filters for results at or after this timestamp
+func (filter *StoreFilter) FilterStart(t time.Time) {
+	filter.start = &t
+}
+
+// FilterStop filters for results at or before this timestamp
+func (filter *StoreFilter) FilterStop(t time.Time) {
+	filter.stop = &t
+}
+
+// FilterProbeIDs filters for results from one of these probes
+func (filter *StoreFilter) FilterProbeIDs(list []uint) {
+	filter.probes = list
+}
+
+// FilterMeasurementIDs filters for results of one of these measurements
+func (filter *StoreFilter) FilterMeasurementIDs(list []uint) {
+	filter.msms = list
+}
+
+// FilterTypes filters for results of one of these types, e.g. "ping"
+func (filter *StoreFilter) FilterTypes(list []string) {
+	filter.types = list
+}
+
+// Limit limits the number of results returned
+func (filter *StoreFilter) Limit(max uint) {
+	filter.limit = max
+}
+
+// GetResults returns the stored results matching the filter, ordered by
+// time, on a channel, which is closed at the end
+func (store *ResultStore) GetResults(filter StoreFilter, results chan result.AsyncResult) {
+	defer close(results)
+
+	where := make([]string, 0)
+	args := make([]any, 0)
+	if filter.start != nil {
+		where = append(where, "timestamp >= ?")
+		args = append(args, filter.start.Unix())
+	}
+	if filter.stop != nil {
+		where = append(where, "timestamp <= ?")
+		args = append(args, filter.stop.Unix())
+	}
+	for _, in := range []struct {
+		column string
+		values []any
+	}{
+		{"prb_id", storeArgs(filter.probes)},
+		{"msm_id", storeArgs(filter.msms)},
+		{"type", storeArgs(filter.types)},
+	} {
+		if len(in.values) > 0 {
+			where = append(where, fmt.Sprintf("%s IN (?%s)", in.column, strings.Repeat(", ?", len(in.values)-1)))
+			args = append(args, in.values...)
+		}
+	}
+
+	query := "SELECT type, result FROM results"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY timestamp, id"
+	if filter.limit != 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.limit)
+	}
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		results <- result.AsyncResult{Result: nil, Error: err}
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var typename, encoded string
+		if err := rows.Scan(&typename, &encoded); err != nil {
+			results <- result.AsyncResult{Result: nil, Error: err}
+			return
+		}
+		res, err := result.ParseWithTypeHint(encoded, typename)
+		if err != nil {
+			results <- result.AsyncResult{Result: nil, Error: err}
+			continue
+		}
+		results <- result.AsyncResult{Result: &res, Error: nil}
+	}
+	if err := rows.Err(); err != nil {
+		results <- result.AsyncResult{Result: nil, Error: err}
+	}
+}
+
+// storeArgs turns a list of values into query arguments
+func storeArgs[T any](list []T) []any {
+	args := make([]any, 0, len(list))
+	for _, item := range list {
+		args = append(args, item)
+	}
+	return args
+}
@@ -0,0 +1,219 @@
+/*
+  (C) 2022, 2023 Robert Kisteleki & RIPE NCC
+
+  See LICENSE file for the license.
+*/
+
+package goatapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robert-kisteleki/goatapi/result"
+)
+
+// open a store in a temporary directory
+func openTestStore(t *testing.T) *ResultStore {
+	t.Helper()
+	store, err := OpenResultStore(filepath.Join(t.TempDir(), "results.db"))
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// query the store and describe the results as "msm/probe/type" items
+func queryStore(t *testing.T, store *ResultStore, filter StoreFilter) string {
+	t.Helper()
+	results := make(chan result.AsyncResult)
+	go store.GetResults(filter, results)
+	items := make([]string, 0)
+	for res := range results {
+		if res.Error != nil {
+			t.Fatalf("error querying the store: %v", res.Error)
+		}
+		base, _ := result.CsvRows("base", *res.Result)
+		items = append(items, base[0][0]+"/"+base[0][1]+"/"+base[0][3])
+	}
+	return strings.Join(items, " ")
+}
+
+// Test storing and querying results
+func TestResultStore(t *testing.T) {
+	store := openTestStore(t)
+
+	filter := NewResultsFilter()
+	filter.FilterFile("testdata/mixed.txt")
+	results := make(chan result.AsyncResult)
+	go filter.GetResults(false, results)
+	stored, err := store.Ingest(results)
+	if err != nil {
+		t.Fatalf("error storing results: %v", err)
+	}
+	assertEqual(t, stored, uint(13), "number of results stored")
+
+	// storing the same results again is a no-op
+	results = make(chan result.AsyncResult)
+	go filter.GetResults(false, results)
+	stored, err = store.Ingest(results)
+	if err != nil {
+		t.Fatalf("error storing results again: %v", err)
+	}
+	assertEqual(t, stored, uint(0), "number of results stored again")
+
+	all := queryStore(t, store, NewStoreFilter())
+	assertEqual(t, strings.Count(all, " ")+1, 13, "number of results in the store")
+	assertEqual(t, strings.HasPrefix(all, "1002/21/ping 1003/22/ping 5002/21/traceroute"), true, "results are ordered by time")
+
+	query := NewStoreFilter()
+	query.FilterTypes([]string{"dns", "ntp"})
+	query.FilterProbeIDs([]uint{21, 23})
+	assertEqual(t, queryStore(t, store, query), "2002/21/dns 2004/23/dns 14002/21/ntp", "query by type and probe")
+
+	query = NewStoreFilter()
+	query.FilterMeasurementIDs([]uint{15001})
+	query.FilterStart(time.Unix(1700000910, 0))
+	assertEqual(t, queryStore(t, store, query), "15001/22/sslcert 15001/23/sslcert", "query by measurement and start")
+
+	query = NewStoreFilter()
+	query.FilterStop(time.Unix(1700000400, 0))
+	query.Limit(1)
+	assertEqual(t, queryStore(t, store, query), "1002/21/ping", "query by stop with limit")
+
+	// unknown types come back as they were
+	query = NewStoreFilter()
+	query.FilterTypes([]string{"future"})
+	results = make(chan result.AsyncResult)
+	go store.GetResults(query, results)
+	for res := range results {
+		generic, ok := (*res.Result).(*result.GenericResult)
+		if !ok {
+			t.Fatalf("unknown type came back as %T", *res.Result)
+		}
+		assertEqual(t, string(generic.Unknown["other"]), "true", "unknown field kept")
+	}
+
+	// details are in the per-type tables
+	var count int
+	var rtt float64
+	store.DB().QueryRow(`SELECT COUNT(*), SUM(rtt) FROM ping_reply`).Scan(&count, &rtt)
+	assertEqual(t, count, 3, "ping replies stored")
+	assertEqual(t, rtt, 31.5, "ping reply RTTs stored")
+	var data string
+	store.DB().QueryRow(`SELECT COUNT(*), MIN(data) FROM dns_answer a JOIN results r ON r.id = a.result_id
+		WHERE r.prb_id = 22 AND a.rrtype = 'A'`).Scan(&count, &data)
+	assertEqual(t, count, 2, "DNS answers stored")
+	assertEqual(t, data, "93.184.216.34", "DNS answer data stored")
+	store.DB().QueryRow(`SELECT COUNT(*) FROM traceroute_hop WHERE timeout`).Scan(&count)
+	assertEqual(t, count, 4, "traceroute hop responses stored")
+	var types string
+	store.DB().QueryRow(`SELECT typeof(reply) || typeof(rtt) || typeof(reply_src) || typeof(duplicate)
+		FROM ping_reply LIMIT 1`).Scan(&types)
+	assertEqual(t, types, "integerrealtextinteger", "types of detail columns")
+}
+
+// Test if columns missing from an existing database are added
+func TestResultStoreColumns(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "results.db")
+	store, err := OpenResultStore(name)
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	// as if it was made before the "duplicate" column existed
+	_, err = store.DB().Exec(`ALTER TABLE ping_reply DROP COLUMN duplicate`)
+	store.Close()
+	if err != nil {
+		t.Fatalf("error dropping a column: %v", err)
+	}
+
+	store, err = OpenResultStore(name)
+	if err != nil {
+		t.Fatalf("error opening store again: %v", err)
+	}
+	defer store.Close()
+	filter := NewResultsFilter()
+	filter.FilterFile("testdata/ping.txt")
+	results := make(chan result.AsyncResult)
+	go filter.GetResults(false, results)
+	stored, err := store.Ingest(results)
+	if err != nil {
+		t.Fatalf("error storing results: %v", err)
+	}
+	assertEqual(t, stored, uint(3), "number of results stored")
+	var count int
+	store.DB().QueryRow(`SELECT COUNT(*) FROM ping_reply WHERE NOT duplicate`).Scan(&count)
+	assertEqual(t, count, 8, "ping replies with the added column")
+}
+
+// Test syncing only newer results of a measurement from the API
+func TestResultStoreSync(t *testing.T) {
+	pings, err := os.ReadFile("testdata/ping.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(pings)), "\n")
+	// a result uploaded late: measured before the newest one of the
+	// first sync, stored by the API after it
+	late := strings.Replace(lines[0], `"prb_id":11,"timestamp":1700000000`, `"prb_id":13,"timestamp":1700000005`, 1)
+	late = strings.Replace(late, `"stored_timestamp":1700000002`, `"stored_timestamp":1700000300`, 1)
+	lines = append(lines, late)
+	available := 2
+	starts := make([]string, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/measurements/1001/results/" {
+			t.Errorf("unexpected request: %s", r.URL.Path)
+		}
+		start, _ := strconv.ParseInt(r.URL.Query().Get("start"), 10, 64)
+		starts = append(starts, r.URL.Query().Get("start"))
+		for _, line := range lines[:available] {
+			res, _ := result.Parse(line)
+			if res.GetTimeStamp().Unix() >= start {
+				fmt.Fprintln(w, line)
+			}
+		}
+	}))
+	defer server.Close()
+	oldbase := apiBaseURL
+	SetAPIBase(server.URL + "/")
+	defer SetAPIBase(oldbase)
+
+	store := openTestStore(t)
+	store.SyncLateness(time.Hour)
+	filter := NewResultsFilter()
+	filter.FilterID(1001)
+
+	stored, err := store.Sync(filter, false)
+	if err != nil {
+		t.Fatalf("error syncing: %v", err)
+	}
+	assertEqual(t, stored, uint(2), "results stored by the first sync")
+	latest, ok, _ := store.LatestTimeStamp(1001)
+	assertEqual(t, ok, true, "there is a latest result")
+	assertEqual(t, latest.Unix(), int64(1700000010), "latest result stored")
+
+	available = 4
+	stored, err = store.Sync(filter, false)
+	if err != nil {
+		t.Fatalf("error syncing again: %v", err)
+	}
+	assertEqual(t, stored, uint(2), "results stored by the second sync")
+	// an hour before the newest stored_timestamp of the first sync
+	assertEqual(t, strings.Join(starts, ","), ",1699996412", "start times asked for")
+	assertEqual(t, queryStore(t, store, NewStoreFilter()), "1001/11/ping 1001/13/ping 1001/12/ping 1001/11/ping", "late result stored")
+	_, ok, _ = store.LatestTimeStamp(1002)
+	assertEqual(t, ok, false, "no results for another measurement")
+
+	if _, err := store.Sync(NewResultsFilter(), false); err == nil {
+		t.Errorf("syncing without a measurement ID should fail")
+	}
+}